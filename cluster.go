@@ -0,0 +1,36 @@
+package evacuator
+
+const (
+	// ClusterModeStandalone detects and handles termination on the same node
+	ClusterModeStandalone = "standalone"
+
+	// ClusterModeAgent only detects termination and publishes it to the controller
+	ClusterModeAgent = "agent"
+
+	// ClusterModeController handles the termination notices published by the agents
+	ClusterModeController = "controller"
+)
+
+const (
+	// ClusterPublishAnnotation publishes notices as an annotation on the node
+	ClusterPublishAnnotation = "annotation"
+
+	// ClusterPublishHttp publishes notices to the controller http endpoint
+	ClusterPublishHttp = "http"
+)
+
+const (
+	// ClusterNoticeAnnotation holds the termination event published by the agent as JSON
+	ClusterNoticeAnnotation = "evacuator.io/termination-notice"
+
+	// ClusterAcceptedAnnotation is set by the controller once it started handling
+	// the notice, so a new leader does not handle it again
+	ClusterAcceptedAnnotation = "evacuator.io/termination-accepted"
+
+	// ClusterEventsPath is the controller endpoint agents post events to
+	ClusterEventsPath = "/v1/events"
+
+	// ClusterIdempotencyKeyHeader carries the event key of published notices,
+	// a retried request has the same key
+	ClusterIdempotencyKeyHeader = "Idempotency-Key"
+)
@@ -0,0 +1,180 @@
+package evacuator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClusterAgentHandler publishes termination notices to the cluster controller
+// instead of handling them on the node, so the node needs no drain permissions
+type ClusterAgentHandler struct {
+	clientset  kubernetes.Interface
+	httpClient *http.Client
+	config     ClusterAgentHandlerConfig
+}
+
+type ClusterAgentHandlerConfig struct {
+	Logger        *slog.Logger
+	Name          string
+	Publish       string
+	ControllerUrl string
+	Token         string
+	InCluster     bool
+	Kubeconfig    string
+
+	// DryRun logs the notice without publishing it
+	DryRun bool
+}
+
+// ClusterAgentRetryInterval is the delay between attempts to reach the controller
+const ClusterAgentRetryInterval = time.Second
+
+func NewClusterAgentHandler(config *ClusterAgentHandlerConfig) (*ClusterAgentHandler, error) {
+	h := &ClusterAgentHandler{
+		config: *config,
+	}
+
+	switch config.Publish {
+	case ClusterPublishAnnotation:
+		clientset, err := newKubernetesClientset(config.InCluster, config.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		h.clientset = clientset
+	case ClusterPublishHttp:
+		h.httpClient = &http.Client{Timeout: 5 * time.Second}
+	default:
+		return nil, fmt.Errorf("unknown publish method: %s", config.Publish)
+	}
+
+	return h, nil
+}
+
+func (h *ClusterAgentHandler) Name() string {
+	if h.config.Name != "" {
+		return h.config.Name
+	}
+	return ClusterModeAgent
+}
+
+func (h *ClusterAgentHandler) HandleTermination(ctx context.Context, event TerminationEvent) error {
+	h.config.Logger.Info("publishing termination notice to cluster controller", "node", event.Hostname, "publish", h.config.Publish, "handler", h.Name())
+
+	if err := h.publish(ctx, event); err != nil {
+		return err
+	}
+
+	h.config.Logger.Info("termination notice published", "node", event.Hostname, "handler", h.Name())
+	return nil
+}
+
+// HandleCancellation withdraws the published notice
+func (h *ClusterAgentHandler) HandleCancellation(ctx context.Context, event TerminationEvent) error {
+	h.config.Logger.Info("withdrawing termination notice from cluster controller", "node", event.Hostname, "publish", h.config.Publish, "handler", h.Name())
+	return h.publish(ctx, event)
+}
+
+func (h *ClusterAgentHandler) publish(ctx context.Context, event TerminationEvent) error {
+	if h.config.DryRun {
+		h.config.Logger.Info("termination notice would be published", "node", event.Hostname, "cancelled", event.Cancelled, "dry_run", true, "handler", h.Name())
+		return nil
+	}
+
+	if h.config.Publish == ClusterPublishAnnotation {
+		return h.publishAnnotation(ctx, event)
+	}
+
+	// the controller may be failing over, keep trying until the deadline
+	for {
+		err := h.publishHttp(ctx, event)
+		if err == nil {
+			return nil
+		}
+
+		h.config.Logger.Warn("failed to reach cluster controller, retrying", "error", err.Error(), "handler", h.Name())
+
+		select {
+		case <-time.After(ClusterAgentRetryInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("failed to publish termination notice: %w", err)
+		}
+	}
+}
+
+// publishAnnotation sets the notice annotation on the node, or removes it when
+// the notice is cancelled. A new notice clears the accepted mark so it is
+// handled, a cancelled one leaves it to the controller, which withdraws the
+// accepted notice even after a failover.
+func (h *ClusterAgentHandler) publishAnnotation(ctx context.Context, event TerminationEvent) error {
+	// a nil value removes the annotations with a merge patch
+	annotations := map[string]any{
+		ClusterNoticeAnnotation: nil,
+	}
+	if !event.Cancelled {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		annotations[ClusterNoticeAnnotation] = string(body)
+		annotations[ClusterAcceptedAnnotation] = nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = h.clientset.CoreV1().Nodes().Patch(ctx, event.Hostname, types.MergePatchType, patch, v1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate kubernetes node: %w", err)
+	}
+
+	return nil
+}
+
+func (h *ClusterAgentHandler) publishHttp(ctx context.Context, event TerminationEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(h.config.ControllerUrl, "/")+ClusterEventsPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if event.Key != "" {
+		req.Header.Set(ClusterIdempotencyKeyHeader, event.Key)
+	}
+	if h.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.config.Token)
+	}
+
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("got %d as http request: %s", res.StatusCode, strings.TrimSpace(string(resBody)))
+	}
+
+	return nil
+}
@@ -0,0 +1,360 @@
+package evacuator
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// ClusterController receives the termination notices published by the node
+// agents, through node annotations or its http endpoint, and emits them as
+// termination events. Only the replica holding the Lease emits events, so it
+// takes the place of the provider in controller mode.
+type ClusterController struct {
+	clientset kubernetes.Interface
+	config    ClusterControllerProviderConfig
+
+	mu      sync.Mutex
+	leading bool
+	events  chan<- TerminationEvent
+
+	// notices being handled by this replica, per node
+	notices map[string]TerminationEvent
+}
+
+type ClusterControllerProviderConfig struct {
+	Logger         *slog.Logger
+	InCluster      bool
+	Kubeconfig     string
+	Token          string
+	ListenAddress  string
+	LeaseName      string
+	LeaseNamespace string
+	Identity       string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+}
+
+const ProviderClusterController ProviderName = "controller"
+
+func NewClusterController(config *ClusterControllerProviderConfig) (*ClusterController, error) {
+
+	clientset, err := newKubernetesClientset(config.InCluster, config.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname for lease identity: %w", err)
+		}
+		config.Identity = hostname
+	}
+
+	return &ClusterController{
+		clientset: clientset,
+		config:    *config,
+		notices:   make(map[string]TerminationEvent),
+	}, nil
+}
+
+func (c *ClusterController) Name() ProviderName {
+	return ProviderClusterController
+}
+
+func (c *ClusterController) IsSupported(ctx context.Context) bool {
+	if _, err := c.clientset.Discovery().ServerVersion(); err != nil {
+		c.config.Logger.Error("failed to reach kubernetes api", "error", err.Error(), "provider", c.Name())
+		return false
+	}
+	return true
+}
+
+func (c *ClusterController) StartMonitoring(ctx context.Context, e chan<- TerminationEvent) {
+	c.mu.Lock()
+	c.events = e
+	c.mu.Unlock()
+
+	if c.config.ListenAddress != "" {
+		go c.serve(ctx)
+	}
+
+	go c.runLeaderElection(ctx)
+
+	c.config.Logger.Info("cluster controller started", "identity", c.config.Identity, "lease", c.config.LeaseNamespace+"/"+c.config.LeaseName, "provider", c.Name())
+}
+
+// runLeaderElection campaigns for the Lease until ctx is done, a replica that
+// loses the Lease campaigns again
+func (c *ClusterController) runLeaderElection(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: v1.ObjectMeta{
+			Name:      c.config.LeaseName,
+			Namespace: c.config.LeaseNamespace,
+		},
+		Client: c.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: c.config.Identity,
+		},
+	}
+
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			Name:            c.config.LeaseName,
+			LeaseDuration:   c.config.LeaseDuration,
+			RenewDeadline:   c.config.RenewDeadline,
+			RetryPeriod:     c.config.RetryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: c.lead,
+				OnStoppedLeading: func() {
+					c.config.Logger.Info("cluster controller stopped leading", "identity", c.config.Identity, "provider", c.Name())
+				},
+				OnNewLeader: func(identity string) {
+					if identity != c.config.Identity {
+						c.config.Logger.Info("cluster controller leader elected", "leader", identity, "provider", c.Name())
+					}
+				},
+			},
+		})
+	}
+}
+
+// lead watches the node annotations while this replica holds the Lease
+func (c *ClusterController) lead(ctx context.Context) {
+	c.config.Logger.Info("cluster controller started leading", "identity", c.config.Identity, "provider", c.Name())
+
+	c.mu.Lock()
+	c.leading = true
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.leading = false
+		c.notices = make(map[string]TerminationEvent)
+		c.mu.Unlock()
+	}()
+
+	factory := informers.NewSharedInformerFactory(c.clientset, 0)
+	nodeInformer := factory.Core().V1().Nodes().Informer()
+
+	nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if node, ok := obj.(*corev1.Node); ok {
+				c.reconcileNode(ctx, node)
+			}
+		},
+		UpdateFunc: func(_, obj any) {
+			if node, ok := obj.(*corev1.Node); ok {
+				c.reconcileNode(ctx, node)
+			}
+		},
+		// the node of a terminated instance is removed, so is its notice
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*corev1.Node); ok {
+				c.mu.Lock()
+				delete(c.notices, node.Name)
+				c.mu.Unlock()
+			}
+		},
+	})
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	<-ctx.Done()
+	factory.Shutdown()
+}
+
+// reconcileNode emits the notice annotated on the node once, and a cancelled
+// event when the annotation is removed after the notice was accepted
+func (c *ClusterController) reconcileNode(ctx context.Context, node *corev1.Node) {
+	raw, annotated := node.Annotations[ClusterNoticeAnnotation]
+
+	if !annotated {
+		c.mu.Lock()
+		notice, handling := c.notices[node.Name]
+		c.mu.Unlock()
+
+		// the accepted annotation outlives a failover, a notice handled by a
+		// previous leader is only known by its node
+		_, accepted := node.Annotations[ClusterAcceptedAnnotation]
+		if !handling && !accepted {
+			return
+		}
+		if !handling {
+			notice = TerminationEvent{Hostname: node.Name}
+		}
+
+		if accepted {
+			if err := c.clearAccepted(ctx, node.Name); err != nil {
+				c.config.Logger.Warn("failed to clear accepted termination notice", "node", node.Name, "error", err.Error(), "provider", c.Name())
+			}
+		}
+
+		notice.Cancelled = true
+		c.emit(ctx, notice)
+		return
+	}
+
+	// accepted by a previous leader, handling it again would repeat notifications
+	if _, accepted := node.Annotations[ClusterAcceptedAnnotation]; accepted {
+		return
+	}
+
+	var event TerminationEvent
+	if err := json.Unmarshal([]byte(raw), &event); err != nil {
+		c.config.Logger.Error("invalid termination notice annotation", "node", node.Name, "error", err.Error(), "provider", c.Name())
+		return
+	}
+
+	// the node is the one annotated, whatever hostname the agent saw
+	event.Hostname = node.Name
+
+	if err := c.accept(ctx, node.Name); err != nil {
+		c.config.Logger.Error("failed to accept termination notice", "node", node.Name, "error", err.Error(), "provider", c.Name())
+		return
+	}
+
+	c.emit(ctx, event)
+}
+
+// accept marks the notice of the node as handled by this replica
+func (c *ClusterController) accept(ctx context.Context, nodeName string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				ClusterAcceptedAnnotation: c.config.Identity + "/" + time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, v1.PatchOptions{})
+	return err
+}
+
+// clearAccepted removes the mark of a withdrawn notice, once its cancellation
+// is emitted there is nothing left to hand over to a new leader
+func (c *ClusterController) clearAccepted(ctx context.Context, nodeName string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				ClusterAcceptedAnnotation: nil,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, v1.PatchOptions{})
+	return err
+}
+
+// emit forwards the event unless it is already being handled
+func (c *ClusterController) emit(ctx context.Context, event TerminationEvent) {
+	c.mu.Lock()
+	current, handling := c.notices[event.Hostname]
+	if event.Cancelled {
+		delete(c.notices, event.Hostname)
+	} else {
+		if handling && current.InstanceID == event.InstanceID && current.Reason == event.Reason {
+			c.mu.Unlock()
+			return
+		}
+		c.notices[event.Hostname] = event
+	}
+	events := c.events
+	c.mu.Unlock()
+
+	c.config.Logger.Info("termination notice received from agent", "node", event.Hostname, "reason", event.Reason, "cancelled", event.Cancelled, "provider", c.Name())
+
+	select {
+	case events <- event:
+	case <-ctx.Done():
+	}
+}
+
+// serve accepts notices over http on every replica, only the leader accepts them
+func (c *ClusterController) serve(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+ClusterEventsPath, func(w http.ResponseWriter, r *http.Request) {
+		// the token is required with a listen address, an empty one is never accepted
+		expected := "Bearer " + c.config.Token
+		if c.config.Token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		c.mu.Lock()
+		leading := c.leading
+		c.mu.Unlock()
+
+		// agents retry, the service may route the next attempt to the leader
+		if !leading {
+			http.Error(w, "not the leader", http.StatusServiceUnavailable)
+			return
+		}
+
+		var event TerminationEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			http.Error(w, fmt.Sprintf("invalid event: %v", err), http.StatusBadRequest)
+			return
+		}
+		if event.Hostname == "" {
+			http.Error(w, "hostname must be set", http.StatusBadRequest)
+			return
+		}
+		if event.Key == "" {
+			event.Key = r.Header.Get(ClusterIdempotencyKeyHeader)
+		}
+
+		c.emit(ctx, event)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := &http.Server{
+		Addr:              c.config.ListenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	c.config.Logger.Info("cluster controller listening", "address", c.config.ListenAddress, "provider", c.Name())
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		c.config.Logger.Error("cluster controller http server stopped", "error", err.Error(), "provider", c.Name())
+	}
+}
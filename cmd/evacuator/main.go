@@ -2,279 +2,357 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
 	"syscall"
-	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/rahadiangg/evacuator"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
-// HandlerResult represents the result of processing a termination event by a handler
-type HandlerResult struct {
-	HandlerName string
-	Error       error
-	ProcessedAt time.Time
-}
+const usage = `Usage: evacuator [command] [flags]
 
-// Application configuration constants
-const (
+Commands:
+  run               Watch the provider and handle termination events (default)
+  simulate          Push a synthetic termination event through the handlers
+  recover           Undo the handling of a notice for an instance that survived it
+  status            Print the provider, handlers and reload status of a running daemon
+  validate-config   Load and validate configuration files
+  schema            Print the JSON Schema of the config file
+  detect            Print the provider that would be used
 
-	// Graceful shutdown timeout - maximum time to wait for goroutines to finish
-	// Reduced to 10 seconds since handlers should already be complete
-	// This is just for final cleanup before termination deadline
-	GracefulShutdownTimeout = 10 * time.Second
-)
+Run 'evacuator <command> -h' for the flags of a command.
+`
 
 func main() {
-	// Parse command-line flags
-	var configPath = flag.String("config", "", "path to config file (optional)")
-	flag.Parse()
-
-	v := viper.New()
-	config, err := evacuator.LoadConfig(*configPath, v)
-	if err != nil {
-		fmt.Printf("failed to load config file: %v", err)
-		os.Exit(1)
-	}
+	args := os.Args[1:]
 
-	// Set the global configuration
-	evacuator.SetGlobalConfig(config)
-
-	logger, err := setupLogger()
-	if err != nil {
-		fmt.Printf("failed to setup logger: %v", err)
-		os.Exit(1)
-	}
-
-	// Log the configuration source
-	if *configPath != "" {
-		if v.ConfigFileUsed() != "" {
-			logger.Info("loaded configuration from file", "file", v.ConfigFileUsed())
-		} else {
-			logger.Info("config file specified but not found, using environment variables and defaults", "file", *configPath)
-		}
-	} else {
-		logger.Info("no config file specified, using environment variables and defaults")
+	// without a command, or with only flags, behave as before subcommands existed
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	// Create default HTTP client with reasonable timeout
-	providerHttpClient := &http.Client{
-		Timeout: config.Provider.RequestTimeout,
+	var err error
+	switch command {
+	case "run":
+		err = runCommand(args)
+	case "simulate":
+		err = simulateCommand(args)
+	case "recover":
+		err = recoverCommand(args)
+	case "status":
+		err = statusCommand(args)
+	case "validate-config":
+		err = validateConfigCommand(args)
+	case "schema":
+		err = schemaCommand(args)
+	case "detect":
+		err = detectCommand(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Printf("unknown command: %s\n\n%s", command, usage)
+		os.Exit(2)
 	}
 
-	dummyDetectionWait, err := time.ParseDuration(config.Provider.Dummy.DetectionWait)
 	if err != nil {
-		logger.Error("failed to parse dummy provider detection wait time", "error", err)
+		fmt.Printf("%s: %v\n", command, err)
 		os.Exit(1)
 	}
+}
 
-	// Register all available providers
-	providers := []evacuator.Provider{
-		evacuator.NewAwsProvider(providerHttpClient, logger),
-		evacuator.NewAlicloudProvider(providerHttpClient, logger),
-		evacuator.NewTencentProvider(providerHttpClient, logger),
-		evacuator.NewGcpProvider(providerHttpClient, logger),
-		evacuator.NewHuaweiProvider(providerHttpClient, logger),
-	}
+// runCommand watches the detected provider and handles termination events until
+// SIGINT or SIGTERM is received, SIGHUP reloads the configuration
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	var configPath = flags.String("config", "", "path to config file (optional)")
+	var dryRun = flags.Bool("dry-run", false, "report what handlers would do without executing it")
+	flags.Parse(args)
 
-	if config.Provider.Name == "dummy" {
-		providers = append(providers, evacuator.NewDummyProvider(logger, dummyDetectionWait))
+	config, logger, err := setup(*configPath, *dryRun)
+	if err != nil {
+		return err
 	}
 
-	// Register all configured handlers
-	handlerRegistry := evacuator.NewHandlerRegistry(logger)
-	handlers, err := handlerRegistry.RegisterHandlers()
+	// Stop plugin processes last, handlers may still be using them before
+	defer evacuator.CleanupPlugins()
+
+	e, err := evacuator.New(
+		evacuator.WithConfig(config),
+		evacuator.WithLogger(logger),
+		evacuator.WithConfigLoader(func() (*evacuator.Config, error) {
+			config, _, err := loadConfig(*configPath, *dryRun)
+			return config, err
+		}),
+	)
 	if err != nil {
-		logger.Error("failed to register handlers", "error", err)
-		os.Exit(1)
+		return err
 	}
 
 	// Create root context for coordinated shutdown
 	rootCtx, rootCancel := context.WithCancel(context.Background())
 	defer rootCancel()
 
-	// Detect the current cloud provider environment
-	provider := DetectProvider(rootCtx, providers, logger)
-	if provider == nil {
-		logger.Error("no supported provider detected")
-		os.Exit(1)
-	}
-
-	// Create channel for termination events from provider
-	terminationEvent := make(chan evacuator.TerminationEvent)
-	var wg sync.WaitGroup
-
-	// Start provider monitoring in background goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		provider.StartMonitoring(rootCtx, terminationEvent)
-	}()
-
 	// Setup signal handling for graceful shutdown
 	shutdownSignal := make(chan os.Signal, 1)
 	signal.Notify(shutdownSignal, syscall.SIGINT, syscall.SIGTERM)
 
-	// Start event broadcaster to distribute events to all handlers
-	wg.Add(1)
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+
 	go func() {
-		defer wg.Done()
-		broadcastTerminationEvents(rootCtx, terminationEvent, handlers, logger)
+		for {
+			select {
+			case <-reloadSignal:
+				logger.Info("reload signal received, reloading configuration")
+				e.Reload()
+			case <-shutdownSignal:
+				logger.Info("shutdown signal received, stopping gracefully...")
+				rootCancel()
+				return
+			case <-rootCtx.Done():
+				return
+			}
+		}
 	}()
 
-	// Wait for shutdown signal (SIGINT or SIGTERM)
-	<-shutdownSignal
-	logger.Info("shutdown signal received, stopping gracefully...")
+	if config.Reload.Watch {
+		watchConfig(*configPath, e, logger)
+	}
 
-	// Cancel context to signal all goroutines to stop
-	rootCancel()
+	// Accept simulated events from `evacuator simulate --socket`
+	var wg sync.WaitGroup
+	if config.Control.Socket != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := serveControlSocket(rootCtx, config.Control.Socket, config, e, logger); err != nil {
+				logger.Error("control socket stopped", "socket", config.Control.Socket, "error", err.Error())
+			}
+		}()
+	}
 
-	// Wait for all goroutines to finish with timeout protection
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+	err = e.Run(rootCtx)
+
+	rootCancel()
+	wg.Wait()
 
-	// Either all goroutines finish or timeout after configured duration
-	select {
-	case <-done:
-		logger.Info("all goroutines stopped successfully")
-	case <-time.After(GracefulShutdownTimeout):
-		logger.Warn("timeout waiting for goroutines to stop")
+	if err != nil {
+		return err
 	}
 
 	logger.Info("shutdown complete")
+	return nil
 }
 
-// DetectProvider automatically detects which cloud provider is currently running.
-// It first checks if a specific provider is configured, then falls back to auto-detection
-// if auto_detect is enabled. Uses global configuration.
-func DetectProvider(ctx context.Context, providers []evacuator.Provider, logger *slog.Logger) evacuator.Provider {
-	providerConfig := evacuator.GetProviderConfig()
-
-	// when specified provider configured use it
-	if providerConfig.Name != "" {
-		for _, p := range providers {
-			if strings.EqualFold(string(p.Name()), providerConfig.Name) {
-				if p.IsSupported(ctx) {
-					logger.Info("configured provider detected and supported", "provider", p.Name())
-					return p
-				} else {
-					logger.Warn("configured provider not supported in current environment", "provider", p.Name())
-					return nil
-				}
-			}
+// validateConfigCommand loads every configuration file the same way run does
+// and reports all their errors and warnings, so config files can be checked in CI
+func validateConfigCommand(args []string) error {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	var configPath = flags.String("config", "", "path to config file (optional), more files can follow the flags")
+	var printConfig = flags.Bool("print", false, "print the resolved configuration with secrets redacted")
+	var strict = flags.Bool("strict", false, "treat warnings as errors")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if *configPath != "" {
+		paths = append([]string{*configPath}, paths...)
+	}
+	if len(paths) == 0 {
+		// environment variables and defaults only
+		paths = []string{""}
+	}
+	if *printConfig && len(paths) > 1 {
+		return fmt.Errorf("-print takes a single config file")
+	}
+
+	invalid := 0
+	for _, path := range paths {
+		if !validateConfigFile(path, *printConfig, *strict) {
+			invalid++
 		}
-		logger.Error("configured provider not found", "provider", providerConfig.Name)
-		return nil
 	}
 
-	// If auto-detection is disabled and no provider is specified, return nil
-	if !providerConfig.AutoDetect {
-		logger.Error("auto-detection disabled and no provider specified")
-		return nil
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d configurations are invalid", invalid, len(paths))
+	}
+	return nil
+}
+
+// validateConfigFile prints the errors and warnings of one configuration and
+// reports whether it is valid
+func validateConfigFile(path string, printConfig bool, strict bool) bool {
+	name := path
+	if name == "" {
+		name = "environment"
+	}
+
+	v := viper.New()
+	config, err := evacuator.LoadConfig(path, v)
+
+	var warnings []evacuator.ConfigIssue
+	var validationErr *evacuator.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		for _, issue := range validationErr.Errors {
+			fmt.Printf("%s: error: %s\n", name, issue)
+		}
+		warnings = validationErr.Warnings
+	case err != nil:
+		fmt.Printf("%s: error: %v\n", name, err)
+		return false
+	default:
+		warnings = config.Warnings
+	}
+
+	for _, issue := range warnings {
+		fmt.Printf("%s: warning: %s\n", name, issue)
 	}
 
-	// Auto-detect provider
-	logger.Info("auto-detecting cloud provider")
-	for _, p := range providers {
-		if p.IsSupported(ctx) {
-			logger.Info("provider auto-detected", "provider", p.Name())
-			return p
+	if err != nil || (strict && len(warnings) > 0) {
+		return false
+	}
+
+	if printConfig {
+		dump, err := config.Dump()
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", name, err)
+			return false
 		}
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		encoder.Encode(dump)
+		return true
+	}
+
+	if path != "" && v.ConfigFileUsed() == "" {
+		fmt.Printf("%s: config file not found, environment variables and defaults are valid\n", name)
+		return true
+	}
+
+	fmt.Printf("%s: configuration is valid\n", name)
+	return true
+}
+
+// schemaCommand prints the JSON Schema of the config file
+func schemaCommand(args []string) error {
+	flags := flag.NewFlagSet("schema", flag.ExitOnError)
+	flags.Parse(args)
+
+	out, err := json.MarshalIndent(evacuator.ConfigSchema(), "", "  ")
+	if err != nil {
+		return err
 	}
 
-	logger.Debug("no supported provider detected during auto-detection")
+	fmt.Println(string(out))
 	return nil
 }
 
-// broadcastTerminationEvents distributes termination events to all handlers.
-// This function processes each event through all handlers sequentially and collects results.
-func broadcastTerminationEvents(ctx context.Context, terminationEvent <-chan evacuator.TerminationEvent, handlers []evacuator.Handler, logger *slog.Logger) {
+// detectCommand prints the provider DetectProvider would choose in the current environment
+func detectCommand(args []string) error {
+	flags := flag.NewFlagSet("detect", flag.ExitOnError)
+	var configPath = flags.String("config", "", "path to config file (optional)")
+	flags.Parse(args)
 
-	config := evacuator.GetGlobalConfig()
+	config, logger, err := setup(*configPath, false)
+	if err != nil {
+		return err
+	}
 
-	for {
-		select {
-		case event := <-terminationEvent:
-			logger.Info("termination event received, processing through all handlers")
+	providers, err := evacuator.NewProviders(config, logger)
+	if err != nil {
+		return err
+	}
 
-			// Process event through all handlers and collect results
-			var handlerWg sync.WaitGroup
-			results := make(chan HandlerResult, len(handlers))
+	provider := evacuator.DetectProvider(context.Background(), providers, config.Provider, logger)
+	if provider == nil {
+		return fmt.Errorf("no supported provider detected")
+	}
 
-			for _, handler := range handlers {
-				handlerWg.Add(1)
-				go func(h evacuator.Handler) {
-					defer handlerWg.Done()
+	fmt.Println(provider.Name())
+	return nil
+}
 
-					handlerCtx, cancel := context.WithTimeout(ctx, config.Handler.ProcessingTimeout)
-					defer cancel()
+// watchConfig reloads the configuration whenever the config file changes
+func watchConfig(configPath string, e *evacuator.Evacuator, logger *slog.Logger) {
+	if configPath == "" {
+		logger.Warn("reload.watch is enabled without a config file, only SIGHUP reloads")
+		return
+	}
 
-					logger.Debug("processing termination event with handler", "handler", h.Name())
+	v := viper.New()
+	v.SetConfigFile(configPath)
+	v.OnConfigChange(func(event fsnotify.Event) {
+		logger.Info("config file changed, reloading configuration", "file", event.Name)
+		e.Reload()
+	})
+	v.WatchConfig()
+
+	logger.Info("watching config file for changes", "file", configPath)
+}
 
-					// if node.name configured, use it as hostname
-					if config.NodeName != "" {
-						event.Hostname = config.NodeName
-					}
+// loadConfig loads the configuration the way every command does
+func loadConfig(configPath string, dryRun bool) (*evacuator.Config, *viper.Viper, error) {
+	v := viper.New()
+	config, err := evacuator.LoadConfig(configPath, v)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config file: %w", err)
+	}
 
-					err := h.HandleTermination(handlerCtx, event)
-					results <- HandlerResult{
-						HandlerName: h.Name(),
-						Error:       err,
-						ProcessedAt: time.Now(),
-					}
-				}(handler)
-			}
+	// The flag only enables dry run, it never turns off a configured one
+	if dryRun {
+		config.Handler.DryRun = true
+	}
 
-			// Wait for all handlers to complete and collect results
-			go func() {
-				handlerWg.Wait()
-				close(results)
-			}()
-
-			// Process results
-			successCount := 0
-			for result := range results {
-				if result.Error != nil {
-					logger.Error("handler failed to process termination event",
-						"handler", result.HandlerName,
-						"error", result.Error.Error(),
-						"processed_at", result.ProcessedAt)
-				} else {
-					logger.Info("handler successfully processed termination event",
-						"handler", result.HandlerName,
-						"processed_at", result.ProcessedAt)
-					successCount++
-				}
-			}
+	return config, v, nil
+}
 
-			logger.Info("termination event processing completed",
-				"total_handlers", len(handlers),
-				"successful_handlers", successCount,
-				"failed_handlers", len(handlers)-successCount)
+// setup loads the configuration and creates the logger
+func setup(configPath string, dryRun bool) (*evacuator.Config, *slog.Logger, error) {
+	config, v, err := loadConfig(configPath, dryRun)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		case <-ctx.Done():
-			logger.Debug("termination event broadcaster stopping")
-			return
+	logger, err := setupLogger(config.Log)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to setup logger: %w", err)
+	}
+
+	// Log the configuration source
+	if configPath != "" {
+		if v.ConfigFileUsed() != "" {
+			logger.Info("loaded configuration from file", "file", v.ConfigFileUsed())
+		} else {
+			logger.Info("config file specified but not found, using environment variables and defaults", "file", configPath)
 		}
+	} else {
+		logger.Info("no config file specified, using environment variables and defaults")
+	}
+
+	if config.Handler.DryRun {
+		logger.Warn("dry run enabled, handlers only report what they would do")
 	}
+
+	// Config logs itself with the secrets redacted
+	logger.Debug("effective configuration", "config", config)
+
+	return config, logger, nil
 }
 
-func setupLogger() (*slog.Logger, error) {
+func setupLogger(config evacuator.LogConfig) (*slog.Logger, error) {
 	var logLeveler slog.Level
 
-	config := evacuator.GetLogConfig()
-
 	switch config.Level {
 	case "debug":
 		logLeveler = slog.LevelDebug
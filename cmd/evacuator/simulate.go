@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rahadiangg/evacuator"
+)
+
+const (
+	// control api host, ignored when dialing the unix socket
+	ControlApiBaseUrl = "http://evacuator"
+
+	ControlSimulatePath = "/v1/simulate"
+	ControlRecoverPath  = "/v1/recover"
+	ControlStatusPath   = "/v1/status"
+)
+
+// simulateRequest is the synthetic event sent to the control socket
+type simulateRequest struct {
+	Hostname   string    `json:"hostname"`
+	PrivateIP  string    `json:"private_ip"`
+	InstanceID string    `json:"instance_id"`
+	Reason     string    `json:"reason"`
+	Deadline   time.Time `json:"deadline,omitzero"`
+}
+
+type simulateResponse struct {
+	Results []simulateResult `json:"results"`
+}
+
+type simulateResult struct {
+	Handler     string                         `json:"handler"`
+	Error       string                         `json:"error,omitempty"`
+	FailedPods  []evacuator.PodEvictionFailure `json:"failed_pods,omitempty"`
+	ProcessedAt time.Time                      `json:"processed_at"`
+}
+
+// simulateCommand builds a termination event from flags and pushes it through the
+// configured handlers, either once in this process or through the control socket
+// of a running daemon
+func simulateCommand(args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	var configPath = flags.String("config", "", "path to config file (optional)")
+	var dryRun = flags.Bool("dry-run", false, "report what handlers would do without executing it")
+	var socket = flags.String("socket", "", "control socket of a running daemon, handlers run in this process when empty")
+	var reason = flags.String("reason", "spot", "termination reason: spot, maintenance or rebalance")
+	var hostname = flags.String("hostname", "", "hostname of the terminated node, defaults to node_name or the local hostname")
+	var privateIP = flags.String("private-ip", "", "private ip of the terminated instance")
+	var instanceID = flags.String("instance-id", "simulated-instance-id", "id of the terminated instance")
+	var deadline = flags.Duration("deadline", 0, "time until the simulated termination, handlers get the processing timeout when zero")
+	flags.Parse(args)
+
+	request := simulateRequest{
+		Hostname:   *hostname,
+		PrivateIP:  *privateIP,
+		InstanceID: *instanceID,
+		Reason:     *reason,
+	}
+	if *deadline > 0 {
+		request.Deadline = time.Now().Add(*deadline)
+	}
+
+	if *socket != "" {
+		if *dryRun {
+			return fmt.Errorf("-dry-run cannot be used with -socket, the daemon configuration decides")
+		}
+		return postToControlSocket(*socket, ControlSimulatePath, request)
+	}
+
+	config, logger, err := setup(*configPath, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if request.Hostname == "" {
+		request.Hostname = config.NodeName
+	}
+
+	event, err := request.terminationEvent()
+	if err != nil {
+		return err
+	}
+
+	// a simulated notice is not persisted, no need to reach the state store
+	config.State.Backend = ""
+
+	defer evacuator.CleanupPlugins()
+
+	e, err := evacuator.NewEvacuator(config, logger)
+	if err != nil {
+		return err
+	}
+	if len(e.Handlers()) == 0 {
+		return fmt.Errorf("no handlers registered")
+	}
+
+	logger.Info("simulating termination event", "node", event.Hostname, "reason", event.Reason, "deadline", event.Deadline)
+
+	results := e.HandleTermination(context.Background(), event)
+	return resultsError(newSimulateResponse(results))
+}
+
+// recoverCommand passes a cancelled notice for the instance through the
+// configured handlers, to recover a node that survived its notice by hand
+func recoverCommand(args []string) error {
+	flags := flag.NewFlagSet("recover", flag.ExitOnError)
+	var configPath = flags.String("config", "", "path to config file (optional)")
+	var dryRun = flags.Bool("dry-run", false, "report what handlers would do without executing it")
+	var socket = flags.String("socket", "", "control socket of a running daemon, handlers run in this process when empty")
+	var hostname = flags.String("hostname", "", "hostname of the recovered node, defaults to node_name or the local hostname")
+	var privateIP = flags.String("private-ip", "", "private ip of the recovered instance")
+	var instanceID = flags.String("instance-id", "", "id of the recovered instance, required with a state backend unless the daemon behind -socket knows the notice")
+	flags.Parse(args)
+
+	request := simulateRequest{
+		Hostname:   *hostname,
+		PrivateIP:  *privateIP,
+		InstanceID: *instanceID,
+	}
+
+	if *socket != "" {
+		if *dryRun {
+			return fmt.Errorf("-dry-run cannot be used with -socket, the daemon configuration decides")
+		}
+		return postToControlSocket(*socket, ControlRecoverPath, request)
+	}
+
+	config, logger, err := setup(*configPath, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if request.Hostname == "" {
+		request.Hostname = config.NodeName
+	}
+
+	// a fresh process remembers no notices, only the state store matters
+	if err := recoverInstanceIDError(request, config); err != nil {
+		return err
+	}
+
+	event, err := request.terminationEvent()
+	if err != nil {
+		return err
+	}
+	event.Cancelled = true
+
+	defer evacuator.CleanupPlugins()
+
+	e, err := evacuator.NewEvacuator(config, logger)
+	if err != nil {
+		return err
+	}
+	if len(e.Handlers()) == 0 {
+		return fmt.Errorf("no handlers registered")
+	}
+
+	logger.Info("recovering node", "node", event.Hostname, "instance_id", event.InstanceID)
+
+	results := e.HandleCancellation(context.Background(), event)
+	return resultsError(newSimulateResponse(results))
+}
+
+// statusCommand prints the status of a running daemon
+func statusCommand(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	var socket = flags.String("socket", "", "control socket of the running daemon")
+	flags.Parse(args)
+
+	if *socket == "" {
+		return fmt.Errorf("-socket must be set")
+	}
+
+	res, err := controlSocketClient(*socket).Get(ControlApiBaseUrl + ControlStatusPath)
+	if err != nil {
+		return fmt.Errorf("failed to reach control socket: %w", err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %d as http request: %s", res.StatusCode, bytes.TrimSpace(resBody))
+	}
+
+	var status evacuator.Status
+	if err := json.Unmarshal(resBody, &status); err != nil {
+		return fmt.Errorf("failed to unmarshal status response: %w", err)
+	}
+
+	fmt.Printf("provider: %s\n", status.Provider)
+	fmt.Printf("handlers: %s\n", strings.Join(status.Handlers, ", "))
+	fmt.Printf("config generation: %d, applied at %s\n", status.Reload.Generation, status.Reload.AppliedAt.Format(time.RFC3339))
+	if len(status.Reload.RestartRequired) > 0 {
+		fmt.Printf("restart required for: %s\n", strings.Join(status.Reload.RestartRequired, ", "))
+	}
+	if status.Reload.LastError != "" {
+		fmt.Printf("last reload rejected at %s: %s\n", status.Reload.LastAttemptAt.Format(time.RFC3339), status.Reload.LastError)
+	}
+
+	return nil
+}
+
+// controlSocketClient returns an http client dialing the unix socket
+func controlSocketClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// postToControlSocket sends the event to a running daemon and prints the handler results
+func postToControlSocket(socket string, path string, request simulateRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	res, err := controlSocketClient(socket).Post(ControlApiBaseUrl+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to reach control socket: %w", err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %d as http request: %s", res.StatusCode, bytes.TrimSpace(resBody))
+	}
+
+	var response simulateResponse
+	if err := json.Unmarshal(resBody, &response); err != nil {
+		return fmt.Errorf("failed to unmarshal simulate response: %w", err)
+	}
+
+	for _, r := range response.Results {
+		if r.Error != "" {
+			fmt.Printf("%s: failed: %s\n", r.Handler, r.Error)
+		} else {
+			fmt.Printf("%s: ok\n", r.Handler)
+		}
+	}
+
+	return resultsError(response)
+}
+
+// serveControlSocket accepts simulated events and recoveries on the unix socket,
+// and reports the status, until ctx is done
+func serveControlSocket(ctx context.Context, socket string, config *evacuator.Config, e *evacuator.Evacuator, logger *slog.Logger) error {
+	// a socket left behind by a previous run would make listen fail
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	// anyone who can write to the socket can trigger handlers
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+ControlSimulatePath, func(w http.ResponseWriter, r *http.Request) {
+		var request simulateRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if request.Hostname == "" {
+			request.Hostname = config.NodeName
+		}
+
+		event, err := request.terminationEvent()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("simulated termination event received from control socket", "node", event.Hostname, "reason", event.Reason, "deadline", event.Deadline)
+
+		// handlers keep running when the client goes away, like for a real event
+		results := e.HandleTermination(ctx, event)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newSimulateResponse(results))
+	})
+
+	mux.HandleFunc("POST "+ControlRecoverPath, func(w http.ResponseWriter, r *http.Request) {
+		var request simulateRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if request.Hostname == "" {
+			request.Hostname = config.NodeName
+		}
+
+		// the daemon knows the instance of the notice it handled for the node,
+		// a controller handles every node and needs to be told which one
+		if request.InstanceID == "" {
+			if request.Hostname == "" && config.Cluster.Mode == evacuator.ClusterModeController {
+				http.Error(w, "hostname must be set to recover through a controller", http.StatusBadRequest)
+				return
+			}
+
+			notice, err := e.KnownNotice(request.Hostname)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%v, set the instance id", err), http.StatusBadRequest)
+				return
+			}
+			request.InstanceID = notice.InstanceID
+			if request.Hostname == "" {
+				request.Hostname = notice.Hostname
+			}
+		}
+
+		if err := recoverInstanceIDError(request, config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		event, err := request.terminationEvent()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		event.Cancelled = true
+
+		logger.Info("recovery received from control socket", "node", event.Hostname, "instance_id", event.InstanceID)
+
+		results := e.HandleCancellation(ctx, event)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newSimulateResponse(results))
+	})
+
+	mux.HandleFunc("GET "+ControlStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e.Status())
+	})
+
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logger.Info("control socket listening", "socket", socket)
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// recoverInstanceIDError returns an error for a recovery without instance ID
+// when it would miss the persisted state, which is kept by instance ID.
+// Otherwise a later notice for the instance would be resumed from it.
+func recoverInstanceIDError(request simulateRequest, config *evacuator.Config) error {
+	if request.InstanceID == "" && config.State.Backend != "" {
+		return fmt.Errorf("instance id must be set with state.backend %s, the state of a notice is kept by instance id", config.State.Backend)
+	}
+	return nil
+}
+
+// terminationEvent converts the request into the event handlers receive,
+// falling back to the local hostname when no hostname is known
+func (r simulateRequest) terminationEvent() (evacuator.TerminationEvent, error) {
+	event := evacuator.TerminationEvent{
+		Hostname:   r.Hostname,
+		PrivateIP:  r.PrivateIP,
+		InstanceID: r.InstanceID,
+		Deadline:   r.Deadline,
+	}
+
+	reason, err := evacuator.ParseTerminationReason(r.Reason)
+	if err != nil {
+		return event, err
+	}
+	event.Reason = reason
+
+	if event.Hostname == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return event, fmt.Errorf("failed to get hostname: %w", err)
+		}
+		event.Hostname = hostname
+	}
+	event.Key = evacuator.EventKey(event)
+
+	return event, nil
+}
+
+func newSimulateResponse(results []evacuator.HandlerResult) simulateResponse {
+	response := simulateResponse{Results: []simulateResult{}}
+	for _, r := range results {
+		result := simulateResult{
+			Handler:     r.HandlerName,
+			ProcessedAt: r.ProcessedAt,
+		}
+		if r.Error != nil {
+			result.Error = r.Error.Error()
+		}
+		var drainErr *evacuator.DrainError
+		if errors.As(r.Error, &drainErr) {
+			result.FailedPods = drainErr.Failed
+		}
+		response.Results = append(response.Results, result)
+	}
+	return response
+}
+
+// resultsError returns an error when any handler failed
+func resultsError(response simulateResponse) error {
+	failed := 0
+	for _, r := range response.Results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d handlers failed", failed, len(response.Results))
+	}
+
+	return nil
+}
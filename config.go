@@ -2,11 +2,17 @@ package evacuator
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type Config struct {
@@ -14,23 +20,111 @@ type Config struct {
 	Provider ProviderConfig `mapstructure:"provider"`
 	Handler  HandlerConfig  `mapstructure:"handler"`
 	Log      LogConfig      `mapstructure:"log"`
+	Control  ControlConfig  `mapstructure:"control"`
+	Cluster  ClusterConfig  `mapstructure:"cluster"`
+	State    StateConfig    `mapstructure:"state"`
+	Reload   ReloadConfig   `mapstructure:"reload"`
+	Secrets  SecretsConfig  `mapstructure:"secrets"`
+
+	// Warnings found by LoadConfig in a valid configuration, logged when an
+	// evacuator is created or reloaded
+	Warnings []ConfigIssue `mapstructure:"-"`
 }
 
 type HandlerConfig struct {
 	ProcessingTimeoutRaw string        `mapstructure:"processing_timeout"`
 	ProcessingTimeout    time.Duration `mapstructure:"-"`
 
-	Kubernetes KubernetesConfig `mapstructure:"kubernetes"`
-	Nomad      NomadConfig      `mapstructure:"nomad"`
-	Telegram   TelegramConfig   `mapstructure:"telegram"`
+	// RecoveryGracePeriod is how long an instance must outlive the handling of
+	// its notice, and its deadline, to have its node recovered, zero to never
+	RecoveryGracePeriodRaw string        `mapstructure:"recovery_grace_period"`
+	RecoveryGracePeriod    time.Duration `mapstructure:"-"`
+
+	// DedupWindow is how long a received notice is remembered to drop the
+	// repeated signals of it, zero to handle every signal
+	DedupWindowRaw string        `mapstructure:"dedup_window"`
+	DedupWindow    time.Duration `mapstructure:"-"`
+
+	Kubernetes   KubernetesConfig   `mapstructure:"kubernetes"`
+	Nomad        NomadConfig        `mapstructure:"nomad"`
+	Telegram     TelegramConfig     `mapstructure:"telegram"`
+	Host         HostConfig         `mapstructure:"host"`
+	LoadBalancer LoadBalancerConfig `mapstructure:"loadbalancer"`
+	Consul       ConsulConfig       `mapstructure:"consul"`
+
+	PluginDir          string         `mapstructure:"plugin_dir"`
+	PluginAutoDiscover bool           `mapstructure:"plugin_auto_discover"`
+	Plugins            []PluginConfig `mapstructure:"plugins"`
+
+	Strict    bool                    `mapstructure:"strict"`
+	DryRun    bool                    `mapstructure:"dry_run"`
+	Instances []HandlerInstanceConfig `mapstructure:"instances"`
+}
+
+// HandlerInstanceConfig configures an additional handler instance, e.g. a
+// second telegram chat. Settings are applied on top of the handler section of
+// the same type, so only the values that differ need to be set.
+type HandlerInstanceConfig struct {
+	Name     string                 `mapstructure:"name"`
+	Type     string                 `mapstructure:"type"`
+	Enabled  bool                   `mapstructure:"enabled"`
+	Settings map[string]interface{} `mapstructure:"settings"`
 }
 
 type KubernetesConfig struct {
-	Enabled            bool   `mapstructure:"enabled"`
-	SkipDaemonSets     bool   `mapstructure:"skip_daemon_sets"`
-	DeleteEmptyDirData bool   `mapstructure:"delete_empty_dir_data"`
-	Kubeconfig         string `mapstructure:"kubeconfig"`
-	InCluster          bool   `mapstructure:"in_cluster"`
+	Enabled                bool                         `mapstructure:"enabled"`
+	SkipDaemonSets         bool                         `mapstructure:"skip_daemon_sets"`
+	DeleteEmptyDirData     bool                         `mapstructure:"delete_empty_dir_data"`
+	Kubeconfig             string                       `mapstructure:"kubeconfig"`
+	InCluster              bool                         `mapstructure:"in_cluster"`
+	QPS                    float32                      `mapstructure:"qps"`
+	Burst                  int                          `mapstructure:"burst"`
+	VerifyPermissions      bool                         `mapstructure:"verify_permissions"`
+	PodCache               bool                         `mapstructure:"pod_cache"`
+	KeepaliveIntervalRaw   string                       `mapstructure:"keepalive_interval"`
+	KeepaliveInterval      time.Duration                `mapstructure:"-"`
+	Taint                  string                       `mapstructure:"taint"`
+	VolumeDetachTimeoutRaw string                       `mapstructure:"volume_detach_timeout"`
+	VolumeDetachTimeout    time.Duration                `mapstructure:"-"`
+	DrainFailure           KubernetesDrainFailureConfig `mapstructure:"drain_failure"`
+	Coordination           KubernetesCoordinationConfig `mapstructure:"coordination"`
+	Prescale               KubernetesPrescaleConfig     `mapstructure:"prescale"`
+}
+
+// KubernetesPrescaleConfig requests replacement capacity as soon as the
+// termination is known, instead of when the evicted pods are pending
+type KubernetesPrescaleConfig struct {
+	Karpenter   bool                        `mapstructure:"karpenter"`
+	Placeholder KubernetesPlaceholderConfig `mapstructure:"placeholder"`
+}
+
+type KubernetesPlaceholderConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	Namespace     string `mapstructure:"namespace"`
+	PriorityClass string `mapstructure:"priority_class"`
+	Image         string `mapstructure:"image"`
+}
+
+// KubernetesDrainFailureConfig decides when pods that failed to evict fail
+// the drain
+type KubernetesDrainFailureConfig struct {
+	Policy             string   `mapstructure:"policy"`
+	Threshold          int      `mapstructure:"threshold"`
+	CriticalNamespaces []string `mapstructure:"critical_namespaces"`
+	CriticalSelector   string   `mapstructure:"critical_selector"`
+}
+
+// KubernetesCoordinationConfig limits the drains running at once across all
+// evacuators sharing the same ConfigMap
+type KubernetesCoordinationConfig struct {
+	Enabled             bool          `mapstructure:"enabled"`
+	Namespace           string        `mapstructure:"namespace"`
+	ConfigMap           string        `mapstructure:"config_map"`
+	MaxConcurrentDrains int           `mapstructure:"max_concurrent_drains"`
+	MaxWaitRaw          string        `mapstructure:"max_wait"`
+	MaxWait             time.Duration `mapstructure:"-"`
+	WindowRaw           string        `mapstructure:"window"`
+	Window              time.Duration `mapstructure:"-"`
 }
 
 type NomadConfig struct {
@@ -38,10 +132,86 @@ type NomadConfig struct {
 	Force   bool `mapstructure:"force"`
 }
 
+type ConsulConfig struct {
+	Enabled   bool            `mapstructure:"enabled"`
+	Address   string          `mapstructure:"address"`
+	Token     string          `mapstructure:"token"`
+	TokenFile string          `mapstructure:"token_file"`
+	Mode      string          `mapstructure:"mode"`
+	Reason    string          `mapstructure:"reason"`
+	Services  []string        `mapstructure:"services"`
+	WaitRaw   string          `mapstructure:"wait"`
+	Wait      time.Duration   `mapstructure:"-"`
+	TLS       ConsulTLSConfig `mapstructure:"tls"`
+}
+
+type ConsulTLSConfig struct {
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
 type TelegramConfig struct {
-	Enabled  bool   `mapstructure:"enabled"`
-	BotToken string `mapstructure:"bot_token"`
-	ChatID   string `mapstructure:"chat_id"`
+	Enabled      bool   `mapstructure:"enabled"`
+	BotToken     string `mapstructure:"bot_token"`
+	BotTokenFile string `mapstructure:"bot_token_file"`
+	ChatID       string `mapstructure:"chat_id"`
+}
+
+type HostConfig struct {
+	Enabled bool              `mapstructure:"enabled"`
+	Docker  HostDockerConfig  `mapstructure:"docker"`
+	Systemd HostSystemdConfig `mapstructure:"systemd"`
+}
+
+type HostDockerConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	Socket         string        `mapstructure:"socket"`
+	Label          string        `mapstructure:"label"`
+	StopTimeoutRaw string        `mapstructure:"stop_timeout"`
+	StopTimeout    time.Duration `mapstructure:"-"`
+}
+
+type HostSystemdConfig struct {
+	Enabled bool     `mapstructure:"enabled"`
+	Units   []string `mapstructure:"units"`
+}
+
+type LoadBalancerConfig struct {
+	Enabled         bool                  `mapstructure:"enabled"`
+	DrainTimeoutRaw string                `mapstructure:"drain_timeout"`
+	DrainTimeout    time.Duration         `mapstructure:"-"`
+	Aws             LoadBalancerAwsConfig `mapstructure:"aws"`
+	Gcp             LoadBalancerGcpConfig `mapstructure:"gcp"`
+}
+
+type LoadBalancerAwsConfig struct {
+	Enabled              bool     `mapstructure:"enabled"`
+	Region               string   `mapstructure:"region"`
+	Endpoint             string   `mapstructure:"endpoint"`
+	TargetGroupArns      []string `mapstructure:"target_group_arns"`
+	ClassicLoadBalancers []string `mapstructure:"classic_load_balancers"`
+	AutoDiscover         bool     `mapstructure:"auto_discover"`
+}
+
+type LoadBalancerGcpConfig struct {
+	Enabled          bool     `mapstructure:"enabled"`
+	Endpoint         string   `mapstructure:"endpoint"`
+	MetadataEndpoint string   `mapstructure:"metadata_endpoint"`
+	Project          string   `mapstructure:"project"`
+	Zone             string   `mapstructure:"zone"`
+	InstanceName     string   `mapstructure:"instance_name"`
+	InstanceGroups   []string `mapstructure:"instance_groups"`
+}
+
+type PluginConfig struct {
+	Name    string   `mapstructure:"name"`
+	Enabled bool     `mapstructure:"enabled"`
+	Path    string   `mapstructure:"path"`
+	Args    []string `mapstructure:"args"`
+	Env     []string `mapstructure:"env"`
 }
 
 type ProviderConfig struct {
@@ -52,6 +222,116 @@ type ProviderConfig struct {
 	RequestTimeoutRaw string              `mapstructure:"request_timeout"`
 	RequestTimeout    time.Duration       `mapstructure:"-"`
 	Dummy             ProviderConfigDummy `mapstructure:"dummy"`
+
+	// tuning of the metadata providers
+	AWS      ProviderConfigAws      `mapstructure:"aws"`
+	Gcp      ProviderConfigGcp      `mapstructure:"gcp"`
+	Alicloud ProviderConfigImds     `mapstructure:"alicloud"`
+	Tencent  ProviderConfigMetadata `mapstructure:"tencent"`
+	Huawei   ProviderConfigImds     `mapstructure:"huawei"`
+}
+
+// ProviderConfigMetadata tunes a provider polling the metadata service of its
+// cloud. An empty poll interval or request timeout uses the provider one.
+type ProviderConfigMetadata struct {
+	PollIntervalRaw   string        `mapstructure:"poll_interval"`
+	PollInterval      time.Duration `mapstructure:"-"`
+	RequestTimeoutRaw string        `mapstructure:"request_timeout"`
+	RequestTimeout    time.Duration `mapstructure:"-"`
+	Endpoint          string        `mapstructure:"endpoint"`
+	Signals           []string      `mapstructure:"signals"`
+}
+
+// ProviderConfigImds tunes a metadata service requiring a session token
+type ProviderConfigImds struct {
+	ProviderConfigMetadata `mapstructure:",squash"`
+	TokenTTLRaw            string        `mapstructure:"token_ttl"`
+	TokenTTL               time.Duration `mapstructure:"-"`
+}
+
+// ProviderConfigAws tunes the aws metadata service, which lists scheduled
+// events days before they start
+type ProviderConfigAws struct {
+	ProviderConfigImds     `mapstructure:",squash"`
+	MaintenanceLeadTimeRaw string        `mapstructure:"maintenance_lead_time"`
+	MaintenanceLeadTime    time.Duration `mapstructure:"-"`
+}
+
+// ProviderConfigGcp tunes the gcp metadata server, which can hold a request
+// until a watched value changes
+type ProviderConfigGcp struct {
+	ProviderConfigMetadata `mapstructure:",squash"`
+	HangingGet             bool `mapstructure:"hanging_get"`
+}
+
+// metadataProviders are the providers polling a metadata service, in
+// detection order
+var metadataProviders = []ProviderName{ProviderAWS, ProviderAlicloud, ProviderTencent, ProviderGcp, ProviderHuawei}
+
+// metadataSection returns the section of the named metadata provider, nil
+// for the other providers
+func (c *ProviderConfig) metadataSection(name ProviderName) *ProviderConfigMetadata {
+	switch name {
+	case ProviderAWS:
+		return &c.AWS.ProviderConfigMetadata
+	case ProviderGcp:
+		return &c.Gcp.ProviderConfigMetadata
+	case ProviderAlicloud:
+		return &c.Alicloud.ProviderConfigMetadata
+	case ProviderTencent:
+		return &c.Tencent
+	case ProviderHuawei:
+		return &c.Huawei.ProviderConfigMetadata
+	}
+	return nil
+}
+
+// tokenSection returns the token settings of the named metadata provider, nil
+// for the providers without session tokens
+func (c *ProviderConfig) tokenSection(name ProviderName) *ProviderConfigImds {
+	switch name {
+	case ProviderAWS:
+		return &c.AWS.ProviderConfigImds
+	case ProviderAlicloud:
+		return &c.Alicloud
+	case ProviderHuawei:
+		return &c.Huawei
+	}
+	return nil
+}
+
+// MetadataProviderConfig returns the effective settings of the named metadata
+// provider, its section falling back to the provider poll interval and
+// request timeout. The logger is left to the caller.
+func (c *ProviderConfig) MetadataProviderConfig(name ProviderName) *MetadataProviderConfig {
+	config := &MetadataProviderConfig{
+		PollInterval: c.PollInterval,
+		HttpClient:   &http.Client{Timeout: c.RequestTimeout},
+	}
+
+	section := c.metadataSection(name)
+	if section == nil {
+		return config
+	}
+
+	if section.PollIntervalRaw != "" {
+		config.PollInterval = section.PollInterval
+	}
+	if section.RequestTimeoutRaw != "" {
+		config.HttpClient.Timeout = section.RequestTimeout
+	}
+	config.Endpoint = section.Endpoint
+	config.Signals = section.Signals
+
+	if token := c.tokenSection(name); token != nil {
+		config.TokenTTL = token.TokenTTL
+	}
+	config.HangingGet = name == ProviderGcp && c.Gcp.HangingGet
+	if name == ProviderAWS {
+		config.MaintenanceLeadTime = c.AWS.MaintenanceLeadTime
+	}
+
+	return config
 }
 
 type LogConfig struct {
@@ -59,8 +339,67 @@ type LogConfig struct {
 	Format string `mapstructure:"format"`
 }
 
+// ControlConfig configures the control socket of a running daemon,
+// used by `evacuator simulate --socket` to inject events
+type ControlConfig struct {
+	Socket string `mapstructure:"socket"`
+}
+
+// ReloadConfig configures how a running daemon picks up configuration
+// changes, SIGHUP always reloads
+type ReloadConfig struct {
+	// Watch reloads whenever the config file changes
+	Watch bool `mapstructure:"watch"`
+}
+
+// StateConfig persists the progress of handled notices, so a restarted
+// evacuator does not run the handlers that completed a notice again
+type StateConfig struct {
+	Backend    string        `mapstructure:"backend"`
+	Path       string        `mapstructure:"path"`
+	Namespace  string        `mapstructure:"namespace"`
+	ConfigMap  string        `mapstructure:"config_map"`
+	InCluster  bool          `mapstructure:"in_cluster"`
+	Kubeconfig string        `mapstructure:"kubeconfig"`
+	TTLRaw     string        `mapstructure:"ttl"`
+	TTL        time.Duration `mapstructure:"-"`
+}
+
+// ClusterConfig splits evacuator into node agents that only detect and publish
+// termination notices, and a central controller that handles them
+type ClusterConfig struct {
+	Mode       string                  `mapstructure:"mode"`
+	Token      string                  `mapstructure:"token"`
+	TokenFile  string                  `mapstructure:"token_file"`
+	Kubeconfig string                  `mapstructure:"kubeconfig"`
+	InCluster  bool                    `mapstructure:"in_cluster"`
+	Agent      ClusterAgentConfig      `mapstructure:"agent"`
+	Controller ClusterControllerConfig `mapstructure:"controller"`
+}
+
+type ClusterAgentConfig struct {
+	Publish       string `mapstructure:"publish"`
+	ControllerUrl string `mapstructure:"controller_url"`
+}
+
+type ClusterControllerConfig struct {
+	ListenAddress      string        `mapstructure:"listen_address"`
+	LeaseName          string        `mapstructure:"lease_name"`
+	LeaseNamespace     string        `mapstructure:"lease_namespace"`
+	Identity           string        `mapstructure:"identity"`
+	LeaseDurationRaw   string        `mapstructure:"lease_duration"`
+	LeaseDuration      time.Duration `mapstructure:"-"`
+	RenewDeadlineRaw   string        `mapstructure:"renew_deadline"`
+	RenewDeadline      time.Duration `mapstructure:"-"`
+	RetryPeriodRaw     string        `mapstructure:"retry_period"`
+	RetryPeriod        time.Duration `mapstructure:"-"`
+	MaxConcurrentNodes int           `mapstructure:"max_concurrent_nodes"`
+}
+
 type ProviderConfigDummy struct {
-	DetectionWait string `mapstructure:"detection_wait"`
+	DetectionWaitRaw string        `mapstructure:"detection_wait"`
+	DetectionWait    time.Duration `mapstructure:"-"`
+	Scenario         string        `mapstructure:"scenario"`
 }
 
 func LoadConfig(configPath string, v *viper.Viper) (*Config, error) {
@@ -92,88 +431,677 @@ func LoadConfig(configPath string, v *viper.Viper) (*Config, error) {
 		}
 	}
 
-	var config Config
-	if err := v.Unmarshal(&config); err != nil {
+	// The secrets section configures where ${vault:...} references are read
+	// from, it is decoded first with those references left as they are
+	var unresolved Config
+	if err := v.Unmarshal(&unresolved, viper.DecodeHook(secretDecodeHook(newSecretResolver(nil)))); err != nil {
 		return nil, err
 	}
+	var issues configIssues
+	secrets := unresolved.Secrets
+	resolveSecretFiles(secrets.secretFields(), &issues)
 
-	// Parse duration strings into time.Duration fields
-	if err := parseDurationFields(&config); err != nil {
+	var config Config
+	var metadata mapstructure.Metadata
+	err := v.Unmarshal(&config,
+		viper.DecodeHook(secretDecodeHook(newSecretResolver(&secrets.Vault))),
+		func(c *mapstructure.DecoderConfig) { c.Metadata = &metadata },
+	)
+	if err != nil {
 		return nil, err
 	}
+	config.Secrets = secrets
+
+	// Keys the config file sets that no field reads, most likely a typo
+	for _, key := range metadata.Unused {
+		issues.warnf(key, "unknown key, ignored")
+	}
 
-	// Validate the config after parsing duration fields
-	if err := validateConfig(&config); err != nil {
+	// Read the *_file variants of the secret fields
+	resolveSecretFiles(config.secretFields(), &issues)
+
+	// Parse duration strings into time.Duration fields
+	parseDurationFields(&config, &issues)
+
+	// Validate the config after parsing duration fields, every problem is
+	// reported at once
+	validateConfig(&config, &issues)
+	if err := issues.err(); err != nil {
 		return nil, err
 	}
 
+	config.Warnings = issues.warnings
 	return &config, nil
 }
 
-func parseDurationFields(c *Config) error {
-	// Parse handler processing timeout
-	processingTimeout, err := time.ParseDuration(c.Handler.ProcessingTimeoutRaw)
-	if err != nil {
-		return fmt.Errorf("handler.processing_timeout must be a valid duration: %w", err)
+// ConfigIssue is a problem found in the configuration, Path is the YAML path
+// of the value, e.g. handler.telegram.chat_id
+type ConfigIssue struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (i ConfigIssue) String() string {
+	return i.Path + ": " + i.Message
+}
+
+// ValidationError lists every error found in an invalid configuration,
+// together with its warnings
+type ValidationError struct {
+	Errors   []ConfigIssue
+	Warnings []ConfigIssue
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].String()
 	}
-	c.Handler.ProcessingTimeout = processingTimeout
 
-	providerPollInterval, err := time.ParseDuration(c.Provider.PollIntervalRaw)
-	if err != nil {
-		return fmt.Errorf("provider.poll_interval must be a valid duration: %w", err)
+	lines := make([]string, len(e.Errors))
+	for i, issue := range e.Errors {
+		lines[i] = issue.String()
+	}
+	return fmt.Sprintf("%d configuration errors:\n  %s", len(e.Errors), strings.Join(lines, "\n  "))
+}
+
+// configIssues collects the errors and warnings of a configuration
+type configIssues struct {
+	errors   []ConfigIssue
+	warnings []ConfigIssue
+}
+
+// errorf records an error, only the first one of a path is kept, so a value
+// that failed to parse is not reported again by the checks using it
+func (is *configIssues) errorf(path string, format string, args ...interface{}) {
+	for _, issue := range is.errors {
+		if issue.Path == path {
+			return
+		}
+	}
+	is.errors = append(is.errors, ConfigIssue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (is *configIssues) warnf(path string, format string, args ...interface{}) {
+	is.warnings = append(is.warnings, ConfigIssue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns a *ValidationError when errors were found
+func (is *configIssues) err() error {
+	if len(is.errors) == 0 {
+		return nil
 	}
-	c.Provider.PollInterval = providerPollInterval
+	return &ValidationError{Errors: is.errors, Warnings: is.warnings}
+}
 
-	providerRequestTimeout, err := time.ParseDuration(c.Provider.RequestTimeoutRaw)
+// parseDuration parses raw into value, reporting a failure at path
+func (is *configIssues) parseDuration(path string, raw string, value *time.Duration) {
+	d, err := time.ParseDuration(raw)
 	if err != nil {
-		return fmt.Errorf("provider.request_timeout must be a valid duration: %w", err)
+		is.errorf(path, "must be a valid duration: %v", err)
+		return
 	}
-	c.Provider.RequestTimeout = providerRequestTimeout
+	*value = d
+}
 
-	return nil
+func parseDurationFields(c *Config, is *configIssues) {
+	is.parseDuration("provider.poll_interval", c.Provider.PollIntervalRaw, &c.Provider.PollInterval)
+	is.parseDuration("provider.request_timeout", c.Provider.RequestTimeoutRaw, &c.Provider.RequestTimeout)
+	is.parseDuration("provider.dummy.detection_wait", c.Provider.Dummy.DetectionWaitRaw, &c.Provider.Dummy.DetectionWait)
+	is.parseDuration("provider.aws.maintenance_lead_time", c.Provider.AWS.MaintenanceLeadTimeRaw, &c.Provider.AWS.MaintenanceLeadTime)
+
+	for _, name := range metadataProviders {
+		path := "provider." + string(name)
+		section := c.Provider.metadataSection(name)
+		if section.PollIntervalRaw != "" {
+			is.parseDuration(path+".poll_interval", section.PollIntervalRaw, &section.PollInterval)
+		}
+		if section.RequestTimeoutRaw != "" {
+			is.parseDuration(path+".request_timeout", section.RequestTimeoutRaw, &section.RequestTimeout)
+		}
+		if token := c.Provider.tokenSection(name); token != nil {
+			is.parseDuration(path+".token_ttl", token.TokenTTLRaw, &token.TokenTTL)
+		}
+	}
+
+	controller := &c.Cluster.Controller
+	is.parseDuration("cluster.controller.lease_duration", controller.LeaseDurationRaw, &controller.LeaseDuration)
+	is.parseDuration("cluster.controller.renew_deadline", controller.RenewDeadlineRaw, &controller.RenewDeadline)
+	is.parseDuration("cluster.controller.retry_period", controller.RetryPeriodRaw, &controller.RetryPeriod)
+
+	is.parseDuration("state.ttl", c.State.TTLRaw, &c.State.TTL)
+
+	parseHandlerDurationFields(&c.Handler, is)
+}
+
+func parseHandlerDurationFields(h *HandlerConfig, is *configIssues) {
+	is.parseDuration("handler.processing_timeout", h.ProcessingTimeoutRaw, &h.ProcessingTimeout)
+	is.parseDuration("handler.recovery_grace_period", h.RecoveryGracePeriodRaw, &h.RecoveryGracePeriod)
+	is.parseDuration("handler.dedup_window", h.DedupWindowRaw, &h.DedupWindow)
+	is.parseDuration("handler.host.docker.stop_timeout", h.Host.Docker.StopTimeoutRaw, &h.Host.Docker.StopTimeout)
+	is.parseDuration("handler.loadbalancer.drain_timeout", h.LoadBalancer.DrainTimeoutRaw, &h.LoadBalancer.DrainTimeout)
+	is.parseDuration("handler.consul.wait", h.Consul.WaitRaw, &h.Consul.Wait)
+	is.parseDuration("handler.kubernetes.keepalive_interval", h.Kubernetes.KeepaliveIntervalRaw, &h.Kubernetes.KeepaliveInterval)
+	is.parseDuration("handler.kubernetes.volume_detach_timeout", h.Kubernetes.VolumeDetachTimeoutRaw, &h.Kubernetes.VolumeDetachTimeout)
+
+	coordination := &h.Kubernetes.Coordination
+	is.parseDuration("handler.kubernetes.coordination.max_wait", coordination.MaxWaitRaw, &coordination.MaxWait)
+	is.parseDuration("handler.kubernetes.coordination.window", coordination.WindowRaw, &coordination.Window)
 }
 
-func validateConfig(c *Config) error {
+// validateConfig records every problem of the parsed configuration
+func validateConfig(c *Config, is *configIssues) {
 
 	// provider
-	if c.Provider.Name == "" && !c.Provider.AutoDetect {
-		return fmt.Errorf("provider.name must be set")
+	switch ProviderName(c.Provider.Name) {
+	case "":
+		if !c.Provider.AutoDetect {
+			is.errorf("provider.name", "must be set when provider.auto_detect is disabled")
+		}
+	case ProviderAWS, ProviderGcp, ProviderAlicloud, ProviderTencent, ProviderHuawei, ProviderDummy:
+	default:
+		is.errorf("provider.name", "must be one of: %s, %s, %s, %s, %s, %s", ProviderAWS, ProviderGcp, ProviderAlicloud, ProviderTencent, ProviderHuawei, ProviderDummy)
+	}
+
+	// duration things, bounded by each metadata provider using them
+	if c.Provider.PollInterval <= 0 {
+		is.errorf("provider.poll_interval", "must be positive")
+	}
+
+	if c.Provider.RequestTimeout <= 0 {
+		is.errorf("provider.request_timeout", "must be positive")
+	}
+
+	switch name := ProviderName(c.Provider.Name); name {
+	case "":
+		// any of them can be detected
+		for _, name := range metadataProviders {
+			validateMetadataProviderConfig(&c.Provider, name, is)
+		}
+	case ProviderDummy:
+	default:
+		if c.Provider.metadataSection(name) != nil {
+			validateMetadataProviderConfig(&c.Provider, name, is)
+		}
+	}
+
+	if c.Provider.Name == string(ProviderDummy) {
+		if c.Provider.Dummy.DetectionWait < 0 {
+			is.errorf("provider.dummy.detection_wait", "must not be negative")
+		}
+		if c.Provider.Dummy.Scenario != "" {
+			if _, err := LoadDummyScenario(c.Provider.Dummy.Scenario); err != nil {
+				is.errorf("provider.dummy.scenario", "%v", err)
+			}
+		}
+	}
+
+	// log
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		is.errorf("log.level", "must be one of: debug, info, warn, error")
+	}
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		is.errorf("log.format", "must be text or json")
+	}
+
+	validateClusterConfig(&c.Cluster, is)
+	validateStateConfig(c, is)
+	validateHandlerConfig(&c.Handler, is)
+}
+
+// validateMetadataProviderConfig checks the effective settings of the named
+// metadata provider against its own bounds
+func validateMetadataProviderConfig(c *ProviderConfig, name ProviderName, is *configIssues) {
+	path := "provider." + string(name)
+	section := c.metadataSection(name)
+	effective := c.MetadataProviderConfig(name)
+
+	limits := metadataProviderBounds[name]
+	subject := string(name)
+	if effective.HangingGet {
+		limits.minRequestTimeout, limits.maxRequestTimeout = GcpHangingGetMinRequestTimeout, GcpHangingGetMaxRequestTimeout
+		subject += " with hanging_get"
+	}
+
+	// report inherited values at the provider section they come from
+	pollPath, timeoutPath := "provider.poll_interval", "provider.request_timeout"
+	if section.PollIntervalRaw != "" {
+		pollPath = path + ".poll_interval"
+	}
+	if section.RequestTimeoutRaw != "" {
+		timeoutPath = path + ".request_timeout"
+	}
+
+	if effective.PollInterval < limits.minPollInterval || effective.PollInterval > limits.maxPollInterval {
+		is.errorf(pollPath, "must be between %s and %s for %s", limits.minPollInterval, limits.maxPollInterval, name)
+	}
+	if timeout := effective.HttpClient.Timeout; timeout < limits.minRequestTimeout || timeout > limits.maxRequestTimeout {
+		is.errorf(timeoutPath, "must be between %s and %s for %s", limits.minRequestTimeout, limits.maxRequestTimeout, subject)
+	}
+
+	if section.Endpoint != "" {
+		endpoint, err := url.Parse(section.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			is.errorf(path+".endpoint", "must be an http or https url")
+		}
+	}
+
+	supported := providerSignals[name]
+	if len(section.Signals) == 0 {
+		is.errorf(path+".signals", "must list at least one of: %s", strings.Join(supported, ", "))
+	}
+	for i, signal := range section.Signals {
+		if !slices.Contains(supported, signal) {
+			is.errorf(fmt.Sprintf("%s.signals[%d]", path, i), "must be one of: %s", strings.Join(supported, ", "))
+		}
+	}
+
+	if token := c.tokenSection(name); token != nil {
+		if token.TokenTTL < time.Second || token.TokenTTL > 6*time.Hour {
+			is.errorf(path+".token_ttl", "must be between 1s and 6h")
+		}
+	}
+
+	if name == ProviderAWS && c.AWS.MaintenanceLeadTime < time.Minute {
+		is.errorf(path+".maintenance_lead_time", "must be at least 1m")
+	}
+}
+
+func validateClusterConfig(c *ClusterConfig, is *configIssues) {
+	switch c.Mode {
+	case ClusterModeStandalone:
+		return
+	case ClusterModeAgent:
+		switch c.Agent.Publish {
+		case ClusterPublishAnnotation:
+		case ClusterPublishHttp:
+			if c.Agent.ControllerUrl == "" {
+				is.errorf("cluster.agent.controller_url", "must be set when cluster.agent.publish is %s", ClusterPublishHttp)
+			}
+			if c.Token == "" {
+				is.errorf("cluster.token", "must be set when cluster.agent.publish is %s, the controller only accepts authenticated events", ClusterPublishHttp)
+			}
+		default:
+			is.errorf("cluster.agent.publish", "must be %s or %s", ClusterPublishAnnotation, ClusterPublishHttp)
+		}
+	case ClusterModeController:
+		controller := c.Controller
+		if controller.LeaseName == "" {
+			is.errorf("cluster.controller.lease_name", "must be set")
+		}
+		if controller.LeaseNamespace == "" {
+			is.errorf("cluster.controller.lease_namespace", "must be set")
+		}
+		if controller.LeaseDuration <= controller.RenewDeadline || controller.RenewDeadline <= controller.RetryPeriod || controller.RetryPeriod <= 0 {
+			is.errorf("cluster.controller", "durations must satisfy lease_duration > renew_deadline > retry_period > 0")
+		}
+		if controller.MaxConcurrentNodes < 0 {
+			is.errorf("cluster.controller.max_concurrent_nodes", "must not be negative")
+		}
+		// any client reaching the endpoint could have a node drained
+		if controller.ListenAddress != "" && c.Token == "" {
+			is.errorf("cluster.token", "must be set when cluster.controller.listen_address is set, or clear the listen address to only watch annotations")
+		}
+	default:
+		is.errorf("cluster.mode", "must be one of: %s, %s, %s", ClusterModeStandalone, ClusterModeAgent, ClusterModeController)
+		return
+	}
+
+	if !c.InCluster && c.Kubeconfig == "" && !(c.Mode == ClusterModeAgent && c.Agent.Publish == ClusterPublishHttp) {
+		is.errorf("cluster.kubeconfig", "must be set when cluster.in_cluster is disabled")
+	}
+}
+
+// validateStateConfig validates the state backend
+func validateStateConfig(c *Config, is *configIssues) {
+	state := c.State
+
+	switch state.Backend {
+	case "":
+		return
+	case StateBackendFile:
+		if state.Path == "" {
+			is.errorf("state.path", "must be set for the %s backend", StateBackendFile)
+		}
+	case StateBackendConfigMap:
+		if state.Namespace == "" {
+			is.errorf("state.namespace", "must be set for the %s backend", StateBackendConfigMap)
+		}
+		if state.ConfigMap == "" {
+			is.errorf("state.config_map", "must be set for the %s backend", StateBackendConfigMap)
+		}
+	case StateBackendAnnotation:
+		// the annotation goes on the node of the event, only known without node_name for a controller
+		if c.NodeName == "" && c.Cluster.Mode != ClusterModeController {
+			is.errorf("node_name", "must be set for the %s state backend", StateBackendAnnotation)
+		}
+	default:
+		is.errorf("state.backend", "must be one of: %s, %s, %s", StateBackendFile, StateBackendConfigMap, StateBackendAnnotation)
+		return
+	}
+
+	if state.Backend != StateBackendFile && !state.InCluster && state.Kubeconfig == "" {
+		is.errorf("state.kubeconfig", "must be set when state.in_cluster is disabled")
 	}
 
-	// duration things
-	if c.Provider.PollInterval < 3*time.Second || c.Provider.PollInterval > 10*time.Second {
-		return fmt.Errorf("provider.poll_interval must be between 3s and 10s")
+	if state.TTL <= 0 {
+		is.errorf("state.ttl", "must be positive")
 	}
+}
+
+// validateHandlerConfig validates the handler settings, the enabled handler
+// sections, the plugins and the instances
+func validateHandlerConfig(h *HandlerConfig, is *configIssues) {
+
+	if h.ProcessingTimeout <= 0 {
+		is.errorf("handler.processing_timeout", "must be positive")
+	} else if h.ProcessingTimeout > 75*time.Second {
+		is.warnf("handler.processing_timeout", "more than 75s leaves no time before an AWS spot instance is terminated")
+	}
+
+	if h.RecoveryGracePeriod < 0 {
+		is.errorf("handler.recovery_grace_period", "must not be negative")
+	}
+
+	if h.DedupWindow < 0 {
+		is.errorf("handler.dedup_window", "must not be negative")
+	}
+
+	validateHandlerSections(h, "handler", is)
+
+	if h.Kubernetes.Enabled && h.Nomad.Enabled {
+		is.errorf("handler.nomad.enabled", "cannot be enabled together with handler.kubernetes")
+	}
+
+	// plugins
+	handlerNames := make(map[string]bool)
+	for i, p := range h.Plugins {
+		path := fmt.Sprintf("handler.plugins[%d]", i)
+		if p.Name == "" {
+			is.errorf(path+".name", "must be set")
+		} else if handlerNames[p.Name] {
+			is.errorf(path+".name", "%q is used more than once", p.Name)
+		}
+		handlerNames[p.Name] = true
+
+		if p.Enabled && !filepath.IsAbs(p.Path) && h.PluginDir == "" {
+			is.errorf("handler.plugin_dir", "must be set for %s without an absolute path", path)
+		}
+	}
+	if h.PluginAutoDiscover && h.PluginDir == "" {
+		is.errorf("handler.plugin_dir", "must be set when handler.plugin_auto_discover is enabled")
+	}
+
+	// instances
+	for _, name := range []HandlerName{HandlerNameDummy, HandlerNameKubernetes, HandlerNameNomad, HandlerNameConsul, HandlerNameTelegram, HandlerNameHost, HandlerNameLoadBalancer} {
+		handlerNames[string(name)] = true
+	}
+	for i, instance := range h.Instances {
+		path := fmt.Sprintf("handler.instances[%d]", i)
+		if instance.Name == "" {
+			is.errorf(path+".name", "must be set")
+		} else if handlerNames[instance.Name] {
+			is.errorf(path+".name", "%q is already used by another handler", instance.Name)
+		}
+		handlerNames[instance.Name] = true
 
-	if c.Provider.RequestTimeout < 1*time.Second || c.Provider.RequestTimeout > 5*time.Second {
-		return fmt.Errorf("provider.request_timeout must be between 1s and 5s")
+		if instance.Type == "" {
+			is.errorf(path+".type", "must be set")
+		}
+
+		if !instance.Enabled {
+			continue
+		}
+
+		instanceConfig, err := h.ForInstance(instance)
+		if err != nil {
+			is.errorf(path+".settings", "%v", err)
+			continue
+		}
+
+		// only the section of the instance type comes from its settings
+		sections := HandlerConfig{ProcessingTimeout: instanceConfig.ProcessingTimeout}
+		switch HandlerName(instance.Type) {
+		case HandlerNameKubernetes:
+			sections.Kubernetes = instanceConfig.Kubernetes
+		case HandlerNameConsul:
+			sections.Consul = instanceConfig.Consul
+		case HandlerNameTelegram:
+			sections.Telegram = instanceConfig.Telegram
+		case HandlerNameHost:
+			sections.Host = instanceConfig.Host
+		case HandlerNameLoadBalancer:
+			sections.LoadBalancer = instanceConfig.LoadBalancer
+		}
+		validateHandlerSections(&sections, path+".settings", is)
 	}
+}
 
-	if c.Handler.ProcessingTimeout > 75*time.Second {
-		// for warning not actual error
-		return fmt.Errorf("handler.processing_timeout more than 75s that makes it ineffective")
+// validateHandlerSections validates the enabled handler sections of h, path
+// is where they are configured, handler or the settings of an instance
+func validateHandlerSections(h *HandlerConfig, path string, is *configIssues) {
+	// instance settings hold the fields of their section directly
+	section := func(name string) string {
+		if path == "handler" {
+			return path + "." + name
+		}
+		return path
 	}
 
 	// telegram
-	if c.Handler.Telegram.Enabled {
-		if c.Handler.Telegram.BotToken == "" && c.Handler.Telegram.ChatID == "" {
-			return fmt.Errorf("handler.telegram.bot_token and handler.telegram.chat_id must be set")
+	if h.Telegram.Enabled {
+		telegram := section("telegram")
+		if h.Telegram.BotToken == "" {
+			is.errorf(telegram+".bot_token", "must be set")
+		}
+		if h.Telegram.ChatID == "" {
+			is.errorf(telegram+".chat_id", "must be set")
+		} else if _, err := strconv.ParseInt(h.Telegram.ChatID, 10, 64); err != nil {
+			is.errorf(telegram+".chat_id", "must be a numeric chat ID")
 		}
 	}
 
 	// kubernetes
-	if c.Handler.Kubernetes.Enabled {
-		if c.Handler.Kubernetes.Kubeconfig == "" && !c.Handler.Kubernetes.InCluster {
-			return fmt.Errorf("handler.kubernetes.kubeconfig must be set if not running in-cluster")
+	if h.Kubernetes.Enabled {
+		kubernetes := section("kubernetes")
+		if h.Kubernetes.Kubeconfig == "" && !h.Kubernetes.InCluster {
+			is.errorf(kubernetes+".kubeconfig", "must be set if not running in-cluster")
+		}
+
+		if h.Kubernetes.QPS < 0 {
+			is.errorf(kubernetes+".qps", "must not be negative")
+		}
+		if h.Kubernetes.Burst < 0 {
+			is.errorf(kubernetes+".burst", "must not be negative")
+		}
+
+		if h.Kubernetes.VolumeDetachTimeout < 0 {
+			is.errorf(kubernetes+".volume_detach_timeout", "must not be negative")
+		}
+
+		drainFailure := h.Kubernetes.DrainFailure
+		switch drainFailure.Policy {
+		case KubernetesDrainFailAny, KubernetesDrainFailThreshold, KubernetesDrainFailCritical, KubernetesDrainFailNever:
+		default:
+			is.errorf(kubernetes+".drain_failure.policy", "must be one of: any, threshold, critical, never")
+		}
+		if drainFailure.Threshold < 0 || drainFailure.Threshold > 100 {
+			is.errorf(kubernetes+".drain_failure.threshold", "must be between 0 and 100")
+		}
+		if drainFailure.Policy == KubernetesDrainFailCritical && len(drainFailure.CriticalNamespaces) == 0 && drainFailure.CriticalSelector == "" {
+			is.errorf(kubernetes+".drain_failure", "critical_namespaces or critical_selector must be set for the critical policy")
+		}
+		if _, err := labels.Parse(drainFailure.CriticalSelector); err != nil {
+			is.errorf(kubernetes+".drain_failure.critical_selector", "must be a valid label selector: %v", err)
+		}
+
+		coordination := h.Kubernetes.Coordination
+		if coordination.Enabled {
+			if coordination.Namespace == "" {
+				is.errorf(kubernetes+".coordination.namespace", "must be set")
+			}
+			if coordination.ConfigMap == "" {
+				is.errorf(kubernetes+".coordination.config_map", "must be set")
+			}
+			if coordination.MaxConcurrentDrains < 1 {
+				is.errorf(kubernetes+".coordination.max_concurrent_drains", "must be at least 1")
+			}
+			if coordination.MaxWait <= 0 {
+				is.errorf(kubernetes+".coordination.max_wait", "must be positive")
+			}
+			if coordination.Window <= 0 {
+				is.errorf(kubernetes+".coordination.window", "must be positive")
+			}
+		}
+
+		placeholder := h.Kubernetes.Prescale.Placeholder
+		if placeholder.Enabled {
+			if placeholder.Namespace == "" {
+				is.errorf(kubernetes+".prescale.placeholder.namespace", "must be set")
+			}
+			if placeholder.PriorityClass == "" {
+				is.errorf(kubernetes+".prescale.placeholder.priority_class", "must be set")
+			}
+			if placeholder.Image == "" {
+				is.errorf(kubernetes+".prescale.placeholder.image", "must be set")
+			}
+		}
+	}
+
+	// host
+	if h.Host.Enabled {
+		host := section("host")
+		if !h.Host.Docker.Enabled && !h.Host.Systemd.Enabled {
+			is.errorf(host, "docker or systemd must be enabled")
+		}
+		if h.Host.Docker.Enabled && h.Host.Docker.Label == "" {
+			is.errorf(host+".docker.label", "must be set")
+		}
+		if h.Host.Systemd.Enabled && len(h.Host.Systemd.Units) == 0 {
+			is.errorf(host+".systemd.units", "must be set")
 		}
 	}
 
-	// nomad
-	if c.Handler.Kubernetes.Enabled && c.Handler.Nomad.Enabled {
-		return fmt.Errorf("handler.kubernetes and handler.nomad cannot be enabled at the same time")
+	// loadbalancer
+	if h.LoadBalancer.Enabled {
+		loadbalancer := section("loadbalancer")
+		lb := h.LoadBalancer
+		if !lb.Aws.Enabled && !lb.Gcp.Enabled {
+			is.errorf(loadbalancer, "aws or gcp must be enabled")
+		}
+		if lb.Aws.Enabled && !lb.Aws.AutoDiscover && len(lb.Aws.TargetGroupArns) == 0 && len(lb.Aws.ClassicLoadBalancers) == 0 {
+			is.errorf(loadbalancer+".aws", "needs target_group_arns, classic_load_balancers or auto_discover")
+		}
+		if lb.Gcp.Enabled && len(lb.Gcp.InstanceGroups) == 0 {
+			is.errorf(loadbalancer+".gcp.instance_groups", "must be set")
+		}
 	}
 
-	return nil
+	// consul
+	if h.Consul.Enabled {
+		consul := section("consul")
+		if h.Consul.Mode != ConsulModeMaintenance && h.Consul.Mode != ConsulModeDeregister {
+			is.errorf(consul+".mode", "must be %s or %s", ConsulModeMaintenance, ConsulModeDeregister)
+		}
+		if h.Consul.Wait >= h.ProcessingTimeout {
+			is.errorf(consul+".wait", "must be less than handler.processing_timeout")
+		}
+	}
+}
+
+// ForInstance returns a copy of the handler config with the instance settings
+// applied on top of the section of the instance type, with that section
+// enabled. Types without a section, like plugins, are returned unchanged.
+func (c HandlerConfig) ForInstance(instance HandlerInstanceConfig) (HandlerConfig, error) {
+	var err error
+
+	switch HandlerName(instance.Type) {
+	case HandlerNameKubernetes:
+		c.Kubernetes, err = overlaySettings(c.Kubernetes, instance.Settings)
+		c.Kubernetes.Enabled = true
+	case HandlerNameNomad:
+		c.Nomad, err = overlaySettings(c.Nomad, instance.Settings)
+		c.Nomad.Enabled = true
+	case HandlerNameConsul:
+		c.Consul, err = overlaySettings(c.Consul, instance.Settings)
+		c.Consul.Enabled = true
+	case HandlerNameTelegram:
+		c.Telegram, err = overlaySettings(c.Telegram, instance.Settings)
+		c.Telegram.Enabled = true
+	case HandlerNameHost:
+		c.Host, err = overlaySettings(c.Host, instance.Settings)
+		c.Host.Enabled = true
+	case HandlerNameLoadBalancer:
+		c.LoadBalancer, err = overlaySettings(c.LoadBalancer, instance.Settings)
+		c.LoadBalancer.Enabled = true
+	default:
+		return c, nil
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("invalid %s settings: %w", instance.Type, err)
+	}
+
+	// the section read its own *_file values on load, these are the instance ones
+	var issues configIssues
+	resolveSecretFiles(c.secretFields(), &issues)
+	parseHandlerDurationFields(&c, &issues)
+
+	return c, issues.err()
+}
+
+// overlaySettings decodes settings on top of a copy of section
+func overlaySettings[T any](section T, settings map[string]interface{}) (T, error) {
+	if len(settings) == 0 {
+		return section, nil
+	}
+
+	// go through a map so slices of section are never shared with the result
+	base := make(map[string]interface{})
+	if err := mapstructure.Decode(section, &base); err != nil {
+		return section, err
+	}
+	mergeSettings(base, settings)
+
+	var out T
+	if err := decodeSettings(base, &out); err != nil {
+		return section, err
+	}
+
+	return out, nil
+}
+
+// mergeSettings merges src into dst, nested maps are merged key by key
+func mergeSettings(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			if dstMap, ok := dst[key].(map[string]interface{}); ok {
+				mergeSettings(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// decodeSettings decodes raw settings into out, weakly typed like viper so
+// "true" or "30s" from YAML and environment variables are accepted
+func decodeSettings(settings map[string]interface{}, out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           out,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(settings)
 }
 
 // ConfigItem represents a configuration item with its environment variable mapping and default value
@@ -191,19 +1119,143 @@ var configItems = []ConfigItem{
 	{"PROVIDER_POLL_INTERVAL", "provider.poll_interval", "3s"},
 	{"PROVIDER_REQUEST_TIMEOUT", "provider.request_timeout", "2s"},
 	{"PROVIDER_DUMMY_DETECTION_WAIT", "provider.dummy.detection_wait", "10s"},
+	{"PROVIDER_DUMMY_SCENARIO", "provider.dummy.scenario", ""},
+	{"PROVIDER_AWS_POLL_INTERVAL", "provider.aws.poll_interval", ""},
+	{"PROVIDER_AWS_REQUEST_TIMEOUT", "provider.aws.request_timeout", ""},
+	{"PROVIDER_AWS_ENDPOINT", "provider.aws.endpoint", AwsMetaDataBaseUrl},
+	{"PROVIDER_AWS_SIGNALS", "provider.aws.signals", []string{ProviderSignalSpot}},
+	{"PROVIDER_AWS_TOKEN_TTL", "provider.aws.token_ttl", "60s"},
+	{"PROVIDER_AWS_MAINTENANCE_LEAD_TIME", "provider.aws.maintenance_lead_time", "30m"},
+	{"PROVIDER_GCP_POLL_INTERVAL", "provider.gcp.poll_interval", ""},
+	{"PROVIDER_GCP_REQUEST_TIMEOUT", "provider.gcp.request_timeout", ""},
+	{"PROVIDER_GCP_ENDPOINT", "provider.gcp.endpoint", GcpMetaDataBaseUrl},
+	{"PROVIDER_GCP_SIGNALS", "provider.gcp.signals", []string{ProviderSignalSpot}},
+	{"PROVIDER_GCP_HANGING_GET", "provider.gcp.hanging_get", false},
+	{"PROVIDER_ALICLOUD_POLL_INTERVAL", "provider.alicloud.poll_interval", ""},
+	{"PROVIDER_ALICLOUD_REQUEST_TIMEOUT", "provider.alicloud.request_timeout", ""},
+	{"PROVIDER_ALICLOUD_ENDPOINT", "provider.alicloud.endpoint", AlicloudMetaDataBaseUrl},
+	{"PROVIDER_ALICLOUD_SIGNALS", "provider.alicloud.signals", []string{ProviderSignalSpot}},
+	{"PROVIDER_ALICLOUD_TOKEN_TTL", "provider.alicloud.token_ttl", "60s"},
+	{"PROVIDER_TENCENT_POLL_INTERVAL", "provider.tencent.poll_interval", ""},
+	{"PROVIDER_TENCENT_REQUEST_TIMEOUT", "provider.tencent.request_timeout", ""},
+	{"PROVIDER_TENCENT_ENDPOINT", "provider.tencent.endpoint", TencentMetaDataBaseUrl},
+	{"PROVIDER_TENCENT_SIGNALS", "provider.tencent.signals", []string{ProviderSignalSpot}},
+	{"PROVIDER_HUAWEI_POLL_INTERVAL", "provider.huawei.poll_interval", ""},
+	{"PROVIDER_HUAWEI_REQUEST_TIMEOUT", "provider.huawei.request_timeout", ""},
+	{"PROVIDER_HUAWEI_ENDPOINT", "provider.huawei.endpoint", HuaweiMetaDataBaseUrl},
+	{"PROVIDER_HUAWEI_SIGNALS", "provider.huawei.signals", []string{ProviderSignalSpot}},
+	{"PROVIDER_HUAWEI_TOKEN_TTL", "provider.huawei.token_ttl", "60s"},
 	{"LOG_LEVEL", "log.level", "info"},
 	{"LOG_FORMAT", "log.format", "json"},
+	{"CONTROL_SOCKET", "control.socket", ""},
+	{"STATE_BACKEND", "state.backend", ""},
+	{"STATE_PATH", "state.path", "/var/lib/evacuator/state.json"},
+	{"STATE_NAMESPACE", "state.namespace", "kube-system"},
+	{"STATE_CONFIG_MAP", "state.config_map", "evacuator-state"},
+	{"STATE_IN_CLUSTER", "state.in_cluster", true},
+	{"STATE_KUBECONFIG", "state.kubeconfig", ""},
+	{"STATE_TTL", "state.ttl", "24h"},
+	{"RELOAD_WATCH", "reload.watch", false},
+	{"SECRETS_VAULT_ADDRESS", "secrets.vault.address", ""},
+	{"SECRETS_VAULT_NAMESPACE", "secrets.vault.namespace", ""},
+	{"SECRETS_VAULT_CA_FILE", "secrets.vault.ca_file", ""},
+	{"SECRETS_VAULT_KV_MOUNT", "secrets.vault.kv_mount", "secret"},
+	{"SECRETS_VAULT_AUTH", "secrets.vault.auth", VaultAuthToken},
+	{"SECRETS_VAULT_TOKEN", "secrets.vault.token", ""},
+	{"SECRETS_VAULT_TOKEN_FILE", "secrets.vault.token_file", ""},
+	{"SECRETS_VAULT_KUBERNETES_ROLE", "secrets.vault.kubernetes.role", ""},
+	{"SECRETS_VAULT_KUBERNETES_MOUNT", "secrets.vault.kubernetes.mount", "kubernetes"},
+	{"SECRETS_VAULT_KUBERNETES_TOKEN_PATH", "secrets.vault.kubernetes.token_path", VaultKubernetesTokenPath},
+	{"CLUSTER_MODE", "cluster.mode", "standalone"},
+	{"CLUSTER_TOKEN", "cluster.token", ""},
+	{"CLUSTER_TOKEN_FILE", "cluster.token_file", ""},
+	{"CLUSTER_KUBECONFIG", "cluster.kubeconfig", ""},
+	{"CLUSTER_IN_CLUSTER", "cluster.in_cluster", true},
+	{"CLUSTER_AGENT_PUBLISH", "cluster.agent.publish", "annotation"},
+	{"CLUSTER_AGENT_CONTROLLER_URL", "cluster.agent.controller_url", ""},
+	{"CLUSTER_CONTROLLER_LISTEN_ADDRESS", "cluster.controller.listen_address", ":8080"},
+	{"CLUSTER_CONTROLLER_LEASE_NAME", "cluster.controller.lease_name", "evacuator-controller"},
+	{"CLUSTER_CONTROLLER_LEASE_NAMESPACE", "cluster.controller.lease_namespace", "kube-system"},
+	{"CLUSTER_CONTROLLER_IDENTITY", "cluster.controller.identity", ""},
+	{"CLUSTER_CONTROLLER_LEASE_DURATION", "cluster.controller.lease_duration", "15s"},
+	{"CLUSTER_CONTROLLER_RENEW_DEADLINE", "cluster.controller.renew_deadline", "10s"},
+	{"CLUSTER_CONTROLLER_RETRY_PERIOD", "cluster.controller.retry_period", "2s"},
+	{"CLUSTER_CONTROLLER_MAX_CONCURRENT_NODES", "cluster.controller.max_concurrent_nodes", 0},
 	{"HANDLER_PROCESSING_TIMEOUT", "handler.processing_timeout", "75s"},
+	{"HANDLER_RECOVERY_GRACE_PERIOD", "handler.recovery_grace_period", "0s"},
+	{"HANDLER_DEDUP_WINDOW", "handler.dedup_window", "1h"},
 	{"HANDLER_KUBERNETES_ENABLED", "handler.kubernetes.enabled", false},
 	{"HANDLER_KUBERNETES_SKIP_DAEMON_SETS", "handler.kubernetes.skip_daemon_sets", true},
 	{"HANDLER_KUBERNETES_DELETE_EMPTY_DIR_DATA", "handler.kubernetes.delete_empty_dir_data", false},
 	{"HANDLER_KUBERNETES_KUBECONFIG", "handler.kubernetes.kubeconfig", ""},
 	{"HANDLER_KUBERNETES_IN_CLUSTER", "handler.kubernetes.in_cluster", true},
+	{"HANDLER_KUBERNETES_QPS", "handler.kubernetes.qps", 50},
+	{"HANDLER_KUBERNETES_BURST", "handler.kubernetes.burst", 100},
+	{"HANDLER_KUBERNETES_VERIFY_PERMISSIONS", "handler.kubernetes.verify_permissions", true},
+	{"HANDLER_KUBERNETES_KEEPALIVE_INTERVAL", "handler.kubernetes.keepalive_interval", "30s"},
+	{"HANDLER_KUBERNETES_POD_CACHE", "handler.kubernetes.pod_cache", true},
+	{"HANDLER_KUBERNETES_TAINT", "handler.kubernetes.taint", KubernetesTerminatingTaint},
+	{"HANDLER_KUBERNETES_VOLUME_DETACH_TIMEOUT", "handler.kubernetes.volume_detach_timeout", "30s"},
+	{"HANDLER_KUBERNETES_DRAIN_FAILURE_POLICY", "handler.kubernetes.drain_failure.policy", KubernetesDrainFailThreshold},
+	{"HANDLER_KUBERNETES_DRAIN_FAILURE_THRESHOLD", "handler.kubernetes.drain_failure.threshold", 50},
+	{"HANDLER_KUBERNETES_DRAIN_FAILURE_CRITICAL_NAMESPACES", "handler.kubernetes.drain_failure.critical_namespaces", []string{}},
+	{"HANDLER_KUBERNETES_DRAIN_FAILURE_CRITICAL_SELECTOR", "handler.kubernetes.drain_failure.critical_selector", ""},
+	{"HANDLER_KUBERNETES_COORDINATION_ENABLED", "handler.kubernetes.coordination.enabled", false},
+	{"HANDLER_KUBERNETES_COORDINATION_NAMESPACE", "handler.kubernetes.coordination.namespace", "kube-system"},
+	{"HANDLER_KUBERNETES_COORDINATION_CONFIG_MAP", "handler.kubernetes.coordination.config_map", "evacuator-coordination"},
+	{"HANDLER_KUBERNETES_COORDINATION_MAX_CONCURRENT_DRAINS", "handler.kubernetes.coordination.max_concurrent_drains", 3},
+	{"HANDLER_KUBERNETES_COORDINATION_MAX_WAIT", "handler.kubernetes.coordination.max_wait", "30s"},
+	{"HANDLER_KUBERNETES_COORDINATION_WINDOW", "handler.kubernetes.coordination.window", "10m"},
+	{"HANDLER_KUBERNETES_PRESCALE_KARPENTER", "handler.kubernetes.prescale.karpenter", false},
+	{"HANDLER_KUBERNETES_PRESCALE_PLACEHOLDER_ENABLED", "handler.kubernetes.prescale.placeholder.enabled", false},
+	{"HANDLER_KUBERNETES_PRESCALE_PLACEHOLDER_NAMESPACE", "handler.kubernetes.prescale.placeholder.namespace", "kube-system"},
+	{"HANDLER_KUBERNETES_PRESCALE_PLACEHOLDER_PRIORITY_CLASS", "handler.kubernetes.prescale.placeholder.priority_class", "evacuator-placeholder"},
+	{"HANDLER_KUBERNETES_PRESCALE_PLACEHOLDER_IMAGE", "handler.kubernetes.prescale.placeholder.image", KubernetesPlaceholderImage},
 	{"HANDLER_TELEGRAM_ENABLED", "handler.telegram.enabled", false},
 	{"HANDLER_TELEGRAM_BOT_TOKEN", "handler.telegram.bot_token", ""},
+	{"HANDLER_TELEGRAM_BOT_TOKEN_FILE", "handler.telegram.bot_token_file", ""},
 	{"HANDLER_TELEGRAM_CHAT_ID", "handler.telegram.chat_id", ""},
 	{"HANDLER_NOMAD_ENABLED", "handler.nomad.enabled", false},
 	{"HANDLER_NOMAD_FORCE", "handler.nomad.force", false},
+	{"HANDLER_HOST_ENABLED", "handler.host.enabled", false},
+	{"HANDLER_HOST_DOCKER_ENABLED", "handler.host.docker.enabled", false},
+	{"HANDLER_HOST_DOCKER_SOCKET", "handler.host.docker.socket", "/var/run/docker.sock"},
+	{"HANDLER_HOST_DOCKER_LABEL", "handler.host.docker.label", "evacuator.stop=true"},
+	{"HANDLER_HOST_DOCKER_STOP_TIMEOUT", "handler.host.docker.stop_timeout", "30s"},
+	{"HANDLER_HOST_SYSTEMD_ENABLED", "handler.host.systemd.enabled", false},
+	{"HANDLER_HOST_SYSTEMD_UNITS", "handler.host.systemd.units", []string{}},
+	{"HANDLER_CONSUL_ENABLED", "handler.consul.enabled", false},
+	{"HANDLER_CONSUL_ADDRESS", "handler.consul.address", ""},
+	{"HANDLER_CONSUL_TOKEN", "handler.consul.token", ""},
+	{"HANDLER_CONSUL_TOKEN_FILE", "handler.consul.token_file", ""},
+	{"HANDLER_CONSUL_MODE", "handler.consul.mode", ConsulModeMaintenance},
+	{"HANDLER_CONSUL_REASON", "handler.consul.reason", "evacuator"},
+	{"HANDLER_CONSUL_SERVICES", "handler.consul.services", []string{}},
+	{"HANDLER_CONSUL_WAIT", "handler.consul.wait", "10s"},
+	{"HANDLER_CONSUL_TLS_CA_FILE", "handler.consul.tls.ca_file", ""},
+	{"HANDLER_CONSUL_TLS_CERT_FILE", "handler.consul.tls.cert_file", ""},
+	{"HANDLER_CONSUL_TLS_KEY_FILE", "handler.consul.tls.key_file", ""},
+	{"HANDLER_CONSUL_TLS_SERVER_NAME", "handler.consul.tls.server_name", ""},
+	{"HANDLER_CONSUL_TLS_INSECURE_SKIP_VERIFY", "handler.consul.tls.insecure_skip_verify", false},
+	{"HANDLER_STRICT", "handler.strict", false},
+	{"HANDLER_DRY_RUN", "handler.dry_run", false},
+	{"HANDLER_PLUGIN_DIR", "handler.plugin_dir", ""},
+	{"HANDLER_PLUGIN_AUTO_DISCOVER", "handler.plugin_auto_discover", false},
+	{"HANDLER_LOADBALANCER_ENABLED", "handler.loadbalancer.enabled", false},
+	{"HANDLER_LOADBALANCER_DRAIN_TIMEOUT", "handler.loadbalancer.drain_timeout", "30s"},
+	{"HANDLER_LOADBALANCER_AWS_ENABLED", "handler.loadbalancer.aws.enabled", false},
+	{"HANDLER_LOADBALANCER_AWS_REGION", "handler.loadbalancer.aws.region", ""},
+	{"HANDLER_LOADBALANCER_AWS_ENDPOINT", "handler.loadbalancer.aws.endpoint", ""},
+	{"HANDLER_LOADBALANCER_AWS_TARGET_GROUP_ARNS", "handler.loadbalancer.aws.target_group_arns", []string{}},
+	{"HANDLER_LOADBALANCER_AWS_CLASSIC_LOAD_BALANCERS", "handler.loadbalancer.aws.classic_load_balancers", []string{}},
+	{"HANDLER_LOADBALANCER_AWS_AUTO_DISCOVER", "handler.loadbalancer.aws.auto_discover", false},
+	{"HANDLER_LOADBALANCER_GCP_ENABLED", "handler.loadbalancer.gcp.enabled", false},
+	{"HANDLER_LOADBALANCER_GCP_ENDPOINT", "handler.loadbalancer.gcp.endpoint", LoadBalancerGcpComputeUrl},
+	{"HANDLER_LOADBALANCER_GCP_METADATA_ENDPOINT", "handler.loadbalancer.gcp.metadata_endpoint", LoadBalancerGcpMetadataUrl},
+	{"HANDLER_LOADBALANCER_GCP_PROJECT", "handler.loadbalancer.gcp.project", ""},
+	{"HANDLER_LOADBALANCER_GCP_ZONE", "handler.loadbalancer.gcp.zone", ""},
+	{"HANDLER_LOADBALANCER_GCP_INSTANCE_NAME", "handler.loadbalancer.gcp.instance_name", ""},
+	{"HANDLER_LOADBALANCER_GCP_INSTANCE_GROUPS", "handler.loadbalancer.gcp.instance_groups", []string{}},
 }
 
 // setDefaults sets default values for configuration
@@ -224,54 +1276,3 @@ func setupEnvironmentMapping(v *viper.Viper) {
 		v.BindEnv(item.YamlKey, item.EnvVar)
 	}
 }
-
-// Global configuration management
-var (
-	// globalConfig holds the application configuration
-	// Set once at startup, read-only during runtime
-	globalConfig *Config
-)
-
-// SetGlobalConfig sets the global configuration for the application
-// This should be called once during application startup
-func SetGlobalConfig(config *Config) {
-	globalConfig = config
-}
-
-// GetGlobalConfig returns the global configuration
-// Returns nil if configuration hasn't been initialized
-func GetGlobalConfig() *Config {
-	return globalConfig
-}
-
-// GetProviderConfig returns the provider configuration
-func GetProviderConfig() ProviderConfig {
-	if globalConfig == nil {
-		return ProviderConfig{}
-	}
-	return globalConfig.Provider
-}
-
-// GetHandlerConfig returns the handler configuration
-func GetHandlerConfig() HandlerConfig {
-	if globalConfig == nil {
-		return HandlerConfig{}
-	}
-	return globalConfig.Handler
-}
-
-// GetLogConfig returns the log configuration
-func GetLogConfig() LogConfig {
-	if globalConfig == nil {
-		return LogConfig{}
-	}
-	return globalConfig.Log
-}
-
-// GetNodeName returns the configured node name
-func GetNodeName() string {
-	if globalConfig == nil {
-		return ""
-	}
-	return globalConfig.NodeName
-}
@@ -0,0 +1,145 @@
+package evacuator
+
+import (
+	"reflect"
+	"strings"
+)
+
+//go:generate sh -c "go run ./cmd/evacuator schema > config.schema.json"
+
+// configEnums are the allowed values of the enumerated settings, by YAML key
+var configEnums = map[string][]string{
+	"provider.name":         {"", string(ProviderAWS), string(ProviderGcp), string(ProviderAlicloud), string(ProviderTencent), string(ProviderHuawei), string(ProviderDummy)},
+	"log.level":             {"debug", "info", "warn", "error"},
+	"log.format":            {"text", "json"},
+	"cluster.mode":          {ClusterModeStandalone, ClusterModeAgent, ClusterModeController},
+	"cluster.agent.publish": {ClusterPublishAnnotation, ClusterPublishHttp},
+	"state.backend":         {"", StateBackendFile, StateBackendConfigMap, StateBackendAnnotation},
+	"secrets.vault.auth":    {VaultAuthToken, VaultAuthKubernetes},
+	"handler.consul.mode":   {ConsulModeMaintenance, ConsulModeDeregister},
+	"handler.kubernetes.drain_failure.policy": {KubernetesDrainFailAny, KubernetesDrainFailThreshold, KubernetesDrainFailCritical, KubernetesDrainFailNever},
+}
+
+func init() {
+	// the signals each metadata provider supports
+	for name, signals := range providerSignals {
+		configEnums["provider."+string(name)+".signals"] = signals
+	}
+}
+
+// durationPattern matches the values time.ParseDuration accepts
+const durationPattern = `^(0|(([0-9]*[.])?[0-9]+(ns|us|µs|ms|s|m|h))+)$`
+
+// inheritedDurationPattern also matches the empty value of durations
+// inherited from the enclosing section, e.g. provider.aws.poll_interval
+const inheritedDurationPattern = `^$|` + durationPattern
+
+// ConfigSchema returns a JSON Schema of the config file, generated from Config
+// and the configuration items, for editor completion and checking config
+// files. Durations and enumerated settings also accept secret references.
+func ConfigSchema() map[string]interface{} {
+	items := make(map[string]ConfigItem)
+	for _, item := range configItems {
+		items[item.YamlKey] = item
+	}
+
+	schema := structSchema(reflect.TypeOf(Config{}), "", items)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "evacuator configuration"
+	schema["$defs"] = map[string]interface{}{
+		"reference": map[string]interface{}{
+			"type":        "string",
+			"pattern":     secretReferencePattern.String(),
+			"description": "${env:NAME}, ${file:/path} or ${vault:path#key} reference",
+		},
+	}
+
+	return schema
+}
+
+// structSchema returns the object schema of a config struct, path is its YAML key
+func structSchema(t reflect.Type, path string, items map[string]ConfigItem) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		// embedded sections share the keys of the struct
+		if tag == ",squash" {
+			embedded := structSchema(field.Type, path, items)
+			for key, property := range embedded["properties"].(map[string]interface{}) {
+				properties[key] = property
+			}
+			continue
+		}
+
+		key := tag
+		if path != "" {
+			key = path + "." + tag
+		}
+		properties[tag] = fieldSchema(field, key, items)
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func fieldSchema(field reflect.StructField, key string, items map[string]ConfigItem) map[string]interface{} {
+	var schema map[string]interface{}
+
+	values, enumerated := configEnums[key]
+	switch {
+	case enumerated && field.Type.Kind() == reflect.Slice:
+		schema = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "enum": values}}
+	case enumerated:
+		schema = map[string]interface{}{"type": "string", "enum": values}
+	case strings.HasSuffix(field.Name, "Raw"):
+		// parsed into the time.Duration field next to it
+		pattern := durationPattern
+		if item, ok := items[key]; ok && item.DefaultValue == "" {
+			pattern = inheritedDurationPattern
+		}
+		schema = map[string]interface{}{"type": "string", "pattern": pattern}
+	default:
+		schema = typeSchema(field.Type, key, items)
+	}
+
+	if (enumerated && schema["enum"] != nil) || schema["pattern"] != nil {
+		schema = map[string]interface{}{
+			"anyOf": []interface{}{schema, map[string]interface{}{"$ref": "#/$defs/reference"}},
+		}
+	}
+
+	if item, ok := items[key]; ok {
+		schema["default"] = item.DefaultValue
+		schema["description"] = "Environment variable " + item.EnvVar
+	}
+
+	return schema
+}
+
+func typeSchema(t reflect.Type, path string, items map[string]ConfigItem) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), path, items)}
+	case reflect.Struct:
+		return structSchema(t, path, items)
+	default:
+		// instance settings, decoded by the handler type
+		return map[string]interface{}{"type": "object"}
+	}
+}
@@ -0,0 +1,139 @@
+package evacuator
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// terminationReasonSeverity orders the reasons, a notice is handled again
+// when the same instance gets a more severe one. A rebalance recommendation is
+// only a warning, maintenance may still end in a live migration, a spot
+// termination always ends the instance.
+var terminationReasonSeverity = map[TerminationReason]int{
+	TerminationReasonRebalance:   0,
+	TerminationReasonMaintenance: 1,
+	TerminationReasonSpot:        2,
+}
+
+// EventKey identifies the notice of an instance across repeated signals and
+// restarts, e.g. i-0abc/spot-termination. Handlers receive it in
+// TerminationEvent.Key to make their side effects idempotent.
+func EventKey(event TerminationEvent) string {
+	return InstanceKey(event) + "/" + strings.ReplaceAll(string(event.Reason), " ", "-")
+}
+
+// EventDeduplicator drops the signals of a notice that was already received,
+// e.g. a provider reporting the same termination on every poll or through
+// several endpoints. A notice with a more severe reason for the same instance
+// is an escalation and goes through.
+type EventDeduplicator struct {
+	logger *slog.Logger
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]dedupEntry // by instance
+}
+
+type dedupEntry struct {
+	key        string
+	reason     TerminationReason
+	seenAt     time.Time
+	hostname   string
+	instanceID string
+}
+
+// NewEventDeduplicator returns a deduplicator remembering notices for window,
+// zero to let every event through
+func NewEventDeduplicator(logger *slog.Logger, window time.Duration) *EventDeduplicator {
+	return &EventDeduplicator{
+		logger: logger,
+		window: window,
+		seen:   make(map[string]dedupEntry),
+	}
+}
+
+// Admit sets the key of the event and reports whether it is to be handled.
+// Cancelled events always are, and make the instance forgotten.
+func (d *EventDeduplicator) Admit(event TerminationEvent) (TerminationEvent, bool) {
+	event.Key = EventKey(event)
+
+	if event.Cancelled {
+		d.Forget(event)
+		return event, true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.window <= 0 {
+		return event, true
+	}
+
+	instance := InstanceKey(event)
+	now := time.Now()
+
+	previous, ok := d.seen[instance]
+	if ok && now.Sub(previous.seenAt) <= d.window {
+		if terminationReasonSeverity[event.Reason] <= terminationReasonSeverity[previous.reason] {
+			d.logger.Info("duplicate termination event dropped", "key", event.Key, "handled_key", previous.key, "instance_id", event.InstanceID)
+			return event, false
+		}
+
+		d.logger.Warn("termination notice escalated, handling it again", "key", event.Key, "previous_key", previous.key, "instance_id", event.InstanceID)
+	}
+
+	d.seen[instance] = dedupEntry{
+		key:        event.Key,
+		reason:     event.Reason,
+		seenAt:     now,
+		hostname:   event.Hostname,
+		instanceID: event.InstanceID,
+	}
+
+	// entries of instances long gone are of no use
+	for key, entry := range d.seen {
+		if now.Sub(entry.seenAt) > d.window {
+			delete(d.seen, key)
+		}
+	}
+
+	return event, true
+}
+
+// SetWindow changes how long notices are remembered, e.g. on a configuration
+// reload
+func (d *EventDeduplicator) SetWindow(window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.window = window
+}
+
+// Forget drops the notices of the instance, e.g. once it recovered, so a
+// later notice is handled again
+func (d *EventDeduplicator) Forget(event TerminationEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.seen, InstanceKey(event))
+}
+
+// notices returns the hostname and instance ID of the notices remembered for
+// the node hostname, or for every node when it is empty
+func (d *EventDeduplicator) notices(hostname string) []TerminationEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var notices []TerminationEvent
+	for _, entry := range d.seen {
+		if time.Since(entry.seenAt) > d.window || !knownInstanceID(entry.instanceID) {
+			continue
+		}
+		if hostname == "" || entry.hostname == hostname {
+			notices = append(notices, TerminationEvent{Hostname: entry.hostname, InstanceID: entry.instanceID})
+		}
+	}
+	return notices
+}
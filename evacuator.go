@@ -0,0 +1,1011 @@
+package evacuator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// GracefulShutdownTimeout is the maximum time Run waits for the provider and
+	// the handling in progress to stop once its context is done
+	GracefulShutdownTimeout = 10 * time.Second
+
+	// StateStoreTimeout bounds every read and write of the state store
+	StateStoreTimeout = 5 * time.Second
+)
+
+// HandlerResult represents the result of processing a termination event by a handler
+type HandlerResult struct {
+	HandlerName string
+	Error       error
+	ProcessedAt time.Time
+}
+
+// Evacuator watches the provider of the instance, or the agents in controller
+// mode, and passes termination events to the handlers created from its
+// configuration and to its subscribers. Several evacuators with their own
+// configuration can run in the same process.
+type Evacuator struct {
+	logger *slog.Logger
+	store  StateStore
+	dedup  *EventDeduplicator
+
+	// load reads the configuration again on Reload, nil when there is no source
+	load      func() (*Config, error)
+	factories map[HandlerName]HandlerFactory
+
+	// handlers passed with WithHandler, kept on reload
+	extraHandlers []Handler
+
+	// reloadMu serializes reloads, mu guards the fields below
+	reloadMu sync.Mutex
+
+	mu          sync.Mutex
+	config      *Config
+	handlers    *handlerSet
+	provider    Provider
+	runCtx      context.Context
+	background  map[string]context.CancelFunc
+	subscribers []func(TerminationEvent)
+	status      ReloadStatus
+
+	// recoveries passes manual recoveries to the running event handling
+	recoveries chan recoveryRequest
+
+	// inFlight is the handling in progress of the running event handling
+	inFlightMu sync.Mutex
+	inFlight   map[string]*inFlightEvent
+}
+
+// Option configures an Evacuator created by New
+type Option func(*options) error
+
+type options struct {
+	config    *Config
+	load      func() (*Config, error)
+	logger    *slog.Logger
+	handlers  []Handler
+	factories map[HandlerName]HandlerFactory
+}
+
+// WithConfig uses config instead of the configuration from the environment
+func WithConfig(config *Config) Option {
+	return func(o *options) error {
+		o.config = config
+		return nil
+	}
+}
+
+// WithConfigFile loads the configuration from the file and the environment,
+// like the --config flag of the command. Reload reads the file again.
+func WithConfigFile(path string) Option {
+	return func(o *options) error {
+		o.load = func() (*Config, error) {
+			config, err := LoadConfig(path, viper.New())
+			if err != nil {
+				return nil, fmt.Errorf("failed to load config file: %w", err)
+			}
+			return config, nil
+		}
+
+		config, err := o.load()
+		if err != nil {
+			return err
+		}
+		o.config = config
+		return nil
+	}
+}
+
+// WithConfigLoader sets how Reload reads the configuration again
+func WithConfigLoader(load func() (*Config, error)) Option {
+	return func(o *options) error {
+		o.load = load
+		return nil
+	}
+}
+
+// WithLogger sets the logger of the evacuator and its handlers, slog.Default()
+// when not set
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) error {
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithHandler adds a handler created by the caller to the configured ones. It
+// runs in every cluster mode, also on agents.
+func WithHandler(handler Handler) Option {
+	return func(o *options) error {
+		o.handlers = append(o.handlers, handler)
+		return nil
+	}
+}
+
+// WithHandlerFactory registers a handler type, usable as the type of
+// handler.instances entries
+func WithHandlerFactory(name HandlerName, factory HandlerFactory) Option {
+	return func(o *options) error {
+		o.factories[name] = factory
+		return nil
+	}
+}
+
+// New creates an evacuator with its handlers and state store. Without
+// WithConfig or WithConfigFile the configuration is read from the environment
+// and defaults. Providers are only created and detected by Run.
+func New(opts ...Option) (*Evacuator, error) {
+	o := &options{
+		factories: make(map[HandlerName]HandlerFactory),
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.config == nil {
+		config, err := LoadConfig("", viper.New())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		o.config = config
+	}
+	if o.logger == nil {
+		o.logger = slog.Default()
+	}
+
+	e := &Evacuator{
+		logger:        o.logger,
+		dedup:         NewEventDeduplicator(o.logger, o.config.Handler.DedupWindow),
+		load:          o.load,
+		factories:     o.factories,
+		extraHandlers: o.handlers,
+		config:        o.config,
+		background:    make(map[string]context.CancelFunc),
+		recoveries:    make(chan recoveryRequest),
+		status: ReloadStatus{
+			Generation: 1,
+			AppliedAt:  time.Now(),
+		},
+	}
+
+	logConfigWarnings(o.config, o.logger)
+
+	handlers, err := e.newHandlerSet(o.config, nil)
+	if err != nil {
+		return nil, err
+	}
+	e.handlers = handlers
+
+	store, err := newStateStore(o.config, o.logger)
+	if err != nil {
+		closeHandlers(handlers.handlers)
+		return nil, err
+	}
+	e.store = store
+
+	return e, nil
+}
+
+// logConfigWarnings logs the warnings LoadConfig found in the configuration
+func logConfigWarnings(config *Config, logger *slog.Logger) {
+	for _, warning := range config.Warnings {
+		logger.Warn("configuration warning", "path", warning.Path, "warning", warning.Message)
+	}
+}
+
+// NewEvacuator creates an evacuator for the configuration, see New
+func NewEvacuator(config *Config, logger *slog.Logger) (*Evacuator, error) {
+	return New(WithConfig(config), WithLogger(logger))
+}
+
+// Handlers returns the handlers termination events are passed to
+func (e *Evacuator) Handlers() []Handler {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.handlers.handlers
+}
+
+// currentConfig returns the running configuration
+func (e *Evacuator) currentConfig() *Config {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.config
+}
+
+// Subscribe registers fn to be called with every termination event Run
+// handles, including cancelled notices, see TerminationEvent.Cancelled. fn is
+// called in its own goroutine while the handlers run, and should return
+// before the event deadline.
+func (e *Evacuator) Subscribe(fn func(TerminationEvent)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.subscribers = append(e.subscribers, fn)
+}
+
+// subscribed returns the subscribers registered so far
+func (e *Evacuator) subscribed() []func(TerminationEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.subscribers
+}
+
+// newClusterAgentHandler returns the publisher replacing the handlers of agents
+func newClusterAgentHandler(config *Config, logger *slog.Logger) (Handler, error) {
+	clusterConfig := config.Cluster
+
+	agent, err := NewClusterAgentHandler(&ClusterAgentHandlerConfig{
+		Logger:        logger,
+		Publish:       clusterConfig.Agent.Publish,
+		ControllerUrl: clusterConfig.Agent.ControllerUrl,
+		Token:         clusterConfig.Token,
+		InCluster:     clusterConfig.InCluster,
+		Kubeconfig:    clusterConfig.Kubeconfig,
+		DryRun:        config.Handler.DryRun,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster agent: %w", err)
+	}
+
+	// the controller runs the handlers, the agent only publishes
+	logger.Info("running as cluster agent, configured handlers are run by the controller", "publish", clusterConfig.Agent.Publish)
+	return agent, nil
+}
+
+// newStateStore returns the configured state store, nil without a backend.
+// Dry runs do not persist state, it would make a later real run skip handlers.
+func newStateStore(config *Config, logger *slog.Logger) (StateStore, error) {
+	stateConfig := config.State
+
+	if stateConfig.Backend == "" {
+		return nil, nil
+	}
+
+	if config.Handler.DryRun {
+		logger.Info("dry run enabled, event state is not persisted", "backend", stateConfig.Backend)
+		return nil, nil
+	}
+
+	// a controller handles the events of every node, named by their hostname
+	nodeName := config.NodeName
+	if config.Cluster.Mode == ClusterModeController {
+		nodeName = ""
+	}
+
+	store, err := NewStateStore(&StateStoreConfig{
+		Backend:    stateConfig.Backend,
+		Path:       stateConfig.Path,
+		Namespace:  stateConfig.Namespace,
+		ConfigMap:  stateConfig.ConfigMap,
+		InCluster:  stateConfig.InCluster,
+		Kubeconfig: stateConfig.Kubeconfig,
+		NodeName:   nodeName,
+		TTL:        stateConfig.TTL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state store: %w", err)
+	}
+
+	logger.Info("event state store enabled", "backend", stateConfig.Backend)
+	return store, nil
+}
+
+// NewProviders returns all available providers for the configuration
+func NewProviders(config *Config, logger *slog.Logger) ([]Provider, error) {
+	providerConfig := config.Provider
+
+	// each metadata provider with its own section
+	metadataConfig := func(name ProviderName) *MetadataProviderConfig {
+		c := providerConfig.MetadataProviderConfig(name)
+		c.Logger = logger
+		return c
+	}
+
+	// Register all available providers
+	providers := []Provider{
+		NewAwsProvider(metadataConfig(ProviderAWS)),
+		NewAlicloudProvider(metadataConfig(ProviderAlicloud)),
+		NewTencentProvider(metadataConfig(ProviderTencent)),
+		NewGcpProvider(metadataConfig(ProviderGcp)),
+		NewHuaweiProvider(metadataConfig(ProviderHuawei)),
+	}
+
+	if providerConfig.Name == string(ProviderDummy) {
+		dummyConfig := &DummyProviderConfig{
+			Logger:         logger,
+			DetectionWait:  providerConfig.Dummy.DetectionWait,
+			RequestTimeout: providerConfig.RequestTimeout,
+		}
+
+		if providerConfig.Dummy.Scenario != "" {
+			scenario, err := LoadDummyScenario(providerConfig.Dummy.Scenario)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load dummy provider scenario: %w", err)
+			}
+			dummyConfig.Scenario = scenario
+		}
+
+		providers = append(providers, NewDummyProvider(dummyConfig))
+	}
+
+	return providers, nil
+}
+
+// DetectProvider returns the provider of the current environment, the
+// configured one when set, otherwise the first supported one when
+// auto-detection is enabled. It returns nil when none is supported.
+func DetectProvider(ctx context.Context, providers []Provider, config ProviderConfig, logger *slog.Logger) Provider {
+
+	// when specified provider configured use it
+	if config.Name != "" {
+		for _, p := range providers {
+			if strings.EqualFold(string(p.Name()), config.Name) {
+				if p.IsSupported(ctx) {
+					logger.Info("configured provider detected and supported", "provider", p.Name())
+					return p
+				} else {
+					logger.Warn("configured provider not supported in current environment", "provider", p.Name())
+					return nil
+				}
+			}
+		}
+		logger.Error("configured provider not found", "provider", config.Name)
+		return nil
+	}
+
+	// If auto-detection is disabled and no provider is specified, return nil
+	if !config.AutoDetect {
+		logger.Error("auto-detection disabled and no provider specified")
+		return nil
+	}
+
+	// Auto-detect provider
+	logger.Info("auto-detecting cloud provider")
+	for _, p := range providers {
+		if p.IsSupported(ctx) {
+			logger.Info("provider auto-detected", "provider", p.Name())
+			return p
+		}
+	}
+
+	logger.Debug("no supported provider detected during auto-detection")
+	return nil
+}
+
+// provider returns the event source for the cluster mode: the detected
+// provider for standalone and agents, and the Lease holding controller for
+// the controller
+func (e *Evacuator) detectProvider(ctx context.Context, config *Config) (Provider, error) {
+	clusterConfig := config.Cluster
+
+	if clusterConfig.Mode == ClusterModeController {
+		controller, err := NewClusterController(&ClusterControllerProviderConfig{
+			Logger:         e.logger,
+			InCluster:      clusterConfig.InCluster,
+			Kubeconfig:     clusterConfig.Kubeconfig,
+			Token:          clusterConfig.Token,
+			ListenAddress:  clusterConfig.Controller.ListenAddress,
+			LeaseName:      clusterConfig.Controller.LeaseName,
+			LeaseNamespace: clusterConfig.Controller.LeaseNamespace,
+			Identity:       clusterConfig.Controller.Identity,
+			LeaseDuration:  clusterConfig.Controller.LeaseDuration,
+			RenewDeadline:  clusterConfig.Controller.RenewDeadline,
+			RetryPeriod:    clusterConfig.Controller.RetryPeriod,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cluster controller: %w", err)
+		}
+		if !controller.IsSupported(ctx) {
+			return nil, fmt.Errorf("cluster controller cannot reach the kubernetes api")
+		}
+
+		e.logger.Info("running as cluster controller", "max_concurrent_nodes", clusterConfig.Controller.MaxConcurrentNodes)
+		return controller, nil
+	}
+
+	providers, err := NewProviders(config, e.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Detect the current cloud provider environment
+	provider := DetectProvider(ctx, providers, config.Provider, e.logger)
+	if provider == nil {
+		return nil, fmt.Errorf("no supported provider detected")
+	}
+
+	return provider, nil
+}
+
+// Run detects the provider and handles its termination events until ctx is
+// done, then waits up to GracefulShutdownTimeout for the handling in progress
+func (e *Evacuator) Run(ctx context.Context) error {
+	if len(e.Handlers()) == 0 && len(e.subscribed()) == 0 {
+		return fmt.Errorf("no handlers registered and no subscribers")
+	}
+
+	config := e.currentConfig()
+
+	provider, err := e.detectProvider(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Start the background work of handlers, e.g. keeping connections warm,
+	// handlers created by a reload are started by it
+	e.mu.Lock()
+	e.provider = provider
+	e.runCtx = ctx
+	e.startBackground(e.handlers.handlers)
+	e.mu.Unlock()
+
+	// Create channel for termination events from provider
+	terminationEvent := make(chan TerminationEvent)
+	var wg sync.WaitGroup
+
+	// Start provider monitoring in background goroutine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		provider.StartMonitoring(ctx, terminationEvent)
+	}()
+
+	// Only the controller handles several nodes and limits how many at once
+	maxConcurrent := 0
+	if config.Cluster.Mode == ClusterModeController {
+		maxConcurrent = config.Cluster.Controller.MaxConcurrentNodes
+	}
+
+	// Start event broadcaster to distribute events to all handlers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.broadcastTerminationEvents(ctx, terminationEvent, maxConcurrent)
+	}()
+
+	<-ctx.Done()
+
+	// Wait for all goroutines to finish with timeout protection
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Either all goroutines finish or timeout after configured duration
+	select {
+	case <-done:
+		e.logger.Info("all goroutines stopped successfully")
+	case <-time.After(GracefulShutdownTimeout):
+		e.logger.Warn("timeout waiting for goroutines to stop")
+	}
+
+	return nil
+}
+
+// broadcastTerminationEvents distributes termination events to all handlers.
+// Each event is processed through all handlers in its own goroutine, so a
+// cancelled notice can stop the handling still in progress for its instance.
+// Repeated signals of a notice are dropped by the deduplicator, an escalated
+// notice replaces the handling in progress for its instance.
+// At most maxConcurrent events are processed at once when it is positive.
+func (e *Evacuator) broadcastTerminationEvents(ctx context.Context, terminationEvent <-chan TerminationEvent, maxConcurrent int) {
+
+	var eventWg sync.WaitGroup
+
+	// handling in progress by InstanceKey, a newer event for the same instance replaces the entry
+	e.inFlightMu.Lock()
+	e.inFlight = make(map[string]*inFlightEvent)
+	inFlight := e.inFlight
+	e.inFlightMu.Unlock()
+	mu := &e.inFlightMu
+
+	// instances that outlived their notice, recovered like a cancellation
+	type recoveredEvent struct {
+		event TerminationEvent
+		entry *inFlightEvent
+	}
+	recovered := make(chan recoveredEvent)
+
+	var slots chan struct{}
+	if maxConcurrent > 0 {
+		slots = make(chan struct{}, maxConcurrent)
+	}
+
+	for {
+		var event TerminationEvent
+
+		// results of a manual recovery, see HandleCancellation
+		var recovery chan<- []HandlerResult
+
+		select {
+		case event = <-terminationEvent:
+		case r := <-recovered:
+			// a newer notice of the instance replaced the handled one meanwhile
+			mu.Lock()
+			replaced := inFlight[InstanceKey(r.event)] != r.entry
+			mu.Unlock()
+			if replaced {
+				continue
+			}
+			event = r.event
+		case request := <-e.recoveries:
+			event, recovery = request.event, request.results
+		case <-ctx.Done():
+			e.logger.Debug("termination event broadcaster stopping")
+			eventWg.Wait()
+			return
+		}
+
+		// if node.name configured, use it as hostname, the controller
+		// receives events for every node so it keeps the event hostname
+		if config := e.currentConfig(); config.NodeName != "" && config.Cluster.Mode != ClusterModeController {
+			event.Hostname = config.NodeName
+		}
+
+		event, admitted := e.dedup.Admit(event)
+		if !admitted {
+			continue
+		}
+
+		// the hostname stands in for an unknown instance ID, like in the event key
+		instance := InstanceKey(event)
+
+		for _, fn := range e.subscribed() {
+			eventWg.Add(1)
+			go func() {
+				defer eventWg.Done()
+				fn(event)
+			}()
+		}
+
+		if event.Cancelled {
+			mu.Lock()
+			current, ok := inFlight[instance]
+			handled := ok && current.handled
+			delete(inFlight, instance)
+			mu.Unlock()
+
+			switch {
+			case handled:
+				e.logger.Info("termination notice cancelled after handling", "instance_id", event.InstanceID)
+				current.cancel()
+			case ok:
+				e.logger.Warn("termination notice cancelled, stopping handlers", "instance_id", event.InstanceID)
+				current.cancel()
+			default:
+				e.logger.Info("termination notice cancelled, no handling in progress", "instance_id", event.InstanceID)
+			}
+
+			eventWg.Add(1)
+			go func() {
+				defer eventWg.Done()
+				if ok {
+					e.waitForHandlers(current.done)
+				}
+				results := e.processCancellation(ctx, event)
+				if recovery != nil {
+					recovery <- results
+				}
+			}()
+			continue
+		}
+
+		e.logger.Info("termination event received, processing through all handlers", "key", event.Key)
+
+		eventCtx, cancel := context.WithCancel(ctx)
+		current := &inFlightEvent{event: event, cancel: cancel, done: make(chan struct{})}
+
+		mu.Lock()
+		previous, ok := inFlight[instance]
+		inFlight[instance] = current
+		mu.Unlock()
+
+		if ok && previous.event.Key != event.Key {
+			e.logger.Info("stopping the handling of the previous notice of the instance", "previous_key", previous.event.Key, "instance_id", event.InstanceID)
+			previous.cancel()
+		}
+
+		eventWg.Add(1)
+		go func() {
+			defer eventWg.Done()
+			defer cancel()
+
+			if slots != nil {
+				if len(slots) == cap(slots) {
+					e.logger.Info("too many events in progress, waiting for a slot", "node", event.Hostname, "max_concurrent", maxConcurrent)
+				}
+				select {
+				case slots <- struct{}{}:
+				case <-eventCtx.Done():
+					close(current.done)
+					return
+				}
+			}
+
+			e.handleTerminationEvent(eventCtx, event)
+			close(current.done)
+
+			if slots != nil {
+				<-slots
+			}
+
+			mu.Lock()
+			current.handled = true
+			mu.Unlock()
+
+			if e.waitForRecovery(eventCtx, event) {
+				// back through the loop, so subscribers see the cancellation
+				// and the entry is removed like for a provider cancellation
+				event.Cancelled = true
+				select {
+				case recovered <- recoveredEvent{event: event, entry: current}:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			mu.Lock()
+			if inFlight[instance] == current {
+				delete(inFlight, instance)
+			}
+			mu.Unlock()
+		}()
+	}
+}
+
+// inFlightEvent is the handling in progress of the notice of an instance
+type inFlightEvent struct {
+	event  TerminationEvent
+	cancel context.CancelFunc
+
+	// closed once the handlers returned, a cancellation waits for it so
+	// a late cordon or taint does not land after the recovery
+	done chan struct{}
+
+	// handled events stay in flight until the recovery grace period is over
+	handled bool
+}
+
+// KnownNotice returns the hostname and instance ID of the notice handled, or
+// still remembered by the deduplicator, for the node hostname, so a recovery
+// does not need to know them. An empty hostname matches the notices of every
+// node, like for a daemon that only handles its own. The event is empty when
+// no notice is known, and an error is returned when notices of several
+// instances are.
+func (e *Evacuator) KnownNotice(hostname string) (TerminationEvent, error) {
+	notices := e.dedup.notices(hostname)
+
+	e.inFlightMu.Lock()
+	for _, current := range e.inFlight {
+		if (hostname == "" || current.event.Hostname == hostname) && knownInstanceID(current.event.InstanceID) {
+			notices = append(notices, TerminationEvent{Hostname: current.event.Hostname, InstanceID: current.event.InstanceID})
+		}
+	}
+	e.inFlightMu.Unlock()
+
+	var known TerminationEvent
+	var instances []string
+	for _, notice := range notices {
+		if !slices.Contains(instances, notice.InstanceID) {
+			instances = append(instances, notice.InstanceID)
+			known = notice
+		}
+	}
+
+	if len(instances) > 1 {
+		return TerminationEvent{}, fmt.Errorf("notices of several instances are known: %s", strings.Join(instances, ", "))
+	}
+	return known, nil
+}
+
+// waitForHandlers waits for the stopped handling of a notice to return, at
+// most the processing timeout the handlers are bound to anyway
+func (e *Evacuator) waitForHandlers(done <-chan struct{}) {
+	timer := time.NewTimer(e.currentConfig().Handler.ProcessingTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		e.logger.Warn("handlers still running after the cancellation, recovering anyway")
+	}
+}
+
+// waitForRecovery waits out the recovery grace period after the handling and
+// the deadline of the event, and reports whether the instance outlived it.
+// It returns false right away when recovery is disabled, and when ctx is done
+// because of a newer event, a cancellation or the shutdown.
+func (e *Evacuator) waitForRecovery(ctx context.Context, event TerminationEvent) bool {
+	gracePeriod := e.currentConfig().Handler.RecoveryGracePeriod
+	if gracePeriod <= 0 {
+		return false
+	}
+
+	// never before the instance was due to terminate
+	wait := gracePeriod
+	if untilDeadline := time.Until(event.Deadline); !event.Deadline.IsZero() && untilDeadline > 0 {
+		wait += untilDeadline
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		e.logger.Warn("instance outlived its termination notice, recovering", "node", event.Hostname, "instance_id", event.InstanceID, "grace_period", gracePeriod.String())
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// HandleCancellation recovers the instance of a cancelled notice, e.g. after
+// `evacuator recover`, and returns the results of the handlers. The instance
+// is forgotten by the deduplicator and the state store, so a later notice is
+// handled again. While Run is active the notice goes through its event
+// handling like a cancellation from the provider, which stops the handling
+// still in progress for the instance.
+func (e *Evacuator) HandleCancellation(ctx context.Context, event TerminationEvent) []HandlerResult {
+	event.Cancelled = true
+
+	e.mu.Lock()
+	runCtx := e.runCtx
+	e.mu.Unlock()
+
+	if runCtx != nil && runCtx.Err() == nil {
+		request := recoveryRequest{event: event, results: make(chan []HandlerResult, 1)}
+		select {
+		case e.recoveries <- request:
+			select {
+			case results := <-request.results:
+				return results
+			case <-ctx.Done():
+				return nil
+			}
+		case <-runCtx.Done():
+			// stopped meanwhile, recover right here
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	e.dedup.Forget(event)
+	return e.processCancellation(ctx, event)
+}
+
+// recoveryRequest passes a manual recovery to the running event handling,
+// which sends the results of the handlers back
+type recoveryRequest struct {
+	event   TerminationEvent
+	results chan []HandlerResult
+}
+
+// processCancellation passes a cancelled notice to the handlers implementing
+// CancellationHandler and returns their results. The instance is forgotten by
+// the state store, so a later notice is handled again.
+func (e *Evacuator) processCancellation(ctx context.Context, event TerminationEvent) []HandlerResult {
+
+	handlers, release := e.acquireHandlers()
+	defer release()
+
+	processingTimeout := e.currentConfig().Handler.ProcessingTimeout
+
+	var wg sync.WaitGroup
+	results := make(chan HandlerResult, len(handlers))
+
+	for _, handler := range handlers {
+		h, ok := handler.(CancellationHandler)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			handlerCtx, cancel := context.WithTimeout(ctx, processingTimeout)
+			defer cancel()
+
+			err := h.HandleCancellation(handlerCtx, event)
+			results <- HandlerResult{
+				HandlerName: name,
+				Error:       err,
+				ProcessedAt: time.Now(),
+			}
+		}(handler.Name())
+	}
+
+	wg.Wait()
+	close(results)
+
+	var processed []HandlerResult
+	for result := range results {
+		if result.Error != nil {
+			e.logger.Error("handler failed to process cancelled notice", "handler", result.HandlerName, "error", result.Error.Error())
+		} else {
+			e.logger.Info("handler successfully processed cancelled notice", "handler", result.HandlerName)
+		}
+		processed = append(processed, result)
+	}
+
+	if e.store != nil {
+		stateCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), StateStoreTimeout)
+		defer cancel()
+
+		if err := e.store.Delete(stateCtx, event); err != nil {
+			e.logger.Warn("failed to delete event state", "instance_id", event.InstanceID, "error", err.Error())
+		}
+	}
+
+	return processed
+}
+
+// handleTerminationEvent runs the handlers that did not complete the notice
+// yet and saves their results as they come in. Without a state store every
+// handler runs.
+func (e *Evacuator) handleTerminationEvent(ctx context.Context, event TerminationEvent) {
+	handlers, release := e.acquireHandlers()
+	defer release()
+
+	if e.store == nil {
+		e.processTerminationEvent(ctx, event, handlers, nil)
+		return
+	}
+
+	state := e.loadEventState(ctx, event)
+
+	var pending []Handler
+	for _, handler := range handlers {
+		if state.Completed(handler.Name()) {
+			e.logger.Info("handler already processed termination event, skipping", "handler", handler.Name(), "instance_id", event.InstanceID)
+			continue
+		}
+		pending = append(pending, handler)
+	}
+
+	if len(pending) == 0 {
+		e.logger.Info("termination event already processed, skipping", "instance_id", event.InstanceID, "received_at", state.ReceivedAt)
+		return
+	}
+
+	e.processTerminationEvent(ctx, event, pending, func(result HandlerResult) {
+		state.Record(result.HandlerName, result.Error, result.ProcessedAt)
+
+		// saved even when the handling was stopped, the results so far are kept
+		stateCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), StateStoreTimeout)
+		defer cancel()
+
+		if err := e.store.Save(stateCtx, event, state); err != nil {
+			e.logger.Warn("failed to save event state", "instance_id", event.InstanceID, "handler", result.HandlerName, "error", err.Error())
+		}
+	})
+}
+
+// loadEventState returns the saved state of the notice, or a new one when the
+// notice is new or the store cannot be read
+func (e *Evacuator) loadEventState(ctx context.Context, event TerminationEvent) *EventState {
+	stateCtx, cancel := context.WithTimeout(ctx, StateStoreTimeout)
+	defer cancel()
+
+	state, err := e.store.Load(stateCtx, event)
+	if err != nil {
+		e.logger.Warn("failed to load event state, running all handlers", "instance_id", event.InstanceID, "error", err.Error())
+		return NewEventState(event)
+	}
+
+	if state == nil || !state.SameNotice(event) {
+		return NewEventState(event)
+	}
+
+	e.logger.Info("termination event seen before, resuming", "instance_id", event.InstanceID, "received_at", state.ReceivedAt)
+	return state
+}
+
+// HandleTermination passes the event through all handlers and returns their
+// results, like a simulated event it bypasses the deduplicator and the state
+// store
+func (e *Evacuator) HandleTermination(ctx context.Context, event TerminationEvent) []HandlerResult {
+	handlers, release := e.acquireHandlers()
+	defer release()
+
+	return e.processTerminationEvent(ctx, event, handlers, nil)
+}
+
+// processTerminationEvent runs the handlers in parallel for a single event and
+// returns their results. Each handler gets the processing timeout, shortened to
+// the event deadline when the event has one. onResult, when set, is called with
+// each result as soon as its handler is done.
+func (e *Evacuator) processTerminationEvent(ctx context.Context, event TerminationEvent, handlers []Handler, onResult func(HandlerResult)) []HandlerResult {
+
+	processingTimeout := e.currentConfig().Handler.ProcessingTimeout
+
+	// Process event through all handlers and collect results
+	var handlerWg sync.WaitGroup
+	results := make(chan HandlerResult, len(handlers))
+
+	for _, handler := range handlers {
+		handlerWg.Add(1)
+		go func(h Handler) {
+			defer handlerWg.Done()
+
+			handlerCtx, cancel := context.WithTimeout(ctx, processingTimeout)
+			defer cancel()
+
+			if !event.Deadline.IsZero() {
+				var cancelDeadline context.CancelFunc
+				handlerCtx, cancelDeadline = context.WithDeadline(handlerCtx, event.Deadline)
+				defer cancelDeadline()
+			}
+
+			e.logger.Debug("processing termination event with handler", "handler", h.Name())
+
+			err := h.HandleTermination(handlerCtx, event)
+			results <- HandlerResult{
+				HandlerName: h.Name(),
+				Error:       err,
+				ProcessedAt: time.Now(),
+			}
+		}(handler)
+	}
+
+	// Wait for all handlers to complete and collect results
+	go func() {
+		handlerWg.Wait()
+		close(results)
+	}()
+
+	// Process results
+	var processed []HandlerResult
+	successCount := 0
+	for result := range results {
+		var drainErr *DrainError
+		if errors.As(result.Error, &drainErr) {
+			e.logger.Error("handler failed to process termination event",
+				"handler", result.HandlerName,
+				"error", result.Error.Error(),
+				"node", drainErr.Node,
+				"failed_pods", len(drainErr.Failed),
+				"total_pods", drainErr.Total,
+				"processed_at", result.ProcessedAt)
+		} else if result.Error != nil {
+			e.logger.Error("handler failed to process termination event",
+				"handler", result.HandlerName,
+				"error", result.Error.Error(),
+				"processed_at", result.ProcessedAt)
+		} else {
+			e.logger.Info("handler successfully processed termination event",
+				"handler", result.HandlerName,
+				"processed_at", result.ProcessedAt)
+			successCount++
+		}
+		if onResult != nil {
+			onResult(result)
+		}
+		processed = append(processed, result)
+	}
+
+	e.logger.Info("termination event processing completed",
+		"total_handlers", len(handlers),
+		"successful_handlers", successCount,
+		"failed_handlers", len(handlers)-successCount)
+
+	return processed
+}
@@ -0,0 +1,29 @@
+// Command evacuator-handler-example is a minimal handler plugin. Build it into
+// the plugin directory and enable it under handler.plugins[]:
+//
+//	go build -o /etc/evacuator/plugins/evacuator-handler-example ./example/handler-plugin
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rahadiangg/evacuator"
+)
+
+type ExampleHandler struct{}
+
+func (h *ExampleHandler) Name() string {
+	return "example"
+}
+
+func (h *ExampleHandler) HandleTermination(ctx context.Context, event evacuator.TerminationEvent) error {
+	// stdout is reserved for the plugin handshake, stderr is forwarded to evacuator logs
+	fmt.Fprintf(os.Stderr, "example plugin received %s for %s (%s)\n", event.Reason, event.Hostname, event.InstanceID)
+	return nil
+}
+
+func main() {
+	evacuator.ServePlugin(&ExampleHandler{})
+}
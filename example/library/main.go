@@ -0,0 +1,77 @@
+// Command library embeds evacuator in a service that flushes its in-memory
+// queue when the instance is about to be terminated, without a sidecar:
+//
+//	PROVIDER_NAME=dummy go run ./example/library
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/rahadiangg/evacuator"
+)
+
+// queue stands in for the buffered work of the service
+type queue struct {
+	mu    sync.Mutex
+	items []string
+}
+
+func (q *queue) flush(logger *slog.Logger) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	logger.Info("queue flushed", "items", len(q.items))
+	q.items = nil
+}
+
+// deregisterHandler is a handler created in code, it gets the processing
+// timeout and its error is logged like the ones of the configured handlers
+type deregisterHandler struct {
+	logger *slog.Logger
+}
+
+func (h *deregisterHandler) Name() string {
+	return "deregister"
+}
+
+func (h *deregisterHandler) HandleTermination(ctx context.Context, event evacuator.TerminationEvent) error {
+	h.logger.Info("leaving the service registry", "instance_id", event.InstanceID, "key", event.Key)
+	return nil
+}
+
+func main() {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	q := &queue{items: []string{"a", "b", "c"}}
+
+	// configuration from the environment and defaults, use
+	// evacuator.WithConfigFile or evacuator.WithConfig to pass one
+	e, err := evacuator.New(
+		evacuator.WithLogger(logger),
+		evacuator.WithHandler(&deregisterHandler{logger: logger}),
+	)
+	if err != nil {
+		logger.Error("failed to create evacuator", "error", err)
+		os.Exit(1)
+	}
+
+	e.Subscribe(func(event evacuator.TerminationEvent) {
+		if event.Cancelled {
+			logger.Info("termination notice cancelled, keeping the queue", "instance_id", event.InstanceID)
+			return
+		}
+		q.flush(logger)
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := e.Run(ctx); err != nil {
+		logger.Error("evacuator stopped", "error", err)
+		os.Exit(1)
+	}
+}
@@ -2,15 +2,35 @@ package evacuator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-plugin"
 )
 
 type TerminationEvent struct {
-	Hostname   string
-	PrivateIP  string
-	InstanceID string
-	Reason     TerminationReason
+	Hostname   string            `json:"hostname"`
+	PrivateIP  string            `json:"private_ip"`
+	InstanceID string            `json:"instance_id"`
+	Reason     TerminationReason `json:"reason"`
+
+	// Deadline is when the instance is terminated, zero when the provider does not tell
+	Deadline time.Time `json:"deadline,omitzero"`
+
+	// Cancelled reports that the provider withdrew the notice for the instance,
+	// handling still in progress for it is stopped
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	// Key identifies the notice across repeated signals and restarts, see
+	// EventKey. Handlers with side effects outside the cluster can pass it on
+	// as idempotency key.
+	Key string `json:"key,omitempty"`
 }
 
 type TerminationReason string
@@ -18,14 +38,40 @@ type TerminationReason string
 const (
 	TerminationReasonSpot        TerminationReason = "spot termination"
 	TerminationReasonMaintenance TerminationReason = "maintenance termination"
+
+	// TerminationReasonRebalance is an advance warning that the instance is at
+	// an elevated risk of being interrupted
+	TerminationReasonRebalance TerminationReason = "rebalance recommendation"
 )
 
+// ParseTerminationReason converts the short reason names used in flags and
+// scenario files, spot when empty
+func ParseTerminationReason(reason string) (TerminationReason, error) {
+	switch reason {
+	case "spot", "":
+		return TerminationReasonSpot, nil
+	case "maintenance":
+		return TerminationReasonMaintenance, nil
+	case "rebalance":
+		return TerminationReasonRebalance, nil
+	default:
+		return "", fmt.Errorf("unknown reason %q, must be spot, maintenance or rebalance", reason)
+	}
+}
+
+// HandlerName identifies a handler type, it is the name the handler factory is
+// registered under and the default name of its handler instances
 type HandlerName string
 
 const (
-	HandlerNameLog        HandlerName = "log"
-	HandlerNameKubernetes HandlerName = "kubernetes"
-	HandlerNameTelegram   HandlerName = "telegram"
+	HandlerNameDummy        HandlerName = "dummy"
+	HandlerNameKubernetes   HandlerName = "kubernetes"
+	HandlerNameNomad        HandlerName = "nomad"
+	HandlerNameConsul       HandlerName = "consul"
+	HandlerNameTelegram     HandlerName = "telegram"
+	HandlerNameHost         HandlerName = "host"
+	HandlerNameLoadBalancer HandlerName = "loadbalancer"
+	HandlerNamePlugin       HandlerName = "plugin"
 )
 
 type Handler interface {
@@ -36,108 +82,491 @@ type Handler interface {
 	Name() string
 }
 
+// CancellationHandler is implemented by handlers that act on a withdrawn
+// notice, handlers without it only have their in-progress handling stopped
+type CancellationHandler interface {
+	HandleCancellation(ctx context.Context, event TerminationEvent) error
+}
+
+// BackgroundHandler is implemented by handlers with work to do before any
+// event, Run is started once with the handlers and returns when ctx is done
+type BackgroundHandler interface {
+	Run(ctx context.Context)
+}
+
+// HandlerFactory creates a handler instance from its configuration
+type HandlerFactory func(config HandlerFactoryConfig) (Handler, error)
+
+// HandlerFactoryConfig is passed to a HandlerFactory for every handler instance
+type HandlerFactoryConfig struct {
+	Logger *slog.Logger
+
+	// Name of the instance, unique across all handlers
+	Name string
+
+	// Handler configuration with the instance settings applied on top of the
+	// section of the instance type, e.g. Telegram for a telegram instance
+	Handler HandlerConfig
+
+	// Settings of the instance as written in config, for handler types
+	// without a section in HandlerConfig
+	Settings map[string]interface{}
+
+	// NodeName is the configured node of this evacuator, empty for a cluster
+	// controller, which handles other nodes
+	NodeName string
+}
+
+// Decode decodes the raw instance settings into out, using the same decoding
+// rules as the configuration file
+func (c HandlerFactoryConfig) Decode(out interface{}) error {
+	return decodeSettings(c.Settings, out)
+}
+
 // HandlerRegistry manages the registration and creation of handlers
 type HandlerRegistry struct {
-	logger *slog.Logger
+	logger    *slog.Logger
+	config    *Config
+	factories map[HandlerName]HandlerFactory
 }
 
-// NewHandlerRegistry creates a new handler registry
-func NewHandlerRegistry(logger *slog.Logger) *HandlerRegistry {
-	return &HandlerRegistry{
-		logger: logger,
+// NewHandlerRegistry creates a new handler registry with the built-in handler
+// factories, creating the handlers configured in config
+func NewHandlerRegistry(logger *slog.Logger, config *Config) *HandlerRegistry {
+	r := &HandlerRegistry{
+		logger:    logger,
+		config:    config,
+		factories: make(map[HandlerName]HandlerFactory),
 	}
+
+	r.RegisterFactory(HandlerNameDummy, createDummyHandler)
+	r.RegisterFactory(HandlerNameKubernetes, createKubernetesHandler)
+	r.RegisterFactory(HandlerNameNomad, createNomadHandler)
+	r.RegisterFactory(HandlerNameConsul, createConsulHandler)
+	r.RegisterFactory(HandlerNameTelegram, createTelegramHandler)
+	r.RegisterFactory(HandlerNameHost, createHostHandler)
+	r.RegisterFactory(HandlerNameLoadBalancer, createLoadBalancerHandler)
+	r.RegisterFactory(HandlerNamePlugin, createPluginHandler)
+
+	return r
 }
 
-// RegisterHandlers registers and creates all available handlers
-func (r *HandlerRegistry) RegisterHandlers() ([]Handler, error) {
-	var handlers []Handler
-	var errors []error
+// RegisterFactory registers the factory for a handler type, replacing any
+// factory previously registered under the same name
+func (r *HandlerRegistry) RegisterFactory(name HandlerName, factory HandlerFactory) {
+	r.factories[name] = factory
+}
 
-	// Get global configuration
-	handlerConfig := GetHandlerConfig()
-	provderConfig := GetProviderConfig()
+// RegisterHandlers creates a handler for every configured handler instance.
+// Instances that fail to initialize are skipped, or fail the registration
+// when handler.strict is enabled.
+func (r *HandlerRegistry) RegisterHandlers() ([]Handler, error) {
+	registered, err := r.registerHandlers(nil)
+	if err != nil {
+		return nil, err
+	}
 
-	// dummy handler registerd when prover is dummy
-	if provderConfig.Name == "dummy" {
-		logHandler := NewDummyHandler(&DummyHandlerConfig{
-			Logger: r.logger,
-		})
-		handlers = append(handlers, logHandler)
-		r.logger.Info("dummy handler registered successfully")
+	handlers := make([]Handler, 0, len(registered))
+	for _, h := range registered {
+		handlers = append(handlers, h.handler)
 	}
+	return handlers, nil
+}
 
-	// Register Kubernetes handler if enabled
-	if handlerConfig.Kubernetes.Enabled {
-		kubernetesHandler, err := r.createKubernetesHandler()
-		if err != nil {
-			r.logger.Error("failed to create kubernetes handler", "error", err)
-			errors = append(errors, fmt.Errorf("kubernetes handler: %w", err))
-		}
+// registeredHandler is a handler with the spec it was created from
+type registeredHandler struct {
+	name    string
+	handler Handler
+	spec    handlerSpec
+}
+
+// handlerSpec is what a handler instance is created from, the section of its
+// type with the instance settings applied. A handler whose spec did not
+// change is kept on a configuration reload.
+type handlerSpec struct {
+	Type     string
+	Section  any
+	Settings map[string]interface{}
+	DryRun   bool
+	NodeName string
+}
 
-		handlers = append(handlers, kubernetesHandler)
-		r.logger.Info("kubernetes handler registered successfully")
+// registerHandlers creates the handler instances, keeping the handlers of
+// previous whose name and spec did not change
+func (r *HandlerRegistry) registerHandlers(previous map[string]registeredHandler) ([]registeredHandler, error) {
+	var registered []registeredHandler
+	var created []Handler
+	var registrationErrors []error
+
+	handlerConfig := r.config.Handler
+
+	instances, err := r.handlerInstances()
+	if err != nil {
+		r.logger.Error("failed to discover plugins", "error", err)
+		registrationErrors = append(registrationErrors, fmt.Errorf("plugin discovery: %w", err))
 	}
 
-	// Register Telegram handler if enabled
-	if handlerConfig.Telegram.Enabled {
-		telegramHandler, err := r.createTelegramHandler()
+	for _, instance := range instances {
+		factoryConfig, err := r.factoryConfig(instance)
 		if err != nil {
-			r.logger.Error("failed to create telegram handler", "error", err)
-			errors = append(errors, fmt.Errorf("telegram handler: %w", err))
+			r.logger.Error("failed to create handler", "handler", instance.Name, "type", instance.Type, "error", err)
+			registrationErrors = append(registrationErrors, fmt.Errorf("%s handler: %w", instance.Name, err))
+			continue
 		}
+		spec := factoryConfig.spec(instance.Type)
 
-		handlers = append(handlers, telegramHandler)
-		r.logger.Info("telegram handler registered successfully")
-	}
+		if p, ok := previous[instance.Name]; ok && reflect.DeepEqual(p.spec, spec) {
+			registered = append(registered, p)
+			r.logger.Debug("handler unchanged, kept", "handler", instance.Name, "type", instance.Type)
+			continue
+		}
 
-	// Register Nomad handler if enabled
-	if handlerConfig.Nomad.Enabled {
-		nomadHandler, err := r.createNomadHandler()
+		handler, err := r.factories[HandlerName(instance.Type)](factoryConfig)
 		if err != nil {
-			r.logger.Error("failed to create nomad handler", "error", err)
-			errors = append(errors, fmt.Errorf("nomad handler: %w", err))
+			r.logger.Error("failed to create handler", "handler", instance.Name, "type", instance.Type, "error", err)
+			registrationErrors = append(registrationErrors, fmt.Errorf("%s handler: %w", instance.Name, err))
+			continue
 		}
 
-		handlers = append(handlers, nomadHandler)
-		r.logger.Info("nomad handler registered successfully")
+		registered = append(registered, registeredHandler{name: instance.Name, handler: handler, spec: spec})
+		created = append(created, handler)
+
+		if _, ok := previous[instance.Name]; ok {
+			r.logger.Info("handler rebuilt with its changed configuration", "handler", instance.Name, "type", instance.Type)
+		} else {
+			r.logger.Info("handler registered successfully", "handler", instance.Name, "type", instance.Type)
+		}
 	}
 
-	// Return error if no handlers were registered
-	if len(handlers) == 0 {
-		return nil, fmt.Errorf("no handlers registered")
+	if handlerConfig.Strict && len(registrationErrors) > 0 {
+		closeHandlers(created)
+		return nil, fmt.Errorf("failed to create %d handlers: %w", len(registrationErrors), errors.Join(registrationErrors...))
 	}
 
 	// Log summary
 	r.logger.Info("handler registration completed",
-		"total_handlers", len(handlers),
-		"failed_handlers", len(errors))
+		"total_handlers", len(registered),
+		"failed_handlers", len(registrationErrors))
 
-	return handlers, nil
+	return registered, nil
+}
+
+// factoryConfig returns the factory configuration of the instance
+func (r *HandlerRegistry) factoryConfig(instance HandlerInstanceConfig) (HandlerFactoryConfig, error) {
+	if _, ok := r.factories[HandlerName(instance.Type)]; !ok {
+		return HandlerFactoryConfig{}, fmt.Errorf("unknown handler type: %s", instance.Type)
+	}
+
+	handlerConfig, err := r.config.Handler.ForInstance(instance)
+	if err != nil {
+		return HandlerFactoryConfig{}, err
+	}
+
+	// a controller handles other nodes, its own node name would never match
+	nodeName := ""
+	if r.config.Cluster.Mode != ClusterModeController {
+		nodeName = r.config.NodeName
+	}
+
+	return HandlerFactoryConfig{
+		Logger:   r.logger,
+		Name:     instance.Name,
+		Handler:  handlerConfig,
+		Settings: instance.Settings,
+		NodeName: nodeName,
+	}, nil
+}
+
+// spec returns the spec of a handler of the type created from c. Types
+// without a section, e.g. registered with RegisterFactory, may read any
+// section and depend on all of them.
+func (c HandlerFactoryConfig) spec(handlerType string) handlerSpec {
+	spec := handlerSpec{
+		Type:     handlerType,
+		Settings: c.Settings,
+		DryRun:   c.Handler.DryRun,
+		NodeName: c.NodeName,
+	}
+
+	switch HandlerName(handlerType) {
+	case HandlerNameKubernetes:
+		spec.Section = c.Handler.Kubernetes
+	case HandlerNameNomad:
+		spec.Section = c.Handler.Nomad
+	case HandlerNameConsul:
+		spec.Section = c.Handler.Consul
+	case HandlerNameTelegram:
+		spec.Section = c.Handler.Telegram
+	case HandlerNameHost:
+		spec.Section = c.Handler.Host
+	case HandlerNameLoadBalancer:
+		spec.Section = c.Handler.LoadBalancer
+	case HandlerNameDummy, HandlerNamePlugin:
+		// everything is in the settings
+	default:
+		spec.Section = c.Handler
+	}
+
+	return spec
+}
+
+// closeHandlers stops the handlers holding processes, e.g. plugins
+func closeHandlers(handlers []Handler) {
+	for _, handler := range handlers {
+		if closer, ok := handler.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+// handlerInstances returns every handler instance to create: the enabled
+// handler sections, the entries of handler.instances and the handler plugins
+func (r *HandlerRegistry) handlerInstances() ([]HandlerInstanceConfig, error) {
+	handlerConfig := r.config.Handler
+	providerConfig := r.config.Provider
+
+	var instances []HandlerInstanceConfig
+
+	// dummy handler registered when provider is dummy
+	if providerConfig.Name == string(ProviderDummy) {
+		instances = append(instances, HandlerInstanceConfig{Name: string(HandlerNameDummy), Type: string(HandlerNameDummy)})
+	}
+
+	sections := []struct {
+		name    HandlerName
+		enabled bool
+	}{
+		{HandlerNameKubernetes, handlerConfig.Kubernetes.Enabled},
+		{HandlerNameTelegram, handlerConfig.Telegram.Enabled},
+		{HandlerNameNomad, handlerConfig.Nomad.Enabled},
+		{HandlerNameConsul, handlerConfig.Consul.Enabled},
+		{HandlerNameHost, handlerConfig.Host.Enabled},
+		{HandlerNameLoadBalancer, handlerConfig.LoadBalancer.Enabled},
+	}
+
+	for _, section := range sections {
+		if section.enabled {
+			instances = append(instances, HandlerInstanceConfig{Name: string(section.name), Type: string(section.name)})
+		}
+	}
+
+	for _, instance := range handlerConfig.Instances {
+		if instance.Enabled {
+			instances = append(instances, instance)
+		}
+	}
+
+	plugins, err := r.pluginConfigs()
+	for _, p := range plugins {
+		instances = append(instances, HandlerInstanceConfig{
+			Name: p.Name,
+			Type: string(HandlerNamePlugin),
+			Settings: map[string]interface{}{
+				"path": p.Path,
+				"args": p.Args,
+				"env":  p.Env,
+			},
+		})
+	}
+
+	return instances, err
+}
+
+// pluginConfigs returns the enabled plugins from config together with the
+// plugins discovered in the plugin directory, with their binary paths resolved
+func (r *HandlerRegistry) pluginConfigs() ([]PluginConfig, error) {
+	handlerConfig := r.config.Handler
+
+	var plugins []PluginConfig
+	configured := make(map[string]bool)
+
+	for _, p := range handlerConfig.Plugins {
+		configured[p.Name] = true
+		if !p.Enabled {
+			continue
+		}
+
+		if p.Path == "" {
+			p.Path = PluginBinaryPrefix + p.Name
+		}
+		if !filepath.IsAbs(p.Path) {
+			p.Path = filepath.Join(handlerConfig.PluginDir, p.Path)
+		}
+
+		plugins = append(plugins, p)
+	}
+
+	if !handlerConfig.PluginAutoDiscover {
+		return plugins, nil
+	}
+
+	paths, err := plugin.Discover(PluginBinaryPrefix+"*", handlerConfig.PluginDir)
+	if err != nil {
+		return plugins, err
+	}
+
+	sort.Strings(paths)
+	for _, path := range paths {
+		name := strings.TrimPrefix(filepath.Base(path), PluginBinaryPrefix)
+
+		// plugins listed in config, enabled or not, are not loaded twice
+		if configured[name] {
+			continue
+		}
+
+		r.logger.Info("plugin discovered", "plugin", name, "path", path)
+		plugins = append(plugins, PluginConfig{
+			Name:    name,
+			Enabled: true,
+			Path:    path,
+		})
+	}
+
+	return plugins, nil
+}
+
+func createDummyHandler(config HandlerFactoryConfig) (Handler, error) {
+	return NewDummyHandler(&DummyHandlerConfig{
+		Logger: config.Logger,
+		Name:   config.Name,
+	}), nil
 }
 
-func (r *HandlerRegistry) createKubernetesHandler() (Handler, error) {
-	handlerConfig := GetHandlerConfig()
+func createKubernetesHandler(config HandlerFactoryConfig) (Handler, error) {
+	kubernetesConfig := config.Handler.Kubernetes
+
 	return NewKubernetesHandler(&KubernetesHandlerConfig{
-		Logger:             r.logger,
-		InCluster:          handlerConfig.Kubernetes.InCluster,
-		Kubeconfig:         handlerConfig.Kubernetes.Kubeconfig,
-		SkipDaemonSets:     handlerConfig.Kubernetes.SkipDaemonSets,
-		DeleteEmptyDirData: handlerConfig.Kubernetes.DeleteEmptyDirData,
+		Logger:             config.Logger,
+		Name:               config.Name,
+		DryRun:             config.Handler.DryRun,
+		InCluster:          kubernetesConfig.InCluster,
+		Kubeconfig:         kubernetesConfig.Kubeconfig,
+		SkipDaemonSets:     kubernetesConfig.SkipDaemonSets,
+		DeleteEmptyDirData: kubernetesConfig.DeleteEmptyDirData,
+		NodeName:           config.NodeName,
+		PodCache:           kubernetesConfig.PodCache,
+		QPS:                kubernetesConfig.QPS,
+		Burst:              kubernetesConfig.Burst,
+		VerifyPermissions:  kubernetesConfig.VerifyPermissions,
+		KeepaliveInterval:  kubernetesConfig.KeepaliveInterval,
+		Taint:              kubernetesConfig.Taint,
+
+		VolumeDetachTimeout: kubernetesConfig.VolumeDetachTimeout,
+
+		DrainFailurePolicy:             kubernetesConfig.DrainFailure.Policy,
+		DrainFailureThreshold:          kubernetesConfig.DrainFailure.Threshold,
+		DrainFailureCriticalNamespaces: kubernetesConfig.DrainFailure.CriticalNamespaces,
+		DrainFailureCriticalSelector:   kubernetesConfig.DrainFailure.CriticalSelector,
+
+		CoordinationEnabled:             kubernetesConfig.Coordination.Enabled,
+		CoordinationNamespace:           kubernetesConfig.Coordination.Namespace,
+		CoordinationConfigMap:           kubernetesConfig.Coordination.ConfigMap,
+		CoordinationMaxConcurrentDrains: kubernetesConfig.Coordination.MaxConcurrentDrains,
+		CoordinationMaxWait:             kubernetesConfig.Coordination.MaxWait,
+		CoordinationWindow:              kubernetesConfig.Coordination.Window,
+
+		PrescaleKarpenter:                kubernetesConfig.Prescale.Karpenter,
+		PrescalePlaceholderEnabled:       kubernetesConfig.Prescale.Placeholder.Enabled,
+		PrescalePlaceholderNamespace:     kubernetesConfig.Prescale.Placeholder.Namespace,
+		PrescalePlaceholderPriorityClass: kubernetesConfig.Prescale.Placeholder.PriorityClass,
+		PrescalePlaceholderImage:         kubernetesConfig.Prescale.Placeholder.Image,
 	})
 }
 
-func (r *HandlerRegistry) createTelegramHandler() (Handler, error) {
-	handlerConfig := GetHandlerConfig()
+func createTelegramHandler(config HandlerFactoryConfig) (Handler, error) {
+	telegramConfig := config.Handler.Telegram
 
 	return NewTelegramHandler(&TelegramHandlerConfig{
-		Logger:   r.logger,
-		BotToken: handlerConfig.Telegram.BotToken,
-		ChatID:   handlerConfig.Telegram.ChatID,
+		Logger:   config.Logger,
+		Name:     config.Name,
+		DryRun:   config.Handler.DryRun,
+		BotToken: telegramConfig.BotToken,
+		ChatID:   telegramConfig.ChatID,
 	})
 }
 
-func (r *HandlerRegistry) createNomadHandler() (Handler, error) {
+func createNomadHandler(config HandlerFactoryConfig) (Handler, error) {
 	return NewNomadHandler(&NomadHandlerConfig{
-		Logger: r.logger,
+		Logger: config.Logger,
+		Name:   config.Name,
+		DryRun: config.Handler.DryRun,
+		Force:  config.Handler.Nomad.Force,
+	})
+}
+
+func createConsulHandler(config HandlerFactoryConfig) (Handler, error) {
+	consulConfig := config.Handler.Consul
+
+	return NewConsulHandler(&ConsulHandlerConfig{
+		Logger:                config.Logger,
+		Name:                  config.Name,
+		DryRun:                config.Handler.DryRun,
+		Address:               consulConfig.Address,
+		Token:                 consulConfig.Token,
+		Mode:                  consulConfig.Mode,
+		Reason:                consulConfig.Reason,
+		Services:              consulConfig.Services,
+		Wait:                  consulConfig.Wait,
+		TLSCAFile:             consulConfig.TLS.CAFile,
+		TLSCertFile:           consulConfig.TLS.CertFile,
+		TLSKeyFile:            consulConfig.TLS.KeyFile,
+		TLSServerName:         consulConfig.TLS.ServerName,
+		TLSInsecureSkipVerify: consulConfig.TLS.InsecureSkipVerify,
+	})
+}
+
+func createHostHandler(config HandlerFactoryConfig) (Handler, error) {
+	hostConfig := config.Handler.Host
+
+	return NewHostHandler(&HostHandlerConfig{
+		Logger:            config.Logger,
+		Name:              config.Name,
+		DryRun:            config.Handler.DryRun,
+		DockerEnabled:     hostConfig.Docker.Enabled,
+		DockerSocket:      hostConfig.Docker.Socket,
+		DockerLabel:       hostConfig.Docker.Label,
+		DockerStopTimeout: hostConfig.Docker.StopTimeout,
+		SystemdEnabled:    hostConfig.Systemd.Enabled,
+		SystemdUnits:      hostConfig.Systemd.Units,
+	})
+}
+
+func createLoadBalancerHandler(config HandlerFactoryConfig) (Handler, error) {
+	lbConfig := config.Handler.LoadBalancer
+
+	return NewLoadBalancerHandler(&LoadBalancerHandlerConfig{
+		Logger:                  config.Logger,
+		Name:                    config.Name,
+		DryRun:                  config.Handler.DryRun,
+		DrainTimeout:            lbConfig.DrainTimeout,
+		AwsEnabled:              lbConfig.Aws.Enabled,
+		AwsRegion:               lbConfig.Aws.Region,
+		AwsEndpoint:             lbConfig.Aws.Endpoint,
+		AwsTargetGroupArns:      lbConfig.Aws.TargetGroupArns,
+		AwsClassicLoadBalancers: lbConfig.Aws.ClassicLoadBalancers,
+		AwsAutoDiscover:         lbConfig.Aws.AutoDiscover,
+		GcpEnabled:              lbConfig.Gcp.Enabled,
+		GcpEndpoint:             lbConfig.Gcp.Endpoint,
+		GcpMetadataEndpoint:     lbConfig.Gcp.MetadataEndpoint,
+		GcpProject:              lbConfig.Gcp.Project,
+		GcpZone:                 lbConfig.Gcp.Zone,
+		GcpInstanceName:         lbConfig.Gcp.InstanceName,
+		GcpInstanceGroups:       lbConfig.Gcp.InstanceGroups,
+	})
+}
+
+func createPluginHandler(config HandlerFactoryConfig) (Handler, error) {
+	var pluginConfig PluginConfig
+	if err := config.Decode(&pluginConfig); err != nil {
+		return nil, fmt.Errorf("invalid plugin settings: %w", err)
+	}
+
+	return NewPluginHandler(&PluginHandlerConfig{
+		Logger: config.Logger,
+		Name:   config.Name,
+		DryRun: config.Handler.DryRun,
+		Path:   pluginConfig.Path,
+		Args:   pluginConfig.Args,
+		Env:    pluginConfig.Env,
 	})
 }
@@ -0,0 +1,224 @@
+package evacuator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+type ConsulHandler struct {
+	consulClient *consulApi.Client
+	config       ConsulHandlerConfig
+}
+
+type ConsulHandlerConfig struct {
+	Logger   *slog.Logger
+	Name     string
+	Address  string
+	Token    string
+	Mode     string
+	Reason   string
+	Services []string
+	Wait     time.Duration
+
+	// DryRun lists the affected services without changing the agent
+	DryRun bool
+
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+}
+
+const (
+	// ConsulModeMaintenance marks the node (or the configured services) as under maintenance
+	ConsulModeMaintenance = "maintenance"
+
+	// ConsulModeDeregister removes the services from the local agent
+	ConsulModeDeregister = "deregister"
+)
+
+func NewConsulHandler(config *ConsulHandlerConfig) (*ConsulHandler, error) {
+
+	// DefaultConfig reads CONSUL_HTTP_ADDR, CONSUL_HTTP_TOKEN and the CONSUL_* tls
+	// environment variables, explicit configuration takes precedence
+	consulConfig := consulApi.DefaultConfig()
+
+	if config.Address != "" {
+		consulConfig.Address = config.Address
+	}
+	if config.Token != "" {
+		consulConfig.Token = config.Token
+	}
+	if config.TLSCAFile != "" {
+		consulConfig.TLSConfig.CAFile = config.TLSCAFile
+	}
+	if config.TLSCertFile != "" {
+		consulConfig.TLSConfig.CertFile = config.TLSCertFile
+	}
+	if config.TLSKeyFile != "" {
+		consulConfig.TLSConfig.KeyFile = config.TLSKeyFile
+	}
+	if config.TLSServerName != "" {
+		consulConfig.TLSConfig.Address = config.TLSServerName
+	}
+	if config.TLSInsecureSkipVerify {
+		consulConfig.TLSConfig.InsecureSkipVerify = true
+	}
+
+	client, err := consulApi.NewClient(consulConfig)
+	if err != nil {
+		config.Logger.Error("failed to create Consul client", "error", err.Error())
+		return nil, err
+	}
+
+	return &ConsulHandler{
+		consulClient: client,
+		config:       *config,
+	}, nil
+}
+
+func (h *ConsulHandler) Name() string {
+	if h.config.Name != "" {
+		return h.config.Name
+	}
+	return string(HandlerNameConsul)
+}
+
+func (h *ConsulHandler) HandleTermination(ctx context.Context, event TerminationEvent) error {
+
+	h.config.Logger.Info("handling consul node termination", "node", event.Hostname, "mode", h.config.Mode, "handler", h.Name())
+
+	reason := fmt.Sprintf("%s: %s (%s)", h.config.Reason, event.Reason, event.InstanceID)
+
+	if h.config.DryRun {
+		return h.reportServices(ctx, reason)
+	}
+
+	var err error
+	switch h.config.Mode {
+	case ConsulModeMaintenance:
+		err = h.enableMaintenance(ctx, reason)
+	case ConsulModeDeregister:
+		err = h.deregisterServices(ctx)
+	default:
+		err = fmt.Errorf("unknown consul mode: %s", h.config.Mode)
+	}
+
+	if err != nil {
+		h.config.Logger.Error("failed to remove node from consul", "error", err.Error(), "handler", h.Name())
+		return err
+	}
+
+	h.config.Logger.Info("consul node removed from service discovery", "node", event.Hostname, "handler", h.Name())
+
+	// give clients time to notice the change and in-flight requests time to finish
+	if h.config.Wait > 0 {
+		h.config.Logger.Info("waiting for in-flight requests to finish", "wait", h.config.Wait.String(), "handler", h.Name())
+
+		select {
+		case <-time.After(h.config.Wait):
+		case <-ctx.Done():
+			h.config.Logger.Warn("deadline reached while waiting for in-flight requests", "handler", h.Name())
+		}
+	}
+
+	h.config.Logger.Info("consul node termination handling completed successfully", "node", event.Hostname, "handler", h.Name())
+	return nil
+}
+
+// enableMaintenance enables maintenance mode on the configured services,
+// or on the whole node when no services are configured
+func (h *ConsulHandler) enableMaintenance(ctx context.Context, reason string) error {
+	if len(h.config.Services) == 0 {
+		// the client has no context variant, a hung agent must not hold the
+		// handler past its deadline
+		done := make(chan error, 1)
+		go func() {
+			done <- h.consulClient.Agent().EnableNodeMaintenance(reason)
+		}()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to enable node maintenance: %w", err)
+		}
+		h.config.Logger.Info("consul node maintenance enabled", "handler", h.Name())
+		return nil
+	}
+
+	opts := (&consulApi.QueryOptions{}).WithContext(ctx)
+	for _, serviceID := range h.config.Services {
+		if err := h.consulClient.Agent().EnableServiceMaintenanceOpts(serviceID, reason, opts); err != nil {
+			return fmt.Errorf("failed to enable maintenance for service %s: %w", serviceID, err)
+		}
+		h.config.Logger.Info("consul service maintenance enabled", "service_id", serviceID, "handler", h.Name())
+	}
+
+	return nil
+}
+
+// deregisterServices deregisters the configured services, or every service
+// registered on the local agent when no services are configured
+func (h *ConsulHandler) deregisterServices(ctx context.Context) error {
+	opts := (&consulApi.QueryOptions{}).WithContext(ctx)
+
+	serviceIDs, err := h.serviceIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.config.Logger.Info("deregistering consul services", "total_services", len(serviceIDs), "handler", h.Name())
+
+	for _, serviceID := range serviceIDs {
+		if err := h.consulClient.Agent().ServiceDeregisterOpts(serviceID, opts); err != nil {
+			return fmt.Errorf("failed to deregister service %s: %w", serviceID, err)
+		}
+		h.config.Logger.Info("consul service deregistered", "service_id", serviceID, "handler", h.Name())
+	}
+
+	return nil
+}
+
+// serviceIDs returns the configured services, or every service registered on the local agent
+func (h *ConsulHandler) serviceIDs(ctx context.Context) ([]string, error) {
+	if len(h.config.Services) > 0 {
+		return h.config.Services, nil
+	}
+
+	services, err := h.consulClient.Agent().ServicesWithFilterOpts("", (&consulApi.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agent services: %w", err)
+	}
+
+	var serviceIDs []string
+	for id := range services {
+		serviceIDs = append(serviceIDs, id)
+	}
+
+	return serviceIDs, nil
+}
+
+// reportServices logs what the configured mode would change on the agent
+func (h *ConsulHandler) reportServices(ctx context.Context, reason string) error {
+	if h.config.Mode == ConsulModeMaintenance && len(h.config.Services) == 0 {
+		h.config.Logger.Info("consul node maintenance would be enabled", "reason", reason, "dry_run", true, "handler", h.Name())
+		return nil
+	}
+
+	serviceIDs, err := h.serviceIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.config.Logger.Info("consul services would be removed from discovery", "mode", h.config.Mode, "services", serviceIDs, "reason", reason, "dry_run", true, "handler", h.Name())
+	return nil
+}
@@ -11,6 +11,7 @@ type DummyHandler struct {
 
 type DummyHandlerConfig struct {
 	Logger *slog.Logger
+	Name   string
 }
 
 func NewDummyHandler(config *DummyHandlerConfig) *DummyHandler {
@@ -25,10 +26,22 @@ func (h *DummyHandler) HandleTermination(ctx context.Context, event TerminationE
 		"private_ip", event.PrivateIP,
 		"instance_id", event.InstanceID,
 		"reason", event.Reason,
+		"key", event.Key,
+	)
+	return nil
+}
+
+func (h *DummyHandler) HandleCancellation(ctx context.Context, event TerminationEvent) error {
+	h.config.Logger.Info("dummy handler recovered",
+		"hostname", event.Hostname,
+		"instance_id", event.InstanceID,
 	)
 	return nil
 }
 
 func (h *DummyHandler) Name() string {
-	return "dummy"
+	if h.config.Name != "" {
+		return h.config.Name
+	}
+	return string(HandlerNameDummy)
 }
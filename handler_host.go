@@ -0,0 +1,322 @@
+package evacuator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+)
+
+// HostHandler stops workloads running directly on the VM, for instances that
+// are not part of an orchestrator. Labelled Docker containers are stopped
+// through the Docker Engine API, then systemd units are stopped in order
+// through the systemd D-Bus API.
+type HostHandler struct {
+	dockerClient *http.Client
+	config       HostHandlerConfig
+}
+
+type HostHandlerConfig struct {
+	Logger *slog.Logger
+	Name   string
+
+	DockerEnabled     bool
+	DockerSocket      string
+	DockerLabel       string
+	DockerStopTimeout time.Duration
+
+	SystemdEnabled bool
+	SystemdUnits   []string
+
+	// DryRun lists the containers and units that would be stopped
+	DryRun bool
+}
+
+const (
+	// docker engine api version, supported since docker 20.10
+	HostDockerApiVersion = "v1.41"
+
+	// docker engine api host, ignored when dialing the unix socket
+	HostDockerApiBaseUrl = "http://docker/" + HostDockerApiVersion
+)
+
+type HostDockerContainer struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+	State string   `json:"State"`
+}
+
+func NewHostHandler(config *HostHandlerConfig) (*HostHandler, error) {
+
+	if !config.DockerEnabled && !config.SystemdEnabled {
+		return nil, fmt.Errorf("at least one of docker or systemd must be enabled")
+	}
+
+	var dockerClient *http.Client
+	if config.DockerEnabled {
+		if config.DockerSocket == "" {
+			return nil, fmt.Errorf("docker socket path must be set")
+		}
+
+		// Talk to docker engine over the unix socket, the host part of the url is ignored
+		dockerClient = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", config.DockerSocket)
+				},
+			},
+		}
+	}
+
+	return &HostHandler{
+		dockerClient: dockerClient,
+		config:       *config,
+	}, nil
+}
+
+func (h *HostHandler) Name() string {
+	if h.config.Name != "" {
+		return h.config.Name
+	}
+	return string(HandlerNameHost)
+}
+
+func (h *HostHandler) HandleTermination(ctx context.Context, event TerminationEvent) error {
+	h.config.Logger.Info("handling host workload termination", "node", event.Hostname, "handler", h.Name())
+
+	var stopped, failed []string
+
+	// Containers go first, stopping units like docker.service before them
+	// would kill the containers without a graceful stop
+	if h.config.DockerEnabled {
+		s, f := h.stopContainers(ctx)
+		stopped = append(stopped, s...)
+		failed = append(failed, f...)
+	}
+
+	if h.config.SystemdEnabled {
+		s, f := h.stopUnits(ctx)
+		stopped = append(stopped, s...)
+		failed = append(failed, f...)
+	}
+
+	h.config.Logger.Info("host workload stop summary",
+		"node", event.Hostname,
+		"stopped", stopped,
+		"failed", failed,
+		"handler", h.Name())
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to stop %d host workloads: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	h.config.Logger.Info("host workload termination handling completed successfully", "node", event.Hostname, "handler", h.Name())
+	return nil
+}
+
+// stopContainers stops all running labelled containers in parallel and
+// returns the containers that stopped cleanly and the ones that failed
+func (h *HostHandler) stopContainers(ctx context.Context) ([]string, []string) {
+	containers, err := h.listContainers(ctx)
+	if err != nil {
+		h.config.Logger.Error("failed to list docker containers", "error", err.Error(), "handler", h.Name())
+		return nil, []string{"docker: " + err.Error()}
+	}
+
+	h.config.Logger.Info("found docker containers to stop", "label", h.config.DockerLabel, "total_containers", len(containers), "handler", h.Name())
+
+	if h.config.DryRun {
+		var names []string
+		for _, c := range containers {
+			names = append(names, "container/"+c.displayName())
+		}
+		h.config.Logger.Info("docker containers would be stopped", "containers", names, "stop_timeout", h.stopTimeout(ctx).String(), "dry_run", true, "handler", h.Name())
+		return names, nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var stopped, failed []string
+
+	for _, container := range containers {
+		wg.Add(1)
+		go func(c HostDockerContainer) {
+			defer wg.Done()
+
+			name := "container/" + c.displayName()
+			err := h.stopContainer(ctx, c.ID)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				h.config.Logger.Error("failed to stop docker container", "container", c.displayName(), "error", err.Error(), "handler", h.Name())
+				failed = append(failed, name)
+				return
+			}
+			h.config.Logger.Info("docker container stopped", "container", c.displayName(), "handler", h.Name())
+			stopped = append(stopped, name)
+		}(container)
+	}
+
+	wg.Wait()
+
+	return stopped, failed
+}
+
+// listContainers returns the running containers matching the configured label
+func (h *HostHandler) listContainers(ctx context.Context) ([]HostDockerContainer, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"label":  {h.config.DockerLabel},
+		"status": {"running"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := h.doDockerRequest(ctx, "GET", "/containers/json?filters="+url.QueryEscape(string(filters)))
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []HostDockerContainer
+	if err := json.Unmarshal(body, &containers); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal docker containers: %w", err)
+	}
+
+	return containers, nil
+}
+
+// stopContainer asks docker to stop a container, docker sends SIGTERM and
+// only kills the container once the stop timeout has passed
+func (h *HostHandler) stopContainer(ctx context.Context, id string) error {
+	timeout := h.stopTimeout(ctx)
+	_, err := h.doDockerRequest(ctx, "POST", fmt.Sprintf("/containers/%s/stop?t=%d", id, int(timeout.Seconds())))
+	return err
+}
+
+// stopTimeout returns the configured stop timeout, shortened so docker
+// kills the container before the handler deadline is reached
+func (h *HostHandler) stopTimeout(ctx context.Context) time.Duration {
+	timeout := h.config.DockerStopTimeout
+
+	if deadline, ok := ctx.Deadline(); ok {
+		// keep a second for docker to kill the container and respond
+		remaining := time.Until(deadline) - time.Second
+		if remaining < timeout {
+			timeout = remaining
+		}
+	}
+
+	if timeout < 0 {
+		timeout = 0
+	}
+
+	return timeout
+}
+
+func (h *HostHandler) doDockerRequest(ctx context.Context, method string, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, HostDockerApiBaseUrl+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := h.dockerClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// 304 is returned when the container is already stopped
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("got %d as http request: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+// stopUnits stops the configured systemd units one by one in the configured order
+func (h *HostHandler) stopUnits(ctx context.Context) ([]string, []string) {
+	if h.config.DryRun {
+		var names []string
+		for _, unit := range h.config.SystemdUnits {
+			names = append(names, "unit/"+unit)
+		}
+		h.config.Logger.Info("systemd units would be stopped in order", "units", names, "dry_run", true, "handler", h.Name())
+		return names, nil
+	}
+
+	conn, err := systemdDbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		h.config.Logger.Error("failed to connect to systemd", "error", err.Error(), "handler", h.Name())
+		return nil, []string{"systemd: " + err.Error()}
+	}
+	defer conn.Close()
+
+	var stopped, failed []string
+
+	for _, unit := range h.config.SystemdUnits {
+		name := "unit/" + unit
+
+		if ctx.Err() != nil {
+			h.config.Logger.Error("deadline reached before stopping systemd unit", "unit", unit, "handler", h.Name())
+			failed = append(failed, name)
+			continue
+		}
+
+		if err := h.stopUnit(ctx, conn, unit); err != nil {
+			h.config.Logger.Error("failed to stop systemd unit", "unit", unit, "error", err.Error(), "handler", h.Name())
+			failed = append(failed, name)
+			continue
+		}
+
+		h.config.Logger.Info("systemd unit stopped", "unit", unit, "handler", h.Name())
+		stopped = append(stopped, name)
+	}
+
+	return stopped, failed
+}
+
+// stopUnit enqueues a stop job for the unit and waits for the job result
+func (h *HostHandler) stopUnit(ctx context.Context, conn *systemdDbus.Conn, unit string) error {
+	result := make(chan string, 1)
+
+	if _, err := conn.StopUnitContext(ctx, unit, "replace", result); err != nil {
+		return err
+	}
+
+	select {
+	case r := <-result:
+		if r != "done" {
+			return fmt.Errorf("stop job finished with result %q", r)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// displayName returns the container name without the leading slash, or the short ID
+func (c HostDockerContainer) displayName() string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	if len(c.ID) > 12 {
+		return c.ID[:12]
+	}
+	return c.ID
+}
@@ -2,6 +2,7 @@ package evacuator
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"sync"
@@ -11,31 +12,203 @@ import (
 	policyv1 "k8s.io/api/policy/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 )
 
 type KubernetesHandler struct {
-	RestConfig *kubernetes.Clientset // Kubernetes clientset for interacting with the cluster
-	config     KubernetesHandlerConfig
+	RestConfig    *kubernetes.Clientset // Kubernetes clientset for interacting with the cluster
+	dynamicClient dynamic.Interface     // for the Karpenter NodeClaims, only set when pre-scaling with Karpenter
+	config        KubernetesHandlerConfig
+	coordinator   *KubernetesCoordinator
+	podCache      *kubernetesPodCache // pods of the node this evacuator runs on, nil without node name
+
+	criticalSelector labels.Selector // pods whose failed eviction fails the critical policy
 }
 
 type KubernetesHandlerConfig struct {
 	Logger             *slog.Logger
+	Name               string
 	InCluster          bool
 	Kubeconfig         string
 	SkipDaemonSets     bool
 	DeleteEmptyDirData bool
+
+	// QPS and Burst of the client, zero for the client-go defaults
+	QPS   float32
+	Burst int
+
+	// VerifyPermissions fails the startup when the RBAC misses the permissions
+	// to cordon and evict
+	VerifyPermissions bool
+
+	// KeepaliveInterval is the delay between requests keeping the connection
+	// to the api server open, zero to let it close
+	KeepaliveInterval time.Duration
+
+	// NodeName is tried when no node matches the event, usually NODE_NAME
+	// from the downward API
+	NodeName string
+
+	// PodCache watches the pods of NodeName from startup
+	PodCache bool
+
+	// Taint is the NoSchedule taint key added to the node with the cordon,
+	// empty to only cordon
+	Taint string
+
+	// DrainFailurePolicy decides when failed evictions fail the drain, one of
+	// any, threshold, critical or never
+	DrainFailurePolicy             string
+	DrainFailureThreshold          int
+	DrainFailureCriticalNamespaces []string
+	DrainFailureCriticalSelector   string
+
+	// VolumeDetachTimeout bounds the wait for the volumes of evicted pods to
+	// detach from the node, zero to not wait
+	VolumeDetachTimeout time.Duration
+
+	// Coordination limits the nodes draining at once across the cluster
+	CoordinationEnabled             bool
+	CoordinationNamespace           string
+	CoordinationConfigMap           string
+	CoordinationMaxConcurrentDrains int
+	CoordinationMaxWait             time.Duration
+	CoordinationWindow              time.Duration
+
+	// Prescale requests replacement capacity before the drain
+	PrescaleKarpenter                bool
+	PrescalePlaceholderEnabled       bool
+	PrescalePlaceholderNamespace     string
+	PrescalePlaceholderPriorityClass string
+	PrescalePlaceholderImage         string
+
+	// DryRun sends the cordon and evictions as server-side dry-run requests,
+	// so RBAC and PodDisruptionBudgets are checked without changing anything
+	DryRun bool
 }
 
+// kubernetesCordonPatch marks the node as unschedulable
+const kubernetesCordonPatch = `{"spec":{"unschedulable":true}}`
+
+// KubernetesTerminatingTaint is the default taint of nodes about to terminate,
+// tolerations can select it where a cordon cannot be selected
+const KubernetesTerminatingTaint = "evacuator.io/terminating"
+
 func NewKubernetesHandler(config *KubernetesHandlerConfig) (*KubernetesHandler, error) {
 
+	restConfig, err := newKubernetesRestConfig(config.InCluster, config.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// the defaults of 5 and 10 throttle a drain of a hundred pods
+	if config.QPS > 0 {
+		restConfig.QPS = config.QPS
+	}
+	if config.Burst > 0 {
+		restConfig.Burst = config.Burst
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %s", err)
+	}
+
+	h := &KubernetesHandler{
+		RestConfig: clientset,
+		config:     *config,
+	}
+
+	if config.PrescaleKarpenter {
+		h.dynamicClient, err = dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create kubernetes dynamic client: %s", err)
+		}
+	}
+
+	if config.DrainFailureCriticalSelector != "" {
+		h.criticalSelector, err = labels.Parse(config.DrainFailureCriticalSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid critical selector: %s", err)
+		}
+	}
+
+	// the cache needs the node before any event, a controller has no node
+	if config.PodCache {
+		if config.NodeName != "" {
+			h.podCache = newKubernetesPodCache(clientset, config.NodeName)
+		} else {
+			config.Logger.Info("pod cache disabled, node_name is not set", "handler", h.Name())
+		}
+	}
+
+	if config.CoordinationEnabled {
+		h.coordinator = NewKubernetesCoordinator(clientset, &KubernetesCoordinatorConfig{
+			Logger:        config.Logger.With("handler", h.Name()),
+			Namespace:     config.CoordinationNamespace,
+			ConfigMap:     config.CoordinationConfigMap,
+			MaxConcurrent: config.CoordinationMaxConcurrentDrains,
+			MaxWait:       config.CoordinationMaxWait,
+			Window:        config.CoordinationWindow,
+		})
+	}
+
+	// warm up the connection, the discovery and TLS handshake are paid now
+	// instead of inside the termination window
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// ServerVersion takes no context, an unreachable api would block the
+	// startup for the transport timeout instead
+	var version version.Info
+	body, err := clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err == nil {
+		err = json.Unmarshal(body, &version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach kubernetes api: %s", err)
+	}
+	config.Logger.Info("connected to kubernetes api", "version", version.GitVersion, "handler", h.Name())
+
+	if config.VerifyPermissions {
+		if err := h.verifyPermissions(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// newKubernetesClientset creates a clientset from the in-cluster service account or a kubeconfig file
+func newKubernetesClientset(inCluster bool, kubeconfig string) (*kubernetes.Clientset, error) {
+
+	k8sRestConfig, err := newKubernetesRestConfig(inCluster, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(k8sRestConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %s", err)
+	}
+
+	return clientset, nil
+}
+
+// newKubernetesRestConfig loads the in-cluster service account or a kubeconfig file
+func newKubernetesRestConfig(inCluster bool, kubeconfig string) (*rest.Config, error) {
+
 	var k8sRestConfig *rest.Config
 
 	// Get Kubernetes config based on configuration
-	if config.InCluster {
+	if inCluster {
 		var err error
 		k8sRestConfig, err = rest.InClusterConfig()
 		if err != nil {
@@ -44,76 +217,247 @@ func NewKubernetesHandler(config *KubernetesHandlerConfig) (*KubernetesHandler,
 	} else {
 
 		var err error
-		k8sRestConfig, err = clientcmd.BuildConfigFromFlags("", config.Kubeconfig)
+		k8sRestConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get kubeconfig: %s", err)
 		}
 	}
 
-	clientset, err := kubernetes.NewForConfig(k8sRestConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client: %s", err)
-	}
-
-	return &KubernetesHandler{
-		RestConfig: clientset,
-		config:     *config,
-	}, nil
+	return k8sRestConfig, nil
 }
 
 func (h *KubernetesHandler) Name() string {
-	return "kubernetes"
+	if h.config.Name != "" {
+		return h.config.Name
+	}
+	return string(HandlerNameKubernetes)
 }
 
 func (h *KubernetesHandler) HandleTermination(ctx context.Context, event TerminationEvent) error {
 	h.config.Logger.Info("handling kubernetes node termination", "node", event.Hostname, "handler", h.Name())
 
-	// check if kubernetes node is exist
-	_, err := h.RestConfig.CoreV1().Nodes().Get(ctx, event.Hostname, v1.GetOptions{})
+	// find the kubernetes node of the instance
+	node, err := h.resolveNode(ctx, event)
 	if err != nil {
 		return fmt.Errorf("failed to get kubernetes node: %s", err)
 	}
+	nodeName := node.Name
 
-	h.config.Logger.Info("kubernetes node found, proceeding with cordon", "node", event.Hostname, "handler", h.Name())
+	h.config.Logger.Info("kubernetes node found, proceeding with cordon", "node", nodeName, "dry_run", h.config.DryRun, "handler", h.Name())
 
 	// cordon the node
-	_, err = h.RestConfig.CoreV1().Nodes().Patch(ctx, event.Hostname, types.MergePatchType, []byte(`{"spec":{"unschedulable":true}}`), v1.PatchOptions{})
+	cordonPatch := kubernetesCordonPatchFor(node)
+	_, err = h.RestConfig.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, []byte(cordonPatch), h.patchOptions())
 	if err != nil {
 		return fmt.Errorf("failed to cordon kubernetes node: %s", err)
 	}
 
-	h.config.Logger.Info("kubernetes node successfully cordoned", "node", event.Hostname, "handler", h.Name())
+	if h.config.DryRun {
+		h.config.Logger.Info("kubernetes node would be cordoned", "node", nodeName, "patch", cordonPatch, "dry_run", true, "handler", h.Name())
+	} else {
+		h.config.Logger.Info("kubernetes node successfully cordoned", "node", nodeName, "handler", h.Name())
+	}
+
+	if err := h.setTerminationCondition(ctx, nodeName, event); err != nil {
+		h.config.Logger.Warn("failed to set kubernetes node condition", "node", nodeName, "error", err.Error(), "handler", h.Name())
+	}
+
+	// taint before waiting for a drain slot, so pods evicted from other
+	// terminating nodes are not scheduled here in the meantime. The cordon
+	// already keeps most of them away, a failed taint does not stop the drain.
+	if h.config.Taint != "" {
+		if err := h.taintNode(ctx, nodeName); err != nil {
+			h.config.Logger.Warn("failed to taint kubernetes node", "node", nodeName, "error", err.Error(), "handler", h.Name())
+		}
+	}
+
+	// replacement capacity boots while this node waits for a slot and drains
+	h.prescale(ctx, nodeName, event)
+	if h.config.PrescalePlaceholderEnabled {
+		defer h.deletePlaceholders(context.WithoutCancel(ctx), nodeName)
+	}
+
+	coordinated := false
+	if h.coordinator != nil {
+		if h.config.DryRun {
+			h.config.Logger.Info("kubernetes node would wait for a drain slot", "node", nodeName, "config_map", h.config.CoordinationNamespace+"/"+h.config.CoordinationConfigMap, "dry_run", true, "handler", h.Name())
+		} else if err := h.coordinator.Acquire(ctx, nodeName); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			// an unreachable ConfigMap must not stop the drain
+			h.config.Logger.Warn("drain coordination failed, draining without it", "node", nodeName, "error", err.Error(), "handler", h.Name())
+		} else {
+			coordinated = true
+		}
+	}
 
 	// drain the node
-	err = h.drainNode(ctx, event.Hostname)
+	evicted, failed, err := h.drainNode(ctx, nodeName)
+
+	if coordinated {
+		stats, releaseErr := h.coordinator.Release(ctx, nodeName, evicted, failed, err)
+		if releaseErr != nil {
+			h.config.Logger.Warn("failed to release drain slot", "node", nodeName, "error", releaseErr.Error(), "handler", h.Name())
+		} else {
+			h.config.Logger.Info("interruption stats",
+				"started_at", stats.StartedAt,
+				"nodes_noticed", stats.NodesNoticed,
+				"nodes_drained", stats.NodesDrained,
+				"nodes_failed", stats.NodesFailed,
+				"pods_evicted", stats.PodsEvicted,
+				"pods_failed", stats.PodsFailed,
+				"handler", h.Name())
+		}
+	}
+
 	if err != nil {
-		return fmt.Errorf("failed to drain kubernetes node: %s", err)
+		return fmt.Errorf("failed to drain kubernetes node: %w", err)
 	}
 
-	h.config.Logger.Info("kubernetes node termination handling completed successfully", "node", event.Hostname, "handler", h.Name())
+	h.config.Logger.Info("kubernetes node termination handling completed successfully", "node", nodeName, "handler", h.Name())
 	return nil
 }
 
-// drainNode drains a Kubernetes node by evicting all pods except DaemonSet pods
-func (h *KubernetesHandler) drainNode(ctx context.Context, nodeName string) error {
-	h.config.Logger.Info("starting node drain", "node", nodeName, "handler", h.Name())
+// taintNode adds the NoSchedule taint to the node unless it is already there
+func (h *KubernetesHandler) taintNode(ctx context.Context, nodeName string) error {
+	tainted := false
 
-	// Get all pods on the node
-	fieldSelector := fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
-	pods, err := h.RestConfig.CoreV1().Pods("").List(ctx, v1.ListOptions{
-		FieldSelector: fieldSelector,
+	// the taints are a list, so the node is updated instead of patched
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := h.RestConfig.CoreV1().Nodes().Get(ctx, nodeName, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == h.config.Taint && taint.Effect == corev1.TaintEffectNoSchedule {
+				tainted = true
+				return nil
+			}
+		}
+
+		node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{
+			Key:       h.config.Taint,
+			Effect:    corev1.TaintEffectNoSchedule,
+			TimeAdded: &v1.Time{Time: time.Now()},
+		})
+
+		_, err = h.RestConfig.CoreV1().Nodes().Update(ctx, node, h.updateOptions())
+		return err
 	})
 	if err != nil {
-		return fmt.Errorf("failed to list pods on node %s: %w", nodeName, err)
+		return err
+	}
+
+	switch {
+	case tainted:
+		h.config.Logger.Info("kubernetes node already tainted", "node", nodeName, "taint", h.config.Taint, "handler", h.Name())
+	case h.config.DryRun:
+		h.config.Logger.Info("kubernetes node would be tainted", "node", nodeName, "taint", h.config.Taint+":NoSchedule", "dry_run", true, "handler", h.Name())
+	default:
+		h.config.Logger.Info("kubernetes node successfully tainted", "node", nodeName, "taint", h.config.Taint+":NoSchedule", "handler", h.Name())
 	}
 
-	h.config.Logger.Info("found pods on node", "node", nodeName, "total_pods", len(pods.Items), "handler", h.Name())
+	return nil
+}
+
+// drainNode drains a Kubernetes node by evicting all pods except DaemonSet pods,
+// returning the number of pods evicted and failed to evict
+func (h *KubernetesHandler) drainNode(ctx context.Context, nodeName string) (int, int, error) {
+	h.config.Logger.Info("starting node drain", "node", nodeName, "handler", h.Name())
+
+	podsToEvict, skippedPods, err := h.listPodsToEvict(ctx, nodeName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Log summary of pods found
+	h.config.Logger.Info("pod eviction summary",
+		"node", nodeName,
+		"pods_to_evict", len(podsToEvict),
+		"skipped_terminating", skippedPods["terminating"],
+		"skipped_completed", skippedPods["completed"],
+		"skipped_daemonset", skippedPods["daemonset"],
+		"skipped_static", skippedPods["static"],
+		"skipped_emptydir", skippedPods["emptydir"],
+		"handler", h.Name())
+
+	if len(podsToEvict) == 0 {
+		h.config.Logger.Info("no pods to evict", "node", nodeName, "handler", h.Name())
+		return 0, 0, nil
+	}
+
+	// resolve the volumes before the eviction deletes ephemeral claims
+	var volumes map[string]bool
+	if h.config.VolumeDetachTimeout > 0 {
+		volumes, err = h.persistentVolumes(ctx, podsToEvict)
+		if err != nil {
+			h.config.Logger.Warn("failed to find the volumes of pods to evict", "node", nodeName, "error", err.Error(), "handler", h.Name())
+		}
+	}
+
+	// StatefulSet pods go last, their other replicas keep serving while the
+	// stateless pods move
+	statelessPods, statefulPods := h.splitStatefulSetPods(podsToEvict)
+
+	// Evict all pods in parallel with shared context timeout
+	successCount, failures := h.evictPodsInParallel(ctx, statelessPods, nodeName)
+
+	if len(statefulPods) > 0 {
+		statefulCount, statefulFailures := h.evictStatefulSetPods(ctx, statefulPods, nodeName)
+		successCount += statefulCount
+		failures = append(failures, statefulFailures...)
+	}
+
+	if h.config.VolumeDetachTimeout > 0 {
+		h.waitForVolumeDetach(ctx, nodeName, volumes)
+	}
+
+	// In emergency situations partial success can be better than total
+	// failure, the failure policy decides whether failed pods fail the drain
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			h.config.Logger.Error("pod eviction error",
+				"pod", failure.Name,
+				"namespace", failure.Namespace,
+				"error", failure.Reason,
+				"critical", failure.Critical,
+				"handler", h.Name())
+		}
+
+		drainErr := &DrainError{
+			Node:   nodeName,
+			Policy: h.config.DrainFailurePolicy,
+			Total:  len(podsToEvict),
+			Failed: failures,
+		}
+		if h.drainFailed(drainErr) {
+			return successCount, len(failures), drainErr
+		}
+
+		h.config.Logger.Warn("failed pod evictions tolerated by the drain failure policy", "node", nodeName, "failed_pods", len(failures), "policy", h.config.DrainFailurePolicy, "handler", h.Name())
+	}
+
+	h.config.Logger.Info("node drain completed successfully", "node", nodeName, "evicted_pods", successCount, "handler", h.Name())
+	return successCount, len(failures), nil
+}
+
+// listPodsToEvict returns the pods on the node that a drain evicts, with the
+// number of pods skipped per reason
+func (h *KubernetesHandler) listPodsToEvict(ctx context.Context, nodeName string) ([]corev1.Pod, map[string]int, error) {
+	pods, err := h.listPods(ctx, nodeName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.config.Logger.Info("found pods on node", "node", nodeName, "total_pods", len(pods), "handler", h.Name())
 
 	var podsToEvict []corev1.Pod
 	var skippedPods = make(map[string]int)
 
 	// Filter and collect pods to evict
-	for _, pod := range pods.Items {
+	for _, pod := range pods {
 		// Skip pods that are already terminating
 		if pod.DeletionTimestamp != nil {
 			skippedPods["terminating"]++
@@ -150,34 +494,44 @@ func (h *KubernetesHandler) drainNode(ctx context.Context, nodeName string) erro
 		podsToEvict = append(podsToEvict, pod)
 	}
 
-	// Log summary of pods found
-	h.config.Logger.Info("pod eviction summary",
-		"node", nodeName,
-		"pods_to_evict", len(podsToEvict),
-		"skipped_terminating", skippedPods["terminating"],
-		"skipped_completed", skippedPods["completed"],
-		"skipped_daemonset", skippedPods["daemonset"],
-		"skipped_static", skippedPods["static"],
-		"skipped_emptydir", skippedPods["emptydir"],
-		"handler", h.Name())
+	return podsToEvict, skippedPods, nil
+}
 
-	if len(podsToEvict) == 0 {
-		h.config.Logger.Info("no pods to evict", "node", nodeName, "handler", h.Name())
-		return nil
+// listPods returns the pods on the node, from the pod cache when it serves the node
+func (h *KubernetesHandler) listPods(ctx context.Context, nodeName string) ([]corev1.Pod, error) {
+	if h.podCache.serves(nodeName) {
+		pods, err := h.podCache.pods()
+		if err == nil {
+			return pods, nil
+		}
+		h.config.Logger.Warn("failed to list pods from cache, listing from api", "node", nodeName, "error", err.Error(), "handler", h.Name())
 	}
 
-	// Evict all pods in parallel with shared context timeout
-	return h.evictPodsInParallel(ctx, podsToEvict, nodeName)
+	// Get all pods on the node
+	fieldSelector := fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+	pods, err := h.RestConfig.CoreV1().Pods("").List(ctx, v1.ListOptions{
+		FieldSelector: fieldSelector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods on node %s: %w", nodeName, err)
+	}
+
+	return pods.Items, nil
 }
 
-// evictPodsInParallel evicts multiple pods in parallel and waits for all to complete
-func (h *KubernetesHandler) evictPodsInParallel(ctx context.Context, podsToEvict []corev1.Pod, nodeName string) error {
+// evictPodsInParallel evicts multiple pods in parallel and waits for all to complete,
+// returning the number of evicted pods and the pods that failed
+func (h *KubernetesHandler) evictPodsInParallel(ctx context.Context, podsToEvict []corev1.Pod, nodeName string) (int, []PodEvictionFailure) {
+	if len(podsToEvict) == 0 {
+		return 0, nil
+	}
+
 	h.config.Logger.Info("starting parallel pod eviction", "node", nodeName, "pod_count", len(podsToEvict), "handler", h.Name())
 
 	// Use sync package for coordination
 	var wg sync.WaitGroup
 	var mu sync.Mutex
-	var evictionErrors []error
+	var failures []PodEvictionFailure
 	successCount := 0
 
 	// Start eviction for each pod in parallel
@@ -190,7 +544,7 @@ func (h *KubernetesHandler) evictPodsInParallel(ctx context.Context, podsToEvict
 
 			mu.Lock()
 			if err != nil {
-				evictionErrors = append(evictionErrors, fmt.Errorf("pod %s/%s: %w", p.Namespace, p.Name, err))
+				failures = append(failures, h.podEvictionFailure(&p, err))
 			} else {
 				successCount++
 			}
@@ -205,24 +559,67 @@ func (h *KubernetesHandler) evictPodsInParallel(ctx context.Context, podsToEvict
 		"node", nodeName,
 		"total_pods", len(podsToEvict),
 		"successful_evictions", successCount,
-		"failed_evictions", len(evictionErrors),
+		"failed_evictions", len(failures),
 		"handler", h.Name())
 
-	// If we have any errors, log them but don't fail the entire operation
-	// In emergency situations, partial success is better than total failure
-	if len(evictionErrors) > 0 {
-		for _, err := range evictionErrors {
-			h.config.Logger.Error("pod eviction error", "error", err, "handler", h.Name())
-		}
+	return successCount, failures
+}
 
-		// Only fail if more than half the pods failed to evict
-		if len(evictionErrors) > len(podsToEvict)/2 {
-			return fmt.Errorf("failed to evict majority of pods (%d/%d failed)", len(evictionErrors), len(podsToEvict))
+// podDeletion returns a channel closed once the pod is deleted, from the watch
+// of the pod cache when it serves the node, by polling the pod otherwise
+func (h *KubernetesHandler) podDeletion(ctx context.Context, pod *corev1.Pod) (<-chan struct{}, func()) {
+	if h.podCache.serves(pod.Spec.NodeName) {
+		return h.podCache.deleted(pod)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	deleted := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond) // Check more frequently
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if h.podDeleted(ctx, pod) {
+					close(deleted)
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
 		}
+	}()
+
+	return deleted, cancel
+}
+
+// podDeleted checks if the pod is gone, a StatefulSet recreates its pods with
+// the same name so a pod with another UID counts as deleted too
+func (h *KubernetesHandler) podDeleted(ctx context.Context, pod *corev1.Pod) bool {
+	current, err := h.RestConfig.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, v1.GetOptions{})
+	if err != nil {
+		// Pod is deleted (not found error is expected)
+		return true
 	}
+	return current.UID != pod.UID
+}
 
-	h.config.Logger.Info("node drain completed successfully", "node", nodeName, "evicted_pods", successCount, "handler", h.Name())
-	return nil
+// patchOptions returns the options for node patches, server-side dry run in dry run mode
+func (h *KubernetesHandler) patchOptions() v1.PatchOptions {
+	if h.config.DryRun {
+		return v1.PatchOptions{DryRun: []string{v1.DryRunAll}}
+	}
+	return v1.PatchOptions{}
+}
+
+// updateOptions returns the options for node updates, server-side dry run in dry run mode
+func (h *KubernetesHandler) updateOptions() v1.UpdateOptions {
+	if h.config.DryRun {
+		return v1.UpdateOptions{DryRun: []string{v1.DryRunAll}}
+	}
+	return v1.UpdateOptions{}
 }
 
 // hasEmptyDirVolumes checks if a pod has any emptyDir volumes
@@ -274,6 +671,10 @@ func (h *KubernetesHandler) evictPod(ctx context.Context, pod *corev1.Pod) error
 		},
 	}
 
+	if h.config.DryRun {
+		eviction.DeleteOptions = &v1.DeleteOptions{DryRun: []string{v1.DryRunAll}}
+	}
+
 	// Try to evict the pod
 	err := h.RestConfig.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
 	if err != nil {
@@ -285,6 +686,16 @@ func (h *KubernetesHandler) evictPod(ctx context.Context, pod *corev1.Pod) error
 		return fmt.Errorf("eviction failed: %w", err)
 	}
 
+	// nothing is deleted in dry run, the accepted eviction is the result
+	if h.config.DryRun {
+		h.config.Logger.Info("pod would be evicted",
+			"pod", pod.Name,
+			"namespace", pod.Namespace,
+			"dry_run", true,
+			"handler", h.Name())
+		return nil
+	}
+
 	h.config.Logger.Debug("pod eviction request sent, waiting for deletion",
 		"pod", pod.Name,
 		"namespace", pod.Namespace,
@@ -295,8 +706,8 @@ func (h *KubernetesHandler) evictPod(ctx context.Context, pod *corev1.Pod) error
 	timeout := time.NewTimer(5 * time.Second)
 	defer timeout.Stop()
 
-	ticker := time.NewTicker(500 * time.Millisecond) // Check more frequently
-	defer ticker.Stop()
+	deleted, stop := h.podDeletion(ctx, pod)
+	defer stop()
 
 	for {
 		select {
@@ -309,16 +720,12 @@ func (h *KubernetesHandler) evictPod(ctx context.Context, pod *corev1.Pod) error
 			// Don't return error - pod might still be terminating gracefully
 			// This allows the drain to continue with other pods
 			return nil
-		case <-ticker.C:
-			_, err := h.RestConfig.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, v1.GetOptions{})
-			if err != nil {
-				// Pod is deleted (not found error is expected)
-				h.config.Logger.Info("pod successfully evicted and deleted",
-					"pod", pod.Name,
-					"namespace", pod.Namespace,
-					"handler", h.Name())
-				return nil
-			}
+		case <-deleted:
+			h.config.Logger.Info("pod successfully evicted and deleted",
+				"pod", pod.Name,
+				"namespace", pod.Namespace,
+				"handler", h.Name())
+			return nil
 		case <-ctx.Done():
 			h.config.Logger.Error("context cancelled while waiting for pod deletion",
 				"pod", pod.Name,
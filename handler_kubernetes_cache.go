@@ -0,0 +1,136 @@
+package evacuator
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// kubernetesPodCache watches the pods of one node from startup, so the drain
+// is planned from memory and pod deletions arrive as watch events instead of
+// being polled one by one
+type kubernetesPodCache struct {
+	nodeName string
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   listersv1.PodLister
+
+	mu sync.Mutex
+	// channels closed when the pod with the UID is deleted
+	waiters map[types.UID][]chan struct{}
+}
+
+func newKubernetesPodCache(clientset kubernetes.Interface, nodeName string) *kubernetesPodCache {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithTweakListOptions(func(options *v1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}))
+
+	pods := factory.Core().V1().Pods()
+
+	c := &kubernetesPodCache{
+		nodeName: nodeName,
+		factory:  factory,
+		informer: pods.Informer(),
+		lister:   pods.Lister(),
+		waiters:  make(map[types.UID][]chan struct{}),
+	}
+
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				c.notifyDeleted(pod.UID)
+			}
+		},
+	})
+
+	return c
+}
+
+// run watches the pods until ctx is done
+func (c *kubernetesPodCache) run(ctx context.Context, logger *slog.Logger) {
+	c.factory.Start(ctx.Done())
+
+	if cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		logger.Info("pod cache synced", "node", c.nodeName, "pods", len(c.informer.GetStore().ListKeys()))
+	}
+
+	<-ctx.Done()
+	c.factory.Shutdown()
+}
+
+// serves reports whether the cache holds the pods of the node
+func (c *kubernetesPodCache) serves(nodeName string) bool {
+	return c != nil && c.nodeName == nodeName && c.informer.HasSynced()
+}
+
+// pods returns the cached pods of the node
+func (c *kubernetesPodCache) pods() ([]corev1.Pod, error) {
+	cached, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]corev1.Pod, 0, len(cached))
+	for _, pod := range cached {
+		pods = append(pods, *pod)
+	}
+	return pods, nil
+}
+
+// deleted returns a channel closed once the pod is deleted, and a function to
+// stop waiting for it
+func (c *kubernetesPodCache) deleted(pod *corev1.Pod) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+
+	// registered before looking at the store, so a deletion in between is not missed
+	c.mu.Lock()
+	c.waiters[pod.UID] = append(c.waiters[pod.UID], ch)
+	c.mu.Unlock()
+
+	current, err := c.lister.Pods(pod.Namespace).Get(pod.Name)
+	if err != nil || current.UID != pod.UID {
+		c.notifyDeleted(pod.UID)
+	}
+
+	stop := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		waiters := c.waiters[pod.UID]
+		for i, waiter := range waiters {
+			if waiter == ch {
+				c.waiters[pod.UID] = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(c.waiters[pod.UID]) == 0 {
+			delete(c.waiters, pod.UID)
+		}
+	}
+
+	return ch, stop
+}
+
+func (c *kubernetesPodCache) notifyDeleted(uid types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ch := range c.waiters[uid] {
+		close(ch)
+	}
+	delete(c.waiters, uid)
+}
@@ -0,0 +1,155 @@
+package evacuator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kubernetesPermission is an API access the handler needs, required ones fail
+// the startup while the others only disable a feature
+type kubernetesPermission struct {
+	Group       string
+	Resource    string
+	Subresource string
+	Verb        string
+	Namespace   string
+	Required    bool
+}
+
+func (p kubernetesPermission) String() string {
+	resource := p.Resource
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	if p.Namespace != "" {
+		resource += " in " + p.Namespace
+	}
+	return p.Verb + " " + resource
+}
+
+// permissions lists the accesses needed by the enabled features
+func (h *KubernetesHandler) permissions() []kubernetesPermission {
+	permissions := []kubernetesPermission{
+		{Resource: "nodes", Verb: "get", Required: true},
+		{Resource: "nodes", Verb: "patch", Required: true},
+		{Resource: "pods", Verb: "list", Required: true},
+		{Resource: "pods", Subresource: "eviction", Verb: "create", Required: true},
+		{Resource: "nodes", Verb: "list"},
+		{Resource: "nodes", Subresource: "status", Verb: "patch"},
+		{Resource: "pods", Verb: "get"},
+		{Resource: "events", Verb: "create", Namespace: v1.NamespaceDefault},
+	}
+
+	if h.config.Taint != "" {
+		permissions = append(permissions, kubernetesPermission{Resource: "nodes", Verb: "update"})
+	}
+
+	// the pod cache informer lists then watches the pods of the node
+	if h.podCache != nil {
+		permissions = append(permissions, kubernetesPermission{Resource: "pods", Verb: "watch"})
+	}
+
+	if h.config.VolumeDetachTimeout > 0 {
+		permissions = append(permissions,
+			kubernetesPermission{Resource: "persistentvolumeclaims", Verb: "get"},
+			kubernetesPermission{Group: "storage.k8s.io", Resource: "volumeattachments", Verb: "list"})
+	}
+
+	if h.config.CoordinationEnabled {
+		for _, verb := range []string{"get", "create", "update"} {
+			permissions = append(permissions, kubernetesPermission{Resource: "configmaps", Verb: verb, Namespace: h.config.CoordinationNamespace})
+		}
+	}
+
+	if h.config.PrescaleKarpenter {
+		for _, verb := range []string{"list", "delete"} {
+			permissions = append(permissions, kubernetesPermission{Group: karpenterNodeClaimResource.Group, Resource: karpenterNodeClaimResource.Resource, Verb: verb})
+		}
+	}
+
+	if h.config.PrescalePlaceholderEnabled {
+		for _, verb := range []string{"create", "deletecollection"} {
+			permissions = append(permissions, kubernetesPermission{Resource: "pods", Verb: verb, Namespace: h.config.PrescalePlaceholderNamespace})
+		}
+	}
+
+	return permissions
+}
+
+// verifyPermissions checks the RBAC of the handler with SelfSubjectAccessReviews,
+// so missing cordon or evict permissions are found at startup instead of
+// during the termination
+func (h *KubernetesHandler) verifyPermissions(ctx context.Context) error {
+	var missing []string
+
+	for _, permission := range h.permissions() {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:       permission.Group,
+					Resource:    permission.Resource,
+					Subresource: permission.Subresource,
+					Verb:        permission.Verb,
+					Namespace:   permission.Namespace,
+				},
+			},
+		}
+
+		result, err := h.RestConfig.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, v1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to review permission %s: %s", permission, err)
+		}
+
+		if result.Status.Allowed {
+			continue
+		}
+
+		if permission.Required {
+			missing = append(missing, permission.String())
+		} else {
+			h.config.Logger.Warn("kubernetes permission missing, the feature needing it will fail", "permission", permission.String(), "handler", h.Name())
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing kubernetes permissions: %s", strings.Join(missing, ", "))
+	}
+
+	h.config.Logger.Info("kubernetes permissions verified", "handler", h.Name())
+	return nil
+}
+
+// Run starts the pod cache and keeps the connection to the api server warm,
+// so the first request of a drain does not pay the TLS handshake inside the
+// termination window
+func (h *KubernetesHandler) Run(ctx context.Context) {
+	if h.podCache != nil {
+		go h.podCache.run(ctx, h.config.Logger.With("handler", h.Name()))
+	}
+
+	if h.config.KeepaliveInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(h.config.KeepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := h.RestConfig.Discovery().ServerVersion(); err != nil {
+				h.config.Logger.Warn("kubernetes api unreachable", "error", err.Error(), "handler", h.Name())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package evacuator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// KubernetesCoordinator limits how many nodes drain at once when a whole
+// capacity pool is reclaimed. The drain slots, the nodes waiting for one and
+// the statistics of the interruption are kept in a ConfigMap shared by every
+// evacuator, updated with optimistic concurrency.
+type KubernetesCoordinator struct {
+	clientset kubernetes.Interface
+	config    KubernetesCoordinatorConfig
+}
+
+type KubernetesCoordinatorConfig struct {
+	Logger        *slog.Logger
+	Namespace     string
+	ConfigMap     string
+	MaxConcurrent int
+
+	// MaxWait is how long a node waits for a free slot before draining anyway
+	MaxWait time.Duration
+
+	// Window groups the notices into one interruption, the statistics are reset
+	// when no node was noticed or drained for that long
+	Window time.Duration
+}
+
+// KubernetesInterruptionStats are the fleet-wide statistics of an interruption
+type KubernetesInterruptionStats struct {
+	StartedAt    time.Time `json:"started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	NodesNoticed int       `json:"nodes_noticed"`
+	NodesDrained int       `json:"nodes_drained"`
+	NodesFailed  int       `json:"nodes_failed"`
+	PodsEvicted  int       `json:"pods_evicted"`
+	PodsFailed   int       `json:"pods_failed"`
+}
+
+// kubernetesCoordinationEntry is a node holding or waiting for a drain slot,
+// entries past their deadline belong to evacuators that are gone
+type kubernetesCoordinationEntry struct {
+	Since    time.Time `json:"since"`
+	Deadline time.Time `json:"deadline"`
+}
+
+type kubernetesCoordinationState struct {
+	Holders map[string]kubernetesCoordinationEntry
+	Queue   map[string]kubernetesCoordinationEntry
+	Stats   KubernetesInterruptionStats
+}
+
+const (
+	kubernetesCoordinationHoldersKey = "holders"
+	kubernetesCoordinationQueueKey   = "queue"
+	kubernetesCoordinationStatsKey   = "stats"
+
+	// kubernetesCoordinationPollInterval is the delay between checks for a free slot
+	kubernetesCoordinationPollInterval = time.Second
+
+	// kubernetesCoordinationSlotTimeout bounds a slot taken without a deadline
+	kubernetesCoordinationSlotTimeout = 5 * time.Minute
+)
+
+// kubernetesCoordinationBackoff retries conflicting updates, dozens of nodes
+// may update the ConfigMap at the same moment
+var kubernetesCoordinationBackoff = wait.Backoff{
+	Steps:    10,
+	Duration: 50 * time.Millisecond,
+	Factor:   1.5,
+	Jitter:   0.5,
+}
+
+func NewKubernetesCoordinator(clientset kubernetes.Interface, config *KubernetesCoordinatorConfig) *KubernetesCoordinator {
+	return &KubernetesCoordinator{
+		clientset: clientset,
+		config:    *config,
+	}
+}
+
+// Acquire waits for a drain slot for the node. Waiting nodes are served in
+// order of their deadline, so the node that terminates first drains first.
+// After MaxWait the slot is taken even if none is free.
+func (c *KubernetesCoordinator) Acquire(ctx context.Context, nodeName string) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(kubernetesCoordinationSlotTimeout)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, c.config.MaxWait)
+	defer cancel()
+
+	ticker := time.NewTicker(kubernetesCoordinationPollInterval)
+	defer ticker.Stop()
+
+	noticed := false
+	lastPosition := -1
+	for {
+		acquired := false
+		position := 0
+		force := waitCtx.Err() != nil
+
+		err := c.update(ctx, func(state *kubernetesCoordinationState, now time.Time) bool {
+			changed := false
+
+			if !noticed {
+				if now.Sub(state.Stats.UpdatedAt) > c.config.Window {
+					state.Stats = KubernetesInterruptionStats{StartedAt: now}
+				}
+				state.Stats.NodesNoticed++
+				state.Stats.UpdatedAt = now
+				changed = true
+			}
+
+			if _, holding := state.Holders[nodeName]; holding {
+				acquired = true
+				return changed
+			}
+
+			entry, queued := state.Queue[nodeName]
+			if !queued {
+				entry = kubernetesCoordinationEntry{Since: now, Deadline: deadline}
+				state.Queue[nodeName] = entry
+				changed = true
+			}
+
+			position = state.position(nodeName)
+			if force || position < c.config.MaxConcurrent-len(state.Holders) {
+				delete(state.Queue, nodeName)
+				state.Holders[nodeName] = kubernetesCoordinationEntry{Since: now, Deadline: deadline}
+				acquired = true
+				changed = true
+			}
+
+			return changed
+		})
+		if err != nil {
+			return err
+		}
+		noticed = true
+
+		if acquired {
+			if force {
+				c.config.Logger.Warn("no drain slot freed up in time, draining anyway", "node", nodeName, "max_wait", c.config.MaxWait.String())
+			}
+			return nil
+		}
+
+		if position != lastPosition {
+			c.config.Logger.Info("waiting for a drain slot", "node", nodeName, "position", position+1, "max_concurrent_drains", c.config.MaxConcurrent)
+			lastPosition = position
+		}
+
+		select {
+		case <-ticker.C:
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				c.leave(nodeName)
+				return fmt.Errorf("context done while waiting for a drain slot: %w", ctx.Err())
+			}
+		}
+	}
+}
+
+// Release frees the drain slot of the node and records the drain result,
+// returning the statistics of the interruption so far
+func (c *KubernetesCoordinator) Release(ctx context.Context, nodeName string, evicted, failed int, drainErr error) (KubernetesInterruptionStats, error) {
+	// the slot is released even when the handling was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	var stats KubernetesInterruptionStats
+	err := c.update(ctx, func(state *kubernetesCoordinationState, now time.Time) bool {
+		delete(state.Holders, nodeName)
+		delete(state.Queue, nodeName)
+
+		if drainErr != nil {
+			state.Stats.NodesFailed++
+		} else {
+			state.Stats.NodesDrained++
+		}
+		state.Stats.PodsEvicted += evicted
+		state.Stats.PodsFailed += failed
+		state.Stats.UpdatedAt = now
+
+		stats = state.Stats
+		return true
+	})
+
+	return stats, err
+}
+
+// leave removes the node from the queue when it stops waiting
+func (c *KubernetesCoordinator) leave(nodeName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := c.update(ctx, func(state *kubernetesCoordinationState, now time.Time) bool {
+		if _, queued := state.Queue[nodeName]; !queued {
+			return false
+		}
+		delete(state.Queue, nodeName)
+		return true
+	})
+	if err != nil {
+		c.config.Logger.Warn("failed to leave the drain queue", "node", nodeName, "error", err.Error())
+	}
+}
+
+// update applies fn to the shared state and writes it back when fn reports a
+// change, retrying on conflicts with other evacuators
+func (c *KubernetesCoordinator) update(ctx context.Context, fn func(state *kubernetesCoordinationState, now time.Time) bool) error {
+	retriable := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+
+	return retry.OnError(kubernetesCoordinationBackoff, retriable, func() error {
+		configMaps := c.clientset.CoreV1().ConfigMaps(c.config.Namespace)
+
+		configMap, err := configMaps.Get(ctx, c.config.ConfigMap, v1.GetOptions{})
+		exists := err == nil
+		if apierrors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      c.config.ConfigMap,
+					Namespace: c.config.Namespace,
+				},
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to get coordination config map: %w", err)
+		}
+
+		state, err := decodeKubernetesCoordinationState(configMap)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		pruned := state.prune(now)
+		if !fn(state, now) && !pruned && exists {
+			return nil
+		}
+
+		if err := state.encode(configMap); err != nil {
+			return err
+		}
+
+		if exists {
+			_, err = configMaps.Update(ctx, configMap, v1.UpdateOptions{})
+		} else {
+			_, err = configMaps.Create(ctx, configMap, v1.CreateOptions{})
+		}
+		return err
+	})
+}
+
+func decodeKubernetesCoordinationState(configMap *corev1.ConfigMap) (*kubernetesCoordinationState, error) {
+	state := &kubernetesCoordinationState{
+		Holders: make(map[string]kubernetesCoordinationEntry),
+		Queue:   make(map[string]kubernetesCoordinationEntry),
+	}
+
+	for key, value := range map[string]any{
+		kubernetesCoordinationHoldersKey: &state.Holders,
+		kubernetesCoordinationQueueKey:   &state.Queue,
+		kubernetesCoordinationStatsKey:   &state.Stats,
+	} {
+		raw, ok := configMap.Data[key]
+		if !ok || raw == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(raw), value); err != nil {
+			return nil, fmt.Errorf("invalid %s in coordination config map: %w", key, err)
+		}
+	}
+
+	return state, nil
+}
+
+func (s *kubernetesCoordinationState) encode(configMap *corev1.ConfigMap) error {
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+
+	for key, value := range map[string]any{
+		kubernetesCoordinationHoldersKey: s.Holders,
+		kubernetesCoordinationQueueKey:   s.Queue,
+		kubernetesCoordinationStatsKey:   s.Stats,
+	} {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		configMap.Data[key] = string(raw)
+	}
+
+	return nil
+}
+
+// prune drops the entries of evacuators that are past their deadline
+func (s *kubernetesCoordinationState) prune(now time.Time) bool {
+	pruned := false
+	for _, entries := range []map[string]kubernetesCoordinationEntry{s.Holders, s.Queue} {
+		for name, entry := range entries {
+			if now.After(entry.Deadline) {
+				delete(entries, name)
+				pruned = true
+			}
+		}
+	}
+	return pruned
+}
+
+// position returns the place of the node in the queue, earliest deadline first
+func (s *kubernetesCoordinationState) position(nodeName string) int {
+	names := make([]string, 0, len(s.Queue))
+	for name := range s.Queue {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		a, b := s.Queue[names[i]], s.Queue[names[j]]
+		if !a.Deadline.Equal(b.Deadline) {
+			return a.Deadline.Before(b.Deadline)
+		}
+		if !a.Since.Equal(b.Since) {
+			return a.Since.Before(b.Since)
+		}
+		return names[i] < names[j]
+	})
+
+	for i, name := range names {
+		if name == nodeName {
+			return i
+		}
+	}
+	return len(names)
+}
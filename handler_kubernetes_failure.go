@@ -0,0 +1,85 @@
+package evacuator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// KubernetesDrainFailAny fails the drain when any pod failed to evict
+	KubernetesDrainFailAny = "any"
+
+	// KubernetesDrainFailThreshold fails the drain when more than the threshold
+	// percentage of pods failed to evict
+	KubernetesDrainFailThreshold = "threshold"
+
+	// KubernetesDrainFailCritical fails the drain when a pod of a critical
+	// namespace or matching the critical selector failed to evict
+	KubernetesDrainFailCritical = "critical"
+
+	// KubernetesDrainFailNever never fails the drain, failures are only reported
+	KubernetesDrainFailNever = "never"
+)
+
+// PodEvictionFailure is a pod a drain failed to evict
+type PodEvictionFailure struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Reason    string `json:"reason"`
+	Critical  bool   `json:"critical,omitempty"`
+}
+
+// DrainError is returned when the drain failure policy fails a drain, it
+// lists every pod that failed to evict and why
+type DrainError struct {
+	Node   string               `json:"node"`
+	Policy string               `json:"policy"`
+	Total  int                  `json:"total"`
+	Failed []PodEvictionFailure `json:"failed"`
+}
+
+func (e *DrainError) Error() string {
+	pods := make([]string, 0, len(e.Failed))
+	for _, failure := range e.Failed {
+		pods = append(pods, fmt.Sprintf("%s/%s: %s", failure.Namespace, failure.Name, failure.Reason))
+	}
+	return fmt.Sprintf("failed to evict %d of %d pods on node %s (policy %s): %s", len(e.Failed), e.Total, e.Node, e.Policy, strings.Join(pods, "; "))
+}
+
+// podEvictionFailure records why the pod failed to evict
+func (h *KubernetesHandler) podEvictionFailure(pod *corev1.Pod, err error) PodEvictionFailure {
+	return PodEvictionFailure{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Reason:    err.Error(),
+		Critical:  h.isCriticalPod(pod),
+	}
+}
+
+// isCriticalPod checks if the pod is in a critical namespace or matches the critical selector
+func (h *KubernetesHandler) isCriticalPod(pod *corev1.Pod) bool {
+	if slices.Contains(h.config.DrainFailureCriticalNamespaces, pod.Namespace) {
+		return true
+	}
+	return h.criticalSelector != nil && h.criticalSelector.Matches(labels.Set(pod.Labels))
+}
+
+// drainFailed applies the drain failure policy to the failed pods
+func (h *KubernetesHandler) drainFailed(err *DrainError) bool {
+	switch err.Policy {
+	case KubernetesDrainFailAny:
+		return len(err.Failed) > 0
+	case KubernetesDrainFailCritical:
+		return slices.ContainsFunc(err.Failed, func(failure PodEvictionFailure) bool {
+			return failure.Critical
+		})
+	case KubernetesDrainFailNever:
+		return false
+	default:
+		return len(err.Failed)*100 > h.config.DrainFailureThreshold*err.Total
+	}
+}
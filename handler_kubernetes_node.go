@@ -0,0 +1,99 @@
+package evacuator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// KubernetesNodeMatchHostname matched the event hostname as node name
+	KubernetesNodeMatchHostname = "hostname"
+
+	// KubernetesNodeMatchProviderID matched the instance ID in spec.providerID
+	KubernetesNodeMatchProviderID = "provider_id"
+
+	// KubernetesNodeMatchPrivateIP matched the private IP in status.addresses
+	KubernetesNodeMatchPrivateIP = "private_ip"
+
+	// KubernetesNodeMatchNodeName matched the configured node name, usually
+	// NODE_NAME from the downward API
+	KubernetesNodeMatchNodeName = "node_name"
+)
+
+// resolveNode finds the node of the terminating instance. The metadata
+// hostname is often not the node name, e.g. ip-10-0-0-1.ec2.internal on AWS,
+// so the instance ID, the private IP and the configured node name are tried
+// after it.
+func (h *KubernetesHandler) resolveNode(ctx context.Context, event TerminationEvent) (*corev1.Node, error) {
+	if event.Hostname != "" {
+		node, err := h.RestConfig.CoreV1().Nodes().Get(ctx, event.Hostname, v1.GetOptions{})
+		if err == nil {
+			h.logNodeMatch(node, KubernetesNodeMatchHostname, event.Hostname)
+			return node, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	if event.InstanceID != "" || event.PrivateIP != "" {
+		nodes, err := h.RestConfig.CoreV1().Nodes().List(ctx, v1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		if event.InstanceID != "" {
+			for i := range nodes.Items {
+				if providerIDMatches(nodes.Items[i].Spec.ProviderID, event.InstanceID) {
+					h.logNodeMatch(&nodes.Items[i], KubernetesNodeMatchProviderID, event.InstanceID)
+					return &nodes.Items[i], nil
+				}
+			}
+		}
+
+		if event.PrivateIP != "" {
+			for i := range nodes.Items {
+				for _, address := range nodes.Items[i].Status.Addresses {
+					if address.Type == corev1.NodeInternalIP && address.Address == event.PrivateIP {
+						h.logNodeMatch(&nodes.Items[i], KubernetesNodeMatchPrivateIP, event.PrivateIP)
+						return &nodes.Items[i], nil
+					}
+				}
+			}
+		}
+	}
+
+	if h.config.NodeName != "" && h.config.NodeName != event.Hostname {
+		node, err := h.RestConfig.CoreV1().Nodes().Get(ctx, h.config.NodeName, v1.GetOptions{})
+		if err == nil {
+			h.logNodeMatch(node, KubernetesNodeMatchNodeName, h.config.NodeName)
+			return node, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("no node matches hostname %q, instance id %q or private ip %q", event.Hostname, event.InstanceID, event.PrivateIP)
+}
+
+func (h *KubernetesHandler) logNodeMatch(node *corev1.Node, strategy string, value string) {
+	h.config.Logger.Info("kubernetes node resolved", "node", node.Name, "strategy", strategy, "value", value, "handler", h.Name())
+}
+
+// providerIDMatches checks if the provider ID of a node refers to the
+// instance, e.g. aws:///us-east-1a/i-0abc, qcloud:///100003/ins-abc or
+// cn-hangzhou.i-abc on AliCloud
+func providerIDMatches(providerID string, instanceID string) bool {
+	if providerID == "" {
+		return false
+	}
+	return providerID == instanceID ||
+		strings.HasSuffix(providerID, "/"+instanceID) ||
+		strings.HasSuffix(providerID, "."+instanceID)
+}
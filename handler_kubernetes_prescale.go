@@ -0,0 +1,229 @@
+package evacuator
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// KubernetesPlaceholderLabel marks the placeholder pods with the node they replace
+	KubernetesPlaceholderLabel = "evacuator.io/placeholder-for"
+
+	// KubernetesPlaceholderImage is the default image of the placeholder pods
+	KubernetesPlaceholderImage = "registry.k8s.io/pause:3.10"
+)
+
+// karpenterNodeClaimResource is the Karpenter v1 NodeClaim API
+var karpenterNodeClaimResource = schema.GroupVersionResource{
+	Group:    "karpenter.sh",
+	Version:  "v1",
+	Resource: "nodeclaims",
+}
+
+// prescale asks the autoscaler for replacement capacity before the drain, so
+// new nodes boot while the pods are being evicted instead of after
+func (h *KubernetesHandler) prescale(ctx context.Context, nodeName string, event TerminationEvent) {
+	if h.config.PrescaleKarpenter {
+		if event.Reason != TerminationReasonSpot {
+			// a deleted NodeClaim cannot be brought back, maintenance and
+			// rebalance notices may still be withdrawn
+			h.config.Logger.Info("karpenter nodeclaim kept, the notice is not final", "node", nodeName, "reason", event.Reason, "handler", h.Name())
+		} else if err := h.deleteKarpenterNodeClaim(ctx, nodeName); err != nil {
+			h.config.Logger.Warn("failed to hand the node over to karpenter", "node", nodeName, "error", err.Error(), "handler", h.Name())
+		}
+	}
+
+	if h.config.PrescalePlaceholderEnabled {
+		if err := h.createPlaceholders(ctx, nodeName); err != nil {
+			h.config.Logger.Warn("failed to create placeholder pods", "node", nodeName, "error", err.Error(), "handler", h.Name())
+		}
+	}
+}
+
+// deleteKarpenterNodeClaim deletes the NodeClaim of the node. Karpenter
+// provisions capacity for the pods of nodes being deleted right away, and
+// drains the node itself in parallel with this handler.
+func (h *KubernetesHandler) deleteKarpenterNodeClaim(ctx context.Context, nodeName string) error {
+	node, err := h.RestConfig.CoreV1().Nodes().Get(ctx, nodeName, v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	nodeClaims, err := h.dynamicClient.Resource(karpenterNodeClaimResource).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list karpenter nodeclaims: %w", err)
+	}
+
+	var nodeClaim *unstructured.Unstructured
+	for i := range nodeClaims.Items {
+		item := &nodeClaims.Items[i]
+		claimNode, _, _ := unstructured.NestedString(item.Object, "status", "nodeName")
+		providerID, _, _ := unstructured.NestedString(item.Object, "status", "providerID")
+		if claimNode == nodeName || (providerID != "" && providerID == node.Spec.ProviderID) {
+			nodeClaim = item
+			break
+		}
+	}
+
+	if nodeClaim == nil {
+		h.config.Logger.Info("node is not managed by karpenter", "node", nodeName, "handler", h.Name())
+		return nil
+	}
+
+	options := v1.DeleteOptions{}
+	if h.config.DryRun {
+		options.DryRun = []string{v1.DryRunAll}
+	}
+
+	err = h.dynamicClient.Resource(karpenterNodeClaimResource).Delete(ctx, nodeClaim.GetName(), options)
+	if err != nil {
+		return fmt.Errorf("failed to delete karpenter nodeclaim %s: %w", nodeClaim.GetName(), err)
+	}
+
+	if h.config.DryRun {
+		h.config.Logger.Info("karpenter nodeclaim would be deleted", "node", nodeName, "nodeclaim", nodeClaim.GetName(), "dry_run", true, "handler", h.Name())
+	} else {
+		h.config.Logger.Info("karpenter nodeclaim deleted, replacement capacity requested", "node", nodeName, "nodeclaim", nodeClaim.GetName(), "handler", h.Name())
+	}
+
+	return nil
+}
+
+// createPlaceholders creates a low priority pause pod per pod about to be
+// evicted, with the same requests and scheduling constraints. They stay
+// pending until the autoscaler adds a node, and are preempted by the evicted
+// pods once it is ready.
+func (h *KubernetesHandler) createPlaceholders(ctx context.Context, nodeName string) error {
+	podsToEvict, _, err := h.listPodsToEvict(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	options := v1.CreateOptions{}
+	if h.config.DryRun {
+		options.DryRun = []string{v1.DryRunAll}
+	}
+
+	created := 0
+	for i := range podsToEvict {
+		placeholder := h.placeholderFor(&podsToEvict[i], nodeName)
+
+		// a pod without requests needs no capacity
+		if len(placeholder.Spec.Containers[0].Resources.Requests) == 0 {
+			continue
+		}
+
+		_, err := h.RestConfig.CoreV1().Pods(placeholder.Namespace).Create(ctx, placeholder, options)
+		if err != nil {
+			return fmt.Errorf("failed to create placeholder for pod %s/%s: %w", podsToEvict[i].Namespace, podsToEvict[i].Name, err)
+		}
+		created++
+	}
+
+	if h.config.DryRun {
+		h.config.Logger.Info("placeholder pods would be created", "node", nodeName, "count", created, "namespace", h.config.PrescalePlaceholderNamespace, "dry_run", true, "handler", h.Name())
+	} else {
+		h.config.Logger.Info("placeholder pods created", "node", nodeName, "count", created, "namespace", h.config.PrescalePlaceholderNamespace, "handler", h.Name())
+	}
+
+	return nil
+}
+
+// deletePlaceholders removes the placeholders of the node, once the drain is
+// done the evicted pods themselves keep the autoscaler scaling up
+func (h *KubernetesHandler) deletePlaceholders(ctx context.Context, nodeName string) {
+	if h.config.DryRun {
+		return
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{KubernetesPlaceholderLabel: nodeName}).String()
+	gracePeriod := int64(0)
+
+	err := h.RestConfig.CoreV1().Pods(h.config.PrescalePlaceholderNamespace).DeleteCollection(ctx,
+		v1.DeleteOptions{GracePeriodSeconds: &gracePeriod},
+		v1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		h.config.Logger.Warn("failed to delete placeholder pods", "node", nodeName, "error", err.Error(), "handler", h.Name())
+		return
+	}
+
+	h.config.Logger.Info("placeholder pods deleted", "node", nodeName, "handler", h.Name())
+}
+
+// placeholderFor builds the placeholder pod standing in for pod
+func (h *KubernetesHandler) placeholderFor(pod *corev1.Pod, nodeName string) *corev1.Pod {
+	requests := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			total := requests[name]
+			total.Add(quantity)
+			requests[name] = total
+		}
+	}
+
+	// init containers run before the others, the pod needs the largest of both
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if total, ok := requests[name]; !ok || quantity.Cmp(total) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+
+	// only node affinity applies, pod affinity refers to the labels of the
+	// original pod, which the placeholder does not have
+	var affinity *corev1.Affinity
+	if pod.Spec.Affinity != nil && pod.Spec.Affinity.NodeAffinity != nil {
+		affinity = &corev1.Affinity{NodeAffinity: pod.Spec.Affinity.NodeAffinity.DeepCopy()}
+	}
+
+	// a toleration without key tolerates the cordon too, the placeholder would
+	// be scheduled on the terminating node
+	var tolerations []corev1.Toleration
+	for _, toleration := range pod.Spec.Tolerations {
+		if toleration.Key != "" {
+			tolerations = append(tolerations, toleration)
+		}
+	}
+
+	preemptionPolicy := corev1.PreemptNever
+	gracePeriod := int64(0)
+	automount := false
+
+	return &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			GenerateName: "evacuator-placeholder-",
+			Namespace:    h.config.PrescalePlaceholderNamespace,
+			Labels: map[string]string{
+				KubernetesPlaceholderLabel: nodeName,
+			},
+			Annotations: map[string]string{
+				KubernetesPlaceholderLabel: pod.Namespace + "/" + pod.Name,
+			},
+		},
+		Spec: corev1.PodSpec{
+			PriorityClassName:             h.config.PrescalePlaceholderPriorityClass,
+			PreemptionPolicy:              &preemptionPolicy,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			AutomountServiceAccountToken:  &automount,
+			NodeSelector:                  pod.Spec.NodeSelector,
+			Affinity:                      affinity,
+			Tolerations:                   tolerations,
+			Containers: []corev1.Container{
+				{
+					Name:  "placeholder",
+					Image: h.config.PrescalePlaceholderImage,
+					Resources: corev1.ResourceRequirements{
+						Requests: requests,
+					},
+				},
+			},
+		},
+	}
+}
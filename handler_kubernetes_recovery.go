@@ -0,0 +1,246 @@
+package evacuator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// KubernetesCordonedAnnotation marks nodes cordoned by the handler, only
+	// those are uncordoned on recovery, a cordon by someone else is kept
+	KubernetesCordonedAnnotation = "evacuator.io/cordoned"
+
+	// KubernetesTerminationCondition is the node condition set while a
+	// termination notice is pending for the node
+	KubernetesTerminationCondition corev1.NodeConditionType = "TerminationNotice"
+
+	// KubernetesRecoveredEventReason is the reason of the Event recorded on
+	// recovered nodes
+	KubernetesRecoveredEventReason = "TerminationNoticeCleared"
+
+	// kubernetesEventComponent is the source component of recorded Events
+	kubernetesEventComponent = "evacuator"
+)
+
+// kubernetesCordonPatchFor marks the node as unschedulable, and as cordoned by
+// the handler unless it was already cordoned
+func kubernetesCordonPatchFor(node *corev1.Node) string {
+	if node.Spec.Unschedulable {
+		return kubernetesCordonPatch
+	}
+	return fmt.Sprintf(`{"spec":{"unschedulable":true},"metadata":{"annotations":{%q:%q}}}`,
+		KubernetesCordonedAnnotation, time.Now().UTC().Format(time.RFC3339))
+}
+
+// setTerminationCondition shows the pending notice in the node status, e.g.
+// in kubectl describe node
+func (h *KubernetesHandler) setTerminationCondition(ctx context.Context, nodeName string, event TerminationEvent) error {
+	message := "termination notice received"
+	if !event.Deadline.IsZero() {
+		message += ", instance terminates at " + event.Deadline.UTC().Format(time.RFC3339)
+	}
+
+	now := v1.Now()
+	condition := corev1.NodeCondition{
+		Type:               KubernetesTerminationCondition,
+		Status:             corev1.ConditionTrue,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             kubernetesConditionReason(event.Reason),
+		Message:            message,
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"status": map[string]any{
+			"conditions": []corev1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = h.RestConfig.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, patch, h.patchOptions(), "status")
+	if err != nil {
+		return err
+	}
+
+	if h.config.DryRun {
+		h.config.Logger.Info("kubernetes node condition would be set", "node", nodeName, "condition", string(KubernetesTerminationCondition), "dry_run", true, "handler", h.Name())
+	} else {
+		h.config.Logger.Info("kubernetes node condition set", "node", nodeName, "condition", string(KubernetesTerminationCondition), "handler", h.Name())
+	}
+
+	return nil
+}
+
+// HandleCancellation recovers the node of an instance that was not terminated
+// after all, undoing the cordon, the taint and the condition of the notice
+func (h *KubernetesHandler) HandleCancellation(ctx context.Context, event TerminationEvent) error {
+	h.config.Logger.Info("recovering kubernetes node", "node", event.Hostname, "handler", h.Name())
+
+	node, err := h.resolveNode(ctx, event)
+	if err != nil {
+		return fmt.Errorf("failed to get kubernetes node: %s", err)
+	}
+	nodeName := node.Name
+
+	if _, cordoned := node.Annotations[KubernetesCordonedAnnotation]; cordoned {
+		patch := fmt.Sprintf(`{"spec":{"unschedulable":false},"metadata":{"annotations":{%q:null}}}`, KubernetesCordonedAnnotation)
+		_, err := h.RestConfig.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, []byte(patch), h.patchOptions())
+		if err != nil {
+			return fmt.Errorf("failed to uncordon kubernetes node: %s", err)
+		}
+
+		if h.config.DryRun {
+			h.config.Logger.Info("kubernetes node would be uncordoned", "node", nodeName, "dry_run", true, "handler", h.Name())
+		} else {
+			h.config.Logger.Info("kubernetes node successfully uncordoned", "node", nodeName, "handler", h.Name())
+		}
+	} else if node.Spec.Unschedulable {
+		h.config.Logger.Info("kubernetes node was cordoned before the notice, leaving it cordoned", "node", nodeName, "handler", h.Name())
+	}
+
+	if h.config.Taint != "" {
+		if err := h.untaintNode(ctx, nodeName); err != nil {
+			return fmt.Errorf("failed to untaint kubernetes node: %s", err)
+		}
+	}
+
+	// condition and Event are informational, missing RBAC for them must not
+	// keep the node from recovering
+	if err := h.removeTerminationCondition(ctx, node); err != nil {
+		h.config.Logger.Warn("failed to remove kubernetes node condition", "node", nodeName, "error", err.Error(), "handler", h.Name())
+	}
+
+	message := "termination notice cleared, node recovered"
+	if err := h.recordNodeEvent(ctx, node, KubernetesRecoveredEventReason, message); err != nil {
+		h.config.Logger.Warn("failed to record kubernetes event", "node", nodeName, "error", err.Error(), "handler", h.Name())
+	}
+
+	h.config.Logger.Info("kubernetes node recovery completed successfully", "node", nodeName, "handler", h.Name())
+	return nil
+}
+
+// untaintNode removes the NoSchedule taint added by taintNode
+func (h *KubernetesHandler) untaintNode(ctx context.Context, nodeName string) error {
+	untainted := false
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := h.RestConfig.CoreV1().Nodes().Get(ctx, nodeName, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		taints := make([]corev1.Taint, 0, len(node.Spec.Taints))
+		for _, taint := range node.Spec.Taints {
+			if taint.Key != h.config.Taint || taint.Effect != corev1.TaintEffectNoSchedule {
+				taints = append(taints, taint)
+			}
+		}
+		if len(taints) == len(node.Spec.Taints) {
+			return nil
+		}
+		node.Spec.Taints = taints
+
+		_, err = h.RestConfig.CoreV1().Nodes().Update(ctx, node, h.updateOptions())
+		if err == nil {
+			untainted = true
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !untainted:
+		h.config.Logger.Info("kubernetes node not tainted", "node", nodeName, "taint", h.config.Taint, "handler", h.Name())
+	case h.config.DryRun:
+		h.config.Logger.Info("kubernetes node taint would be removed", "node", nodeName, "taint", h.config.Taint+":NoSchedule", "dry_run", true, "handler", h.Name())
+	default:
+		h.config.Logger.Info("kubernetes node taint removed", "node", nodeName, "taint", h.config.Taint+":NoSchedule", "handler", h.Name())
+	}
+
+	return nil
+}
+
+// removeTerminationCondition deletes the condition set by setTerminationCondition
+func (h *KubernetesHandler) removeTerminationCondition(ctx context.Context, node *corev1.Node) error {
+	found := false
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == KubernetesTerminationCondition {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	patch := fmt.Sprintf(`{"status":{"conditions":[{"$patch":"delete","type":%q}]}}`, KubernetesTerminationCondition)
+	_, err := h.RestConfig.CoreV1().Nodes().Patch(ctx, node.Name, types.StrategicMergePatchType, []byte(patch), h.patchOptions(), "status")
+	if err != nil {
+		return err
+	}
+
+	if h.config.DryRun {
+		h.config.Logger.Info("kubernetes node condition would be removed", "node", node.Name, "condition", string(KubernetesTerminationCondition), "dry_run", true, "handler", h.Name())
+	} else {
+		h.config.Logger.Info("kubernetes node condition removed", "node", node.Name, "condition", string(KubernetesTerminationCondition), "handler", h.Name())
+	}
+
+	return nil
+}
+
+// recordNodeEvent records a Normal Event on the node, Events of cluster
+// scoped objects live in the default namespace
+func (h *KubernetesHandler) recordNodeEvent(ctx context.Context, node *corev1.Node, reason string, message string) error {
+	now := v1.Now()
+	event := &corev1.Event{
+		ObjectMeta: v1.ObjectMeta{
+			GenerateName: node.Name + ".",
+			Namespace:    v1.NamespaceDefault,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       node.Name,
+			UID:        node.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeNormal,
+		Source:         corev1.EventSource{Component: kubernetesEventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	options := v1.CreateOptions{}
+	if h.config.DryRun {
+		options.DryRun = []string{v1.DryRunAll}
+	}
+
+	_, err := h.RestConfig.CoreV1().Events(v1.NamespaceDefault).Create(ctx, event, options)
+	return err
+}
+
+// kubernetesConditionReason converts the termination reason to a CamelCase
+// condition reason, e.g. SpotTermination
+func kubernetesConditionReason(reason TerminationReason) string {
+	switch reason {
+	case TerminationReasonMaintenance:
+		return "MaintenanceTermination"
+	case TerminationReasonRebalance:
+		return "RebalanceRecommendation"
+	default:
+		return "SpotTermination"
+	}
+}
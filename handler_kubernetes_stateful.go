@@ -0,0 +1,237 @@
+package evacuator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kubernetesVolumePollInterval is the delay between checks of the volume attachments
+const kubernetesVolumePollInterval = time.Second
+
+// splitStatefulSetPods separates the pods owned by a StatefulSet from the others
+func (h *KubernetesHandler) splitStatefulSetPods(pods []corev1.Pod) ([]corev1.Pod, []corev1.Pod) {
+	var stateless, stateful []corev1.Pod
+	for _, pod := range pods {
+		if statefulSetName(&pod) != "" {
+			stateful = append(stateful, pod)
+		} else {
+			stateless = append(stateless, pod)
+		}
+	}
+	return stateless, stateful
+}
+
+// evictStatefulSetPods evicts the pods of different StatefulSets in parallel,
+// but the pods of one StatefulSet one at a time, highest ordinal first like a
+// scale down. Each pod must be gone before the next one is evicted, so a set
+// never loses two members on this node at once.
+func (h *KubernetesHandler) evictStatefulSetPods(ctx context.Context, pods []corev1.Pod, nodeName string) (int, []PodEvictionFailure) {
+	sets := make(map[string][]corev1.Pod)
+	for _, pod := range pods {
+		key := pod.Namespace + "/" + statefulSetName(&pod)
+		sets[key] = append(sets[key], pod)
+	}
+
+	h.config.Logger.Info("starting statefulset pod eviction", "node", nodeName, "pod_count", len(pods), "statefulsets", len(sets), "handler", h.Name())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failures []PodEvictionFailure
+	successCount := 0
+
+	for set, setPods := range sets {
+		sort.Slice(setPods, func(i, j int) bool {
+			return statefulSetOrdinal(&setPods[i]) > statefulSetOrdinal(&setPods[j])
+		})
+
+		wg.Add(1)
+		go func(set string, setPods []corev1.Pod) {
+			defer wg.Done()
+
+			for i, pod := range setPods {
+				err := h.evictPod(ctx, &pod)
+				if err == nil && !h.config.DryRun {
+					err = h.waitForPodDeletion(ctx, &pod)
+				}
+
+				mu.Lock()
+				if err != nil {
+					failures = append(failures, h.podEvictionFailure(&pod, err))
+				} else {
+					successCount++
+				}
+
+				// the pods never attempted still count, the failure policy
+				// must see critical ones left on the node
+				if ctx.Err() != nil {
+					for j := i + 1; j < len(setPods); j++ {
+						failures = append(failures, h.podEvictionFailure(&setPods[j], fmt.Errorf("not evicted: %w", ctx.Err())))
+					}
+					mu.Unlock()
+					return
+				}
+				mu.Unlock()
+			}
+
+			h.config.Logger.Debug("statefulset pods evicted", "statefulset", set, "node", nodeName, "handler", h.Name())
+		}(set, setPods)
+	}
+
+	wg.Wait()
+
+	h.config.Logger.Info("statefulset pod eviction completed",
+		"node", nodeName,
+		"total_pods", len(pods),
+		"successful_evictions", successCount,
+		"failed_evictions", len(failures),
+		"handler", h.Name())
+
+	return successCount, failures
+}
+
+// waitForPodDeletion waits until the pod is gone, however long its graceful
+// shutdown takes within the handler deadline
+func (h *KubernetesHandler) waitForPodDeletion(ctx context.Context, pod *corev1.Pod) error {
+	deleted, stop := h.podDeletion(ctx, pod)
+	defer stop()
+
+	select {
+	case <-deleted:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("pod still terminating: %w", ctx.Err())
+	}
+}
+
+// waitForVolumeDetach waits for the persistent volumes of the evicted pods to
+// detach from the node. A volume still attached when the instance dies keeps
+// the rescheduled pods failing with Multi-Attach errors for minutes.
+func (h *KubernetesHandler) waitForVolumeDetach(ctx context.Context, nodeName string, volumes map[string]bool) {
+	if len(volumes) == 0 {
+		return
+	}
+
+	if h.config.DryRun {
+		h.config.Logger.Info("volumes would be waited for to detach", "node", nodeName, "volumes", len(volumes), "dry_run", true, "handler", h.Name())
+		return
+	}
+
+	h.config.Logger.Info("waiting for volumes to detach", "node", nodeName, "volumes", len(volumes), "timeout", h.config.VolumeDetachTimeout.String(), "handler", h.Name())
+
+	ctx, cancel := context.WithTimeout(ctx, h.config.VolumeDetachTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(kubernetesVolumePollInterval)
+	defer ticker.Stop()
+
+	for {
+		attached, err := h.attachedVolumes(ctx, nodeName, volumes)
+		if err == nil && len(attached) == 0 {
+			h.config.Logger.Info("volumes detached", "node", nodeName, "volumes", len(volumes), "handler", h.Name())
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err != nil {
+				h.config.Logger.Warn("failed to check volume attachments", "node", nodeName, "error", err.Error(), "handler", h.Name())
+				return
+			}
+			h.config.Logger.Warn("volumes still attached",
+				"node", nodeName,
+				"volumes", strings.Join(attached, ","),
+				"handler", h.Name())
+			return
+		}
+	}
+}
+
+// persistentVolumes returns the names of the persistent volumes bound to the
+// claims of the pods, including generic ephemeral volumes. It runs before the
+// eviction, as ephemeral claims are deleted with their pod.
+func (h *KubernetesHandler) persistentVolumes(ctx context.Context, pods []corev1.Pod) (map[string]bool, error) {
+	volumes := make(map[string]bool)
+
+	for _, pod := range pods {
+		for _, volume := range pod.Spec.Volumes {
+			var claimName string
+			switch {
+			case volume.PersistentVolumeClaim != nil:
+				claimName = volume.PersistentVolumeClaim.ClaimName
+			case volume.Ephemeral != nil:
+				claimName = pod.Name + "-" + volume.Name
+			default:
+				continue
+			}
+
+			claim, err := h.RestConfig.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(ctx, claimName, v1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get persistent volume claim %s/%s: %w", pod.Namespace, claimName, err)
+			}
+
+			if claim.Spec.VolumeName != "" {
+				volumes[claim.Spec.VolumeName] = true
+			}
+		}
+	}
+
+	return volumes, nil
+}
+
+// attachedVolumes returns the volumes that still have an attachment to the node
+func (h *KubernetesHandler) attachedVolumes(ctx context.Context, nodeName string, volumes map[string]bool) ([]string, error) {
+	attachments, err := h.RestConfig.StorageV1().VolumeAttachments().List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volume attachments: %w", err)
+	}
+
+	var attached []string
+	for _, attachment := range attachments.Items {
+		volume := attachment.Spec.Source.PersistentVolumeName
+		if attachment.Spec.NodeName != nodeName || volume == nil || !volumes[*volume] {
+			continue
+		}
+		if attachment.Status.Attached {
+			attached = append(attached, *volume)
+		}
+	}
+
+	sort.Strings(attached)
+	return attached, nil
+}
+
+// statefulSetName returns the StatefulSet owning the pod, empty if none
+func statefulSetName(pod *corev1.Pod) string {
+	for _, ownerRef := range pod.OwnerReferences {
+		if ownerRef.Kind == "StatefulSet" {
+			return ownerRef.Name
+		}
+	}
+	return ""
+}
+
+// statefulSetOrdinal returns the ordinal suffix of a StatefulSet pod name
+func statefulSetOrdinal(pod *corev1.Pod) int {
+	index := strings.LastIndex(pod.Name, "-")
+	if index < 0 {
+		return -1
+	}
+	ordinal, err := strconv.Atoi(pod.Name[index+1:])
+	if err != nil {
+		return -1
+	}
+	return ordinal
+}
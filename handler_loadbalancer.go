@@ -0,0 +1,381 @@
+package evacuator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing/types"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2Types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// LoadBalancerHandler removes the instance from cloud load balancers so no new
+// traffic is sent to it, then waits for connection draining within the
+// remaining processing budget.
+type LoadBalancerHandler struct {
+	elbv2Client *elbv2.Client
+	elbClient   *elb.Client
+	gcpClient   *http.Client
+	config      LoadBalancerHandlerConfig
+}
+
+type LoadBalancerHandlerConfig struct {
+	Logger       *slog.Logger
+	Name         string
+	DrainTimeout time.Duration
+
+	AwsEnabled              bool
+	AwsRegion               string
+	AwsEndpoint             string
+	AwsTargetGroupArns      []string
+	AwsClassicLoadBalancers []string
+	AwsAutoDiscover         bool
+
+	GcpEnabled          bool
+	GcpEndpoint         string
+	GcpMetadataEndpoint string
+	GcpProject          string
+	GcpZone             string
+	GcpInstanceName     string
+	GcpInstanceGroups   []string
+
+	// DryRun looks up the registrations without deregistering the instance
+	DryRun bool
+}
+
+// awsTarget is a target group registration of this instance
+type awsTarget struct {
+	targetGroupArn string
+	target         elbv2Types.TargetDescription
+}
+
+const (
+	// delay between load balancer draining checks
+	LoadBalancerDrainPollInterval = 2 * time.Second
+)
+
+func NewLoadBalancerHandler(config *LoadBalancerHandlerConfig) (*LoadBalancerHandler, error) {
+
+	if !config.AwsEnabled && !config.GcpEnabled {
+		return nil, fmt.Errorf("at least one of aws or gcp must be enabled")
+	}
+
+	h := &LoadBalancerHandler{
+		config: *config,
+	}
+
+	if config.AwsEnabled {
+		loadOptions := []func(*awsConfig.LoadOptions) error{}
+		if config.AwsRegion != "" {
+			loadOptions = append(loadOptions, awsConfig.WithRegion(config.AwsRegion))
+		} else {
+			loadOptions = append(loadOptions, awsConfig.WithEC2IMDSRegion())
+		}
+
+		cfg, err := awsConfig.LoadDefaultConfig(context.Background(), loadOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load aws config: %w", err)
+		}
+
+		h.elbv2Client = elbv2.NewFromConfig(cfg, func(o *elbv2.Options) {
+			if config.AwsEndpoint != "" {
+				o.BaseEndpoint = aws.String(config.AwsEndpoint)
+			}
+		})
+		h.elbClient = elb.NewFromConfig(cfg, func(o *elb.Options) {
+			if config.AwsEndpoint != "" {
+				o.BaseEndpoint = aws.String(config.AwsEndpoint)
+			}
+		})
+	}
+
+	if config.GcpEnabled {
+		h.gcpClient = &http.Client{}
+	}
+
+	return h, nil
+}
+
+func (h *LoadBalancerHandler) Name() string {
+	if h.config.Name != "" {
+		return h.config.Name
+	}
+	return string(HandlerNameLoadBalancer)
+}
+
+func (h *LoadBalancerHandler) HandleTermination(ctx context.Context, event TerminationEvent) error {
+	h.config.Logger.Info("handling load balancer deregistration", "node", event.Hostname, "instance_id", event.InstanceID, "handler", h.Name())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var deregistrationErrors []error
+
+	run := func(cloud string, fn func(context.Context, TerminationEvent) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(ctx, event); err != nil {
+				mu.Lock()
+				deregistrationErrors = append(deregistrationErrors, fmt.Errorf("%s: %w", cloud, err))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	if h.config.AwsEnabled {
+		run("aws", h.deregisterAws)
+	}
+	if h.config.GcpEnabled {
+		run("gcp", h.deregisterGcp)
+	}
+
+	wg.Wait()
+
+	if len(deregistrationErrors) > 0 {
+		return errors.Join(deregistrationErrors...)
+	}
+
+	h.config.Logger.Info("load balancer deregistration completed successfully", "node", event.Hostname, "handler", h.Name())
+	return nil
+}
+
+// drainBudget returns how long draining may be waited for, bounded by the
+// configured drain timeout and the handler deadline
+func (h *LoadBalancerHandler) drainBudget(ctx context.Context) time.Duration {
+	budget := h.config.DrainTimeout
+
+	if deadline, ok := ctx.Deadline(); ok {
+		// keep a second to report the result before the deadline
+		remaining := time.Until(deadline) - time.Second
+		if remaining < budget {
+			budget = remaining
+		}
+	}
+
+	return budget
+}
+
+func (h *LoadBalancerHandler) deregisterAws(ctx context.Context, event TerminationEvent) error {
+	if event.InstanceID == "" || event.InstanceID == "unknown" {
+		return fmt.Errorf("instance ID is required for aws deregistration")
+	}
+
+	// a failed lookup still leaves the registrations found elsewhere to
+	// deregister, its error is returned with the others at the end
+	var deregistrationErrors []error
+
+	targets, err := h.findAwsTargets(ctx, event)
+	if err != nil {
+		h.config.Logger.Error("failed to find some target group registrations", "error", err.Error(), "handler", h.Name())
+		deregistrationErrors = append(deregistrationErrors, err)
+	}
+
+	loadBalancers, err := h.findAwsClassicLoadBalancers(ctx, event)
+	if err != nil {
+		h.config.Logger.Error("failed to find classic load balancer registrations", "error", err.Error(), "handler", h.Name())
+		deregistrationErrors = append(deregistrationErrors, err)
+	}
+
+	h.config.Logger.Info("found aws load balancer registrations",
+		"instance_id", event.InstanceID,
+		"target_groups", len(targets),
+		"classic_load_balancers", len(loadBalancers),
+		"handler", h.Name())
+
+	if h.config.DryRun {
+		for _, t := range targets {
+			h.config.Logger.Info("target would be deregistered", "target_group", t.targetGroupArn, "target", aws.ToString(t.target.Id), "dry_run", true, "handler", h.Name())
+		}
+		for _, name := range loadBalancers {
+			h.config.Logger.Info("instance would be deregistered from classic load balancer", "load_balancer", name, "dry_run", true, "handler", h.Name())
+		}
+		return errors.Join(deregistrationErrors...)
+	}
+
+	var deregisteredTargets []awsTarget
+	var deregisteredLoadBalancers []string
+
+	for _, t := range targets {
+		_, err := h.elbv2Client.DeregisterTargets(ctx, &elbv2.DeregisterTargetsInput{
+			TargetGroupArn: aws.String(t.targetGroupArn),
+			Targets:        []elbv2Types.TargetDescription{t.target},
+		})
+		if err != nil {
+			h.config.Logger.Error("failed to deregister target", "target_group", t.targetGroupArn, "error", err.Error(), "handler", h.Name())
+			deregistrationErrors = append(deregistrationErrors, fmt.Errorf("target group %s: %w", t.targetGroupArn, err))
+			continue
+		}
+		h.config.Logger.Info("target deregistered", "target_group", t.targetGroupArn, "target", aws.ToString(t.target.Id), "handler", h.Name())
+		deregisteredTargets = append(deregisteredTargets, t)
+	}
+
+	for _, name := range loadBalancers {
+		_, err := h.elbClient.DeregisterInstancesFromLoadBalancer(ctx, &elb.DeregisterInstancesFromLoadBalancerInput{
+			LoadBalancerName: aws.String(name),
+			Instances:        []elbTypes.Instance{{InstanceId: aws.String(event.InstanceID)}},
+		})
+		if err != nil {
+			h.config.Logger.Error("failed to deregister instance from classic load balancer", "load_balancer", name, "error", err.Error(), "handler", h.Name())
+			deregistrationErrors = append(deregistrationErrors, fmt.Errorf("classic load balancer %s: %w", name, err))
+			continue
+		}
+		h.config.Logger.Info("instance deregistered from classic load balancer", "load_balancer", name, "handler", h.Name())
+		deregisteredLoadBalancers = append(deregisteredLoadBalancers, name)
+	}
+
+	h.waitAwsDrained(ctx, event, deregisteredTargets, deregisteredLoadBalancers)
+
+	return errors.Join(deregistrationErrors...)
+}
+
+// findAwsTargets returns the target group registrations of the instance, matched
+// by instance ID for instance targets and by private IP for ip targets. The
+// registrations found are returned with the errors of the target groups that
+// could not be looked up.
+func (h *LoadBalancerHandler) findAwsTargets(ctx context.Context, event TerminationEvent) ([]awsTarget, error) {
+	targetGroupArns := append([]string{}, h.config.AwsTargetGroupArns...)
+	var lookupErrors []error
+
+	if h.config.AwsAutoDiscover {
+		paginator := elbv2.NewDescribeTargetGroupsPaginator(h.elbv2Client, &elbv2.DescribeTargetGroupsInput{})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				lookupErrors = append(lookupErrors, fmt.Errorf("failed to describe target groups: %w", err))
+				break
+			}
+			for _, tg := range page.TargetGroups {
+				targetGroupArns = appendUnique(targetGroupArns, aws.ToString(tg.TargetGroupArn))
+			}
+		}
+	}
+
+	var targets []awsTarget
+	for _, arn := range targetGroupArns {
+		health, err := h.elbv2Client.DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{
+			TargetGroupArn: aws.String(arn),
+		})
+		if err != nil {
+			lookupErrors = append(lookupErrors, fmt.Errorf("failed to describe target health for %s: %w", arn, err))
+			continue
+		}
+
+		for _, d := range health.TargetHealthDescriptions {
+			if d.Target == nil {
+				continue
+			}
+			id := aws.ToString(d.Target.Id)
+			if id != event.InstanceID && id != event.PrivateIP {
+				continue
+			}
+			targets = append(targets, awsTarget{targetGroupArn: arn, target: *d.Target})
+		}
+	}
+
+	return targets, errors.Join(lookupErrors...)
+}
+
+// findAwsClassicLoadBalancers returns the classic load balancers the instance
+// is registered in, the configured ones even when the discovery fails
+func (h *LoadBalancerHandler) findAwsClassicLoadBalancers(ctx context.Context, event TerminationEvent) ([]string, error) {
+	loadBalancers := append([]string{}, h.config.AwsClassicLoadBalancers...)
+
+	if !h.config.AwsAutoDiscover {
+		return loadBalancers, nil
+	}
+
+	paginator := elb.NewDescribeLoadBalancersPaginator(h.elbClient, &elb.DescribeLoadBalancersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return loadBalancers, fmt.Errorf("failed to describe classic load balancers: %w", err)
+		}
+		for _, lb := range page.LoadBalancerDescriptions {
+			for _, instance := range lb.Instances {
+				if aws.ToString(instance.InstanceId) == event.InstanceID {
+					loadBalancers = appendUnique(loadBalancers, aws.ToString(lb.LoadBalancerName))
+				}
+			}
+		}
+	}
+
+	return loadBalancers, nil
+}
+
+// waitAwsDrained waits for every deregistration to finish connection draining.
+// Running out of budget is only logged, traffic is already stopped at this point.
+func (h *LoadBalancerHandler) waitAwsDrained(ctx context.Context, event TerminationEvent, targets []awsTarget, loadBalancers []string) {
+	if len(targets) == 0 && len(loadBalancers) == 0 {
+		return
+	}
+
+	budget := h.drainBudget(ctx)
+	if budget <= 0 {
+		h.config.Logger.Warn("no time left to wait for aws connection draining", "handler", h.Name())
+		return
+	}
+
+	h.config.Logger.Info("waiting for aws connection draining", "budget", budget.String(), "handler", h.Name())
+
+	var wg sync.WaitGroup
+
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t awsTarget) {
+			defer wg.Done()
+			waiter := elbv2.NewTargetDeregisteredWaiter(h.elbv2Client, func(o *elbv2.TargetDeregisteredWaiterOptions) {
+				o.MinDelay = LoadBalancerDrainPollInterval
+				o.MaxDelay = LoadBalancerDrainPollInterval
+			})
+			err := waiter.Wait(ctx, &elbv2.DescribeTargetHealthInput{
+				TargetGroupArn: aws.String(t.targetGroupArn),
+				Targets:        []elbv2Types.TargetDescription{t.target},
+			}, budget)
+			if err != nil {
+				h.config.Logger.Warn("target still draining", "target_group", t.targetGroupArn, "error", err.Error(), "handler", h.Name())
+				return
+			}
+			h.config.Logger.Info("target drained", "target_group", t.targetGroupArn, "handler", h.Name())
+		}(t)
+	}
+
+	for _, name := range loadBalancers {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			waiter := elb.NewInstanceDeregisteredWaiter(h.elbClient, func(o *elb.InstanceDeregisteredWaiterOptions) {
+				o.MinDelay = LoadBalancerDrainPollInterval
+				o.MaxDelay = LoadBalancerDrainPollInterval
+			})
+			err := waiter.Wait(ctx, &elb.DescribeInstanceHealthInput{
+				LoadBalancerName: aws.String(name),
+				Instances:        []elbTypes.Instance{{InstanceId: aws.String(event.InstanceID)}},
+			}, budget)
+			if err != nil {
+				h.config.Logger.Warn("classic load balancer still draining", "load_balancer", name, "error", err.Error(), "handler", h.Name())
+				return
+			}
+			h.config.Logger.Info("classic load balancer drained", "load_balancer", name, "handler", h.Name())
+		}(name)
+	}
+
+	wg.Wait()
+}
+
+// appendUnique appends value to values when it is not already present
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,266 @@
+package evacuator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LoadBalancerGcpComputeUrl  = "https://compute.googleapis.com/compute/v1"
+	LoadBalancerGcpMetadataUrl = "http://metadata.google.internal/computeMetadata/v1"
+
+	// gcp metadata endpoint, relative to the metadata url
+	LoadBalancerGcpMetadataProjectPath = "/project/project-id"
+	LoadBalancerGcpMetadataZonePath    = "/instance/zone"
+	LoadBalancerGcpMetadataNamePath    = "/instance/name"
+	LoadBalancerGcpMetadataTokenPath   = "/instance/service-accounts/default/token"
+)
+
+type gcpAccessToken struct {
+	AccessToken string `json:"access_token"`
+}
+
+type gcpOperation struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  *struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error"`
+}
+
+// gcpInstance identifies the instance in the compute API
+type gcpInstance struct {
+	project string
+	zone    string
+	name    string
+	token   string
+}
+
+// deregisterGcp removes the instance from the configured unmanaged instance groups.
+// Backend services using the groups drain connections before the removal completes.
+func (h *LoadBalancerHandler) deregisterGcp(ctx context.Context, event TerminationEvent) error {
+	instance, err := h.resolveGcpInstance(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.config.Logger.Info("removing instance from gcp instance groups",
+		"instance", instance.name,
+		"zone", instance.zone,
+		"instance_groups", len(h.config.GcpInstanceGroups),
+		"handler", h.Name())
+
+	if h.config.DryRun {
+		for _, group := range h.config.GcpInstanceGroups {
+			h.config.Logger.Info("instance would be removed from instance group", "instance_group", group, "dry_run", true, "handler", h.Name())
+		}
+		return nil
+	}
+
+	var deregistrationErrors []error
+	operations := make(map[string]gcpOperation)
+
+	for _, group := range h.config.GcpInstanceGroups {
+		operation, err := h.removeGcpInstance(ctx, instance, group)
+		if err != nil {
+			h.config.Logger.Error("failed to remove instance from instance group", "instance_group", group, "error", err.Error(), "handler", h.Name())
+			deregistrationErrors = append(deregistrationErrors, fmt.Errorf("instance group %s: %w", group, err))
+			continue
+		}
+		h.config.Logger.Info("instance removal requested", "instance_group", group, "operation", operation.Name, "handler", h.Name())
+		operations[group] = operation
+	}
+
+	h.waitGcpDrained(ctx, instance, operations)
+
+	return errors.Join(deregistrationErrors...)
+}
+
+// resolveGcpInstance fills the instance identity from config, falling back to the metadata server
+func (h *LoadBalancerHandler) resolveGcpInstance(ctx context.Context) (gcpInstance, error) {
+	instance := gcpInstance{
+		project: h.config.GcpProject,
+		zone:    h.config.GcpZone,
+		name:    h.config.GcpInstanceName,
+	}
+
+	lookups := []struct {
+		value *string
+		path  string
+	}{
+		{&instance.project, LoadBalancerGcpMetadataProjectPath},
+		{&instance.zone, LoadBalancerGcpMetadataZonePath},
+		{&instance.name, LoadBalancerGcpMetadataNamePath},
+	}
+
+	for _, l := range lookups {
+		if *l.value != "" {
+			continue
+		}
+		value, err := h.doGcpMetadataRequest(ctx, l.path)
+		if err != nil {
+			return instance, fmt.Errorf("failed to get %s from metadata: %w", l.path, err)
+		}
+		*l.value = value
+	}
+
+	// metadata returns the zone as projects/<number>/zones/<zone>
+	instance.zone = instance.zone[strings.LastIndex(instance.zone, "/")+1:]
+
+	tokenBody, err := h.doGcpMetadataRequest(ctx, LoadBalancerGcpMetadataTokenPath)
+	if err != nil {
+		return instance, fmt.Errorf("failed to get access token from metadata: %w", err)
+	}
+
+	var token gcpAccessToken
+	if err := json.Unmarshal([]byte(tokenBody), &token); err != nil {
+		return instance, fmt.Errorf("failed to unmarshal access token: %w", err)
+	}
+	instance.token = token.AccessToken
+
+	return instance, nil
+}
+
+func (h *LoadBalancerHandler) removeGcpInstance(ctx context.Context, instance gcpInstance, group string) (gcpOperation, error) {
+	body, err := json.Marshal(map[string]any{
+		"instances": []map[string]string{
+			{"instance": fmt.Sprintf("projects/%s/zones/%s/instances/%s", instance.project, instance.zone, instance.name)},
+		},
+	})
+	if err != nil {
+		return gcpOperation{}, err
+	}
+
+	path := fmt.Sprintf("/projects/%s/zones/%s/instanceGroups/%s/removeInstances", instance.project, instance.zone, group)
+	return h.doGcpComputeRequest(ctx, instance, "POST", path, body)
+}
+
+// waitGcpDrained polls the removal operations until they are done.
+// Running out of budget is only logged, the backend stops sending new
+// connections as soon as the removal starts.
+func (h *LoadBalancerHandler) waitGcpDrained(ctx context.Context, instance gcpInstance, operations map[string]gcpOperation) {
+	if len(operations) == 0 {
+		return
+	}
+
+	budget := h.drainBudget(ctx)
+	if budget <= 0 {
+		h.config.Logger.Warn("no time left to wait for gcp connection draining", "handler", h.Name())
+		return
+	}
+
+	h.config.Logger.Info("waiting for gcp connection draining", "budget", budget.String(), "handler", h.Name())
+
+	waitCtx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	for group, operation := range operations {
+		wg.Add(1)
+		go func(group string, operation gcpOperation) {
+			defer wg.Done()
+			if err := h.waitGcpOperation(waitCtx, instance, operation); err != nil {
+				h.config.Logger.Warn("instance group still draining", "instance_group", group, "error", err.Error(), "handler", h.Name())
+				return
+			}
+			h.config.Logger.Info("instance group drained", "instance_group", group, "handler", h.Name())
+		}(group, operation)
+	}
+
+	wg.Wait()
+}
+
+func (h *LoadBalancerHandler) waitGcpOperation(ctx context.Context, instance gcpInstance, operation gcpOperation) error {
+	ticker := time.NewTicker(LoadBalancerDrainPollInterval)
+	defer ticker.Stop()
+
+	path := fmt.Sprintf("/projects/%s/zones/%s/operations/%s", instance.project, instance.zone, operation.Name)
+
+	for {
+		if operation.Status == "DONE" {
+			if operation.Error != nil && len(operation.Error.Errors) > 0 {
+				return fmt.Errorf("operation failed: %s", operation.Error.Errors[0].Message)
+			}
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+			var err error
+			operation, err = h.doGcpComputeRequest(ctx, instance, "GET", path, nil)
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (h *LoadBalancerHandler) doGcpComputeRequest(ctx context.Context, instance gcpInstance, method string, path string, body []byte) (gcpOperation, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.config.GcpEndpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return gcpOperation{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+instance.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := h.gcpClient.Do(req)
+	if err != nil {
+		return gcpOperation{}, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return gcpOperation{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return gcpOperation{}, fmt.Errorf("got %d as http request: %s", res.StatusCode, strings.TrimSpace(string(resBody)))
+	}
+
+	var operation gcpOperation
+	if err := json.Unmarshal(resBody, &operation); err != nil {
+		return gcpOperation{}, fmt.Errorf("failed to unmarshal operation: %w", err)
+	}
+
+	return operation, nil
+}
+
+func (h *LoadBalancerHandler) doGcpMetadataRequest(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", h.config.GcpMetadataEndpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	res, err := h.gcpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got %d as http request", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(body), nil
+}
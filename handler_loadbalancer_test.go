@@ -0,0 +1,134 @@
+package evacuator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeElb answers the ELB and ELBv2 query API calls of the deregistration,
+// both clients share its endpoint like with LocalStack
+type fakeElb struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeElb) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	form, _ := url.ParseQuery(string(body))
+	action := form.Get("Action")
+
+	f.mu.Lock()
+	f.calls = append(f.calls, action+" "+form.Get("TargetGroupArn")+form.Get("LoadBalancerName"))
+	f.mu.Unlock()
+
+	switch action {
+	case "DescribeTargetGroups":
+		fakeElbRespond(w, action, `<TargetGroups>
+			<member><TargetGroupArn>arn:tg/web</TargetGroupArn></member>
+			<member><TargetGroupArn>arn:tg/deleted</TargetGroupArn></member>
+		</TargetGroups>`)
+	case "DescribeTargetHealth":
+		switch {
+		case form.Get("TargetGroupArn") == "arn:tg/deleted":
+			fakeElbError(w, "TargetGroupNotFound", "target group not found")
+		case form.Get("Targets.member.1.Id") != "":
+			// the waiter after the deregistration
+			fakeElbRespond(w, action, `<TargetHealthDescriptions><member>
+				<Target><Id>i-0abc</Id><Port>80</Port></Target>
+				<TargetHealth><State>unused</State></TargetHealth>
+			</member></TargetHealthDescriptions>`)
+		default:
+			fakeElbRespond(w, action, `<TargetHealthDescriptions>
+				<member><Target><Id>i-0abc</Id><Port>80</Port></Target><TargetHealth><State>healthy</State></TargetHealth></member>
+				<member><Target><Id>i-0other</Id><Port>80</Port></Target><TargetHealth><State>healthy</State></TargetHealth></member>
+			</TargetHealthDescriptions>`)
+		}
+	case "DeregisterTargets", "DeregisterInstancesFromLoadBalancer":
+		fakeElbRespond(w, action, "")
+	case "DescribeLoadBalancers":
+		fakeElbError(w, "AccessDenied", "not authorized to perform elasticloadbalancing:DescribeLoadBalancers")
+	case "DescribeInstanceHealth":
+		fakeElbError(w, "InvalidInstance", "instance is not registered")
+	default:
+		fakeElbError(w, "InvalidAction", "unexpected action "+action)
+	}
+}
+
+func (f *fakeElb) called(call string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+func fakeElbRespond(w http.ResponseWriter, action, result string) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<%[1]sResponse><%[1]sResult>%[2]s</%[1]sResult><ResponseMetadata><RequestId>req</RequestId></ResponseMetadata></%[1]sResponse>`, action, result)
+}
+
+func fakeElbError(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error><RequestId>req</RequestId></ErrorResponse>`, code, message)
+}
+
+func TestLoadBalancerDeregisterAwsPartialLookup(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", t.TempDir()+"/config")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", t.TempDir()+"/credentials")
+
+	fake := &fakeElb{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	h, err := NewLoadBalancerHandler(&LoadBalancerHandlerConfig{
+		Logger:                  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		DrainTimeout:            5 * time.Second,
+		AwsEnabled:              true,
+		AwsRegion:               "us-east-1",
+		AwsEndpoint:             server.URL,
+		AwsClassicLoadBalancers: []string{"legacy"},
+		AwsAutoDiscover:         true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = h.HandleTermination(ctx, TerminationEvent{InstanceID: "i-0abc", Hostname: "node-1"})
+
+	// the failed lookups are reported
+	if err == nil {
+		t.Fatal("expected the failed lookups to be returned")
+	}
+	for _, want := range []string{"arn:tg/deleted", "TargetGroupNotFound", "classic load balancers", "AccessDenied"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	// and do not keep the instance in the load balancers that were found
+	if !fake.called("DeregisterTargets arn:tg/web") {
+		t.Error("instance not deregistered from the target group found")
+	}
+	if !fake.called("DeregisterInstancesFromLoadBalancer legacy") {
+		t.Error("instance not deregistered from the configured classic load balancer")
+	}
+}
@@ -15,7 +15,11 @@ type NomadHandler struct {
 
 type NomadHandlerConfig struct {
 	Logger *slog.Logger
+	Name   string
 	Force  bool
+
+	// DryRun reports the allocations the drain would migrate without draining
+	DryRun bool
 }
 
 func NewNomadHandler(config *NomadHandlerConfig) (*NomadHandler, error) {
@@ -33,13 +37,72 @@ func NewNomadHandler(config *NomadHandlerConfig) (*NomadHandler, error) {
 }
 
 func (h *NomadHandler) Name() string {
-	return "nomad"
+	if h.config.Name != "" {
+		return h.config.Name
+	}
+	return string(HandlerNameNomad)
 }
 
 func (h *NomadHandler) HandleTermination(ctx context.Context, event TerminationEvent) error {
 
 	h.config.Logger.Info("handling nomad node termination", "node", event.Hostname, "handler", h.Name())
 
+	nodeID, err := h.findNode(event)
+	if err != nil || nodeID == "" {
+		return err
+	}
+
+	h.config.Logger.Info("nomad node found, proceeding with cordon", "node_id", nodeID, "node", nodeID, "node", event.Hostname, "handler", h.Name())
+
+	if h.config.DryRun {
+		return h.reportDrain(nodeID, event)
+	}
+
+	// cordon & drain the node
+	_, err = h.nomadClient.Nodes().UpdateDrain(nodeID, &nomadApi.DrainSpec{
+		IgnoreSystemJobs: h.config.Force,
+	}, false, &nomadApi.WriteOptions{})
+
+	if err != nil {
+		h.config.Logger.Debug(fmt.Sprintf("failed to drain nomad node for %s", event.Hostname), "handler", h.Name())
+		return err
+	}
+	h.config.Logger.Info("nomad node successfully drained", "node_id", nodeID, "node", event.Hostname, "handler", h.Name())
+
+	return nil
+}
+
+// HandleCancellation makes the node of an instance that was not terminated
+// after all eligible for scheduling again, stopping a drain still in progress
+func (h *NomadHandler) HandleCancellation(ctx context.Context, event TerminationEvent) error {
+
+	h.config.Logger.Info("recovering nomad node", "node", event.Hostname, "handler", h.Name())
+
+	nodeID, err := h.findNode(event)
+	if err != nil || nodeID == "" {
+		return err
+	}
+
+	if h.config.DryRun {
+		h.config.Logger.Info("nomad node would be marked eligible", "node_id", nodeID, "node", event.Hostname, "dry_run", true, "handler", h.Name())
+		return nil
+	}
+
+	// a nil drain spec cancels the drain
+	_, err = h.nomadClient.Nodes().UpdateDrain(nodeID, nil, true, &nomadApi.WriteOptions{})
+	if err != nil {
+		h.config.Logger.Debug(fmt.Sprintf("failed to mark nomad node eligible for %s", event.Hostname), "handler", h.Name())
+		return err
+	}
+	h.config.Logger.Info("nomad node successfully marked eligible", "node_id", nodeID, "node", event.Hostname, "handler", h.Name())
+
+	return nil
+}
+
+// findNode returns the ID of the nomad node named after the event hostname,
+// empty when there is none
+func (h *NomadHandler) findNode(event TerminationEvent) (string, error) {
+
 	// get nomad nodes
 	nomadNodes, _, err := h.nomadClient.Nodes().List(&nomadApi.QueryOptions{
 		Filter: fmt.Sprintf(`Name == "%s"`, event.Hostname),
@@ -47,35 +110,48 @@ func (h *NomadHandler) HandleTermination(ctx context.Context, event TerminationE
 
 	if err != nil {
 		h.config.Logger.Debug("failed to list nomad nodes", "error", err.Error(), "handler", h.Name())
-		return err
+		return "", err
 	}
 
 	// get nomad node ID for first data
-	var nodeID string
 	for _, node := range nomadNodes {
 		if node.Name == event.Hostname {
-			nodeID = node.ID
-			break
+			return node.ID, nil
 		}
 	}
 
-	if nodeID == "" {
-		h.config.Logger.Debug(fmt.Sprintf("failed to find nomad node for %s", event.Hostname), "handler", h.Name())
+	h.config.Logger.Debug(fmt.Sprintf("failed to find nomad node for %s", event.Hostname), "handler", h.Name())
+	return "", nil
+}
+
+// reportDrain logs the allocations a drain of the node would migrate
+func (h *NomadHandler) reportDrain(nodeID string, event TerminationEvent) error {
+	allocations, _, err := h.nomadClient.Nodes().Allocations(nodeID, &nomadApi.QueryOptions{})
+	if err != nil {
+		h.config.Logger.Debug("failed to list nomad allocations", "error", err.Error(), "handler", h.Name())
 		return err
 	}
 
-	h.config.Logger.Info("nomad node found, proceeding with cordon", "node_id", nodeID, "node", nodeID, "node", event.Hostname, "handler", h.Name())
-
-	// cordon & drain the node
-	_, err = h.nomadClient.Nodes().UpdateDrain(nodeID, &nomadApi.DrainSpec{
-		IgnoreSystemJobs: h.config.Force,
-	}, false, &nomadApi.WriteOptions{})
+	count := 0
+	for _, alloc := range allocations {
+		// terminal allocations are not migrated
+		if alloc.ClientTerminalStatus() || alloc.ServerTerminalStatus() {
+			continue
+		}
+		// system jobs are only left running when forced
+		if h.config.Force && alloc.Job != nil && alloc.Job.Type != nil && *alloc.Job.Type == nomadApi.JobTypeSystem {
+			continue
+		}
 
-	if err != nil {
-		h.config.Logger.Debug(fmt.Sprintf("failed to drain nomad node for %s", event.Hostname), "handler", h.Name())
-		return err
+		h.config.Logger.Info("nomad allocation would be migrated",
+			"alloc_id", alloc.ID,
+			"job", alloc.JobID,
+			"task_group", alloc.TaskGroup,
+			"dry_run", true,
+			"handler", h.Name())
+		count++
 	}
-	h.config.Logger.Info("nomad node successfully drained", "node_id", nodeID, "node", event.Hostname, "handler", h.Name())
 
+	h.config.Logger.Info("nomad node would be drained", "node_id", nodeID, "node", event.Hostname, "allocations", count, "dry_run", true, "handler", h.Name())
 	return nil
 }
@@ -0,0 +1,306 @@
+package evacuator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// PluginHandler runs a Handler implemented by an external plugin binary.
+// The plugin process is started on creation and restarted when it has
+// exited by the time an event arrives.
+type PluginHandler struct {
+	config PluginHandlerConfig
+
+	mu      sync.Mutex
+	client  *plugin.Client
+	handler Handler
+}
+
+type PluginHandlerConfig struct {
+	Logger *slog.Logger
+	Name   string
+	Path   string
+	Args   []string
+	Env    []string
+
+	// DryRun skips calling the plugin, plugins cannot be asked for a dry run
+	DryRun bool
+}
+
+// pluginHostEnvAllowlist is the environment passed on to every plugin
+var pluginHostEnvAllowlist = []string{"PATH", "HOME", "TMPDIR", "TZ", "LANG"}
+
+const (
+	// PluginBinaryPrefix is the file name prefix of plugin binaries in the plugin directory
+	PluginBinaryPrefix = "evacuator-handler-"
+
+	// name the handler is dispensed under, shared by host and plugin
+	pluginDispenseName = "handler"
+
+	// gRPC service implemented by every handler plugin
+	pluginServiceName = "evacuator.plugin.v1.Handler"
+)
+
+// PluginHandshake is the handshake shared by evacuator and its plugins. A
+// plugin built against a different protocol version is refused on start.
+var PluginHandshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "EVACUATOR_PLUGIN",
+	MagicCookieValue: "handler",
+}
+
+// ServePlugin serves h as a handler plugin. It is called from the main
+// function of a plugin binary and blocks until evacuator stops the plugin.
+func ServePlugin(h Handler) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: PluginHandshake,
+		Plugins: map[string]plugin.Plugin{
+			pluginDispenseName: &HandlerPlugin{Impl: h},
+		},
+		GRPCServer: plugin.DefaultGRPCServer,
+	})
+}
+
+// CleanupPlugins stops every running plugin process, it is called once on shutdown
+func CleanupPlugins() {
+	plugin.CleanupClients()
+}
+
+func NewPluginHandler(config *PluginHandlerConfig) (*PluginHandler, error) {
+
+	if _, err := os.Stat(config.Path); err != nil {
+		return nil, fmt.Errorf("plugin binary not found: %w", err)
+	}
+
+	h := &PluginHandler{
+		config: *config,
+	}
+
+	if err := h.start(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *PluginHandler) Name() string {
+	return h.config.Name
+}
+
+func (h *PluginHandler) HandleTermination(ctx context.Context, event TerminationEvent) error {
+	if h.config.DryRun {
+		h.config.Logger.Info("plugin would be called", "path", h.config.Path, "dry_run", true, "handler", h.Name())
+		return nil
+	}
+
+	h.mu.Lock()
+	if h.client.Exited() {
+		h.config.Logger.Warn("plugin process exited, restarting", "path", h.config.Path, "handler", h.Name())
+		if err := h.start(); err != nil {
+			h.mu.Unlock()
+			return fmt.Errorf("failed to restart plugin: %w", err)
+		}
+	}
+	handler := h.handler
+	h.mu.Unlock()
+
+	return handler.HandleTermination(ctx, event)
+}
+
+// Close stops the plugin process
+func (h *PluginHandler) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.client.Kill()
+}
+
+// pluginHostEnv returns the variables of the evacuator environment a plugin
+// inherits. The rest holds tokens and cloud credentials, plugins get what they
+// need through their env setting.
+func pluginHostEnv() []string {
+	var env []string
+	for _, name := range pluginHostEnvAllowlist {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// start launches the plugin process and dispenses its handler
+func (h *PluginHandler) start() error {
+	cmd := exec.Command(h.config.Path, h.config.Args...)
+	cmd.Env = append(pluginHostEnv(), h.config.Env...)
+
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig: PluginHandshake,
+		Plugins: map[string]plugin.Plugin{
+			pluginDispenseName: &HandlerPlugin{},
+		},
+		Cmd:              cmd,
+		SkipHostEnv:      true,
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Managed:          true,
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "plugin." + h.config.Name,
+			Level:  hclog.Info,
+			Output: os.Stderr,
+		}),
+	})
+
+	rpcClient, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return fmt.Errorf("failed to start plugin: %w", err)
+	}
+
+	raw, err := rpcClient.Dispense(pluginDispenseName)
+	if err != nil {
+		client.Kill()
+		return fmt.Errorf("failed to dispense plugin handler: %w", err)
+	}
+
+	handler := raw.(Handler)
+	h.config.Logger.Info("plugin started", "path", h.config.Path, "plugin_name", handler.Name(), "handler", h.Name())
+
+	h.client = client
+	h.handler = handler
+	return nil
+}
+
+// HandlerPlugin is the go-plugin definition of a handler plugin, Impl is
+// only set on the plugin side.
+type HandlerPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	Impl Handler
+}
+
+func (p *HandlerPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&pluginServiceDesc, &pluginGRPCServer{handler: p.Impl})
+	return nil
+}
+
+func (p *HandlerPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return &pluginGRPCClient{conn: c}, nil
+}
+
+// The service is described by hand with protobuf well-known types as messages,
+// so plugins in other languages only need the method names below:
+//
+//	Name(google.protobuf.Empty) returns (google.protobuf.StringValue)
+//	HandleTermination(google.protobuf.Struct) returns (google.protobuf.Empty)
+//
+// The Struct carries the hostname, private_ip, instance_id, reason and key
+// fields, key identifies the notice for idempotent side effects.
+var pluginServiceDesc = grpc.ServiceDesc{
+	ServiceName: pluginServiceName,
+	HandlerType: (*pluginService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Name", Handler: pluginNameHandler},
+		{MethodName: "HandleTermination", Handler: pluginHandleTerminationHandler},
+	},
+	Metadata: "evacuator/handler_plugin.go",
+}
+
+type pluginService interface {
+	name(ctx context.Context) (*wrapperspb.StringValue, error)
+	handleTermination(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+}
+
+type pluginGRPCServer struct {
+	handler Handler
+}
+
+func (s *pluginGRPCServer) name(ctx context.Context) (*wrapperspb.StringValue, error) {
+	return wrapperspb.String(s.handler.Name()), nil
+}
+
+func (s *pluginGRPCServer) handleTermination(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	fields := req.GetFields()
+	event := TerminationEvent{
+		Hostname:   fields["hostname"].GetStringValue(),
+		PrivateIP:  fields["private_ip"].GetStringValue(),
+		InstanceID: fields["instance_id"].GetStringValue(),
+		Reason:     TerminationReason(fields["reason"].GetStringValue()),
+		Key:        fields["key"].GetStringValue(),
+	}
+
+	if err := s.handler.HandleTermination(ctx, event); err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func pluginNameHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(pluginService).name(ctx)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + pluginServiceName + "/Name"}
+	return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(pluginService).name(ctx)
+	})
+}
+
+func pluginHandleTerminationHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(pluginService).handleTermination(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + pluginServiceName + "/HandleTermination"}
+	return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(pluginService).handleTermination(ctx, req.(*structpb.Struct))
+	})
+}
+
+// pluginGRPCClient implements Handler on the evacuator side
+type pluginGRPCClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *pluginGRPCClient) Name() string {
+	out := new(wrapperspb.StringValue)
+	if err := c.conn.Invoke(context.Background(), "/"+pluginServiceName+"/Name", &emptypb.Empty{}, out); err != nil {
+		return "unknown"
+	}
+	return out.GetValue()
+}
+
+func (c *pluginGRPCClient) HandleTermination(ctx context.Context, event TerminationEvent) error {
+	req, err := structpb.NewStruct(map[string]interface{}{
+		"hostname":    event.Hostname,
+		"private_ip":  event.PrivateIP,
+		"instance_id": event.InstanceID,
+		"reason":      string(event.Reason),
+		"key":         event.Key,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.conn.Invoke(ctx, "/"+pluginServiceName+"/HandleTermination", req, &emptypb.Empty{}); err != nil {
+		return fmt.Errorf("%s", status.Convert(err).Message())
+	}
+
+	return nil
+}
@@ -19,8 +19,12 @@ type TelegramHandler struct {
 
 type TelegramHandlerConfig struct {
 	Logger   *slog.Logger
+	Name     string
 	BotToken string
 	ChatID   string
+
+	// DryRun renders the message without sending it
+	DryRun bool
 }
 
 func NewTelegramHandler(config *TelegramHandlerConfig) (*TelegramHandler, error) {
@@ -46,7 +50,10 @@ func NewTelegramHandler(config *TelegramHandlerConfig) (*TelegramHandler, error)
 }
 
 func (h *TelegramHandler) Name() string {
-	return "telegram"
+	if h.config.Name != "" {
+		return h.config.Name
+	}
+	return string(HandlerNameTelegram)
 }
 
 func (h *TelegramHandler) HandleTermination(ctx context.Context, event TerminationEvent) error {
@@ -71,6 +78,11 @@ Node evacuation process has been initiated\.`,
 		escapeMarkdown(string(event.Reason)),
 	)
 
+	if h.config.DryRun {
+		h.config.Logger.Info("telegram message would be sent", "chat_id", h.config.ChatID, "message", message, "dry_run", true, "handler", h.Name())
+		return nil
+	}
+
 	// Send message using telego
 	_, err := h.telegoBot.SendMessage(ctx, &telego.SendMessageParams{
 		ChatID:    h.chatId,
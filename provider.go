@@ -1,6 +1,15 @@
 package evacuator
 
-import "context"
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 type Provider interface {
 	// Get the provider name
@@ -13,6 +22,12 @@ type Provider interface {
 	StartMonitoring(ctx context.Context, e chan<- TerminationEvent)
 }
 
+// PollIntervalSetter is implemented by providers polling the metadata service,
+// the new interval applies while monitoring, e.g. on a configuration reload
+type PollIntervalSetter interface {
+	SetPollInterval(interval time.Duration)
+}
+
 type ProviderName string
 
 const (
@@ -23,3 +38,184 @@ const (
 	ProviderTencent  ProviderName = "tencent"
 	ProviderHuawei   ProviderName = "huawei"
 )
+
+// Signals a metadata provider can watch for, see providerSignals
+const (
+	ProviderSignalSpot        = "spot"
+	ProviderSignalRebalance   = "rebalance"
+	ProviderSignalMaintenance = "maintenance"
+)
+
+// providerSignals are the signals each metadata provider supports, the
+// first one is watched when none is configured
+var providerSignals = map[ProviderName][]string{
+	ProviderAWS:      {ProviderSignalSpot, ProviderSignalRebalance, ProviderSignalMaintenance},
+	ProviderGcp:      {ProviderSignalSpot, ProviderSignalMaintenance},
+	ProviderAlicloud: {ProviderSignalSpot},
+	ProviderTencent:  {ProviderSignalSpot},
+	ProviderHuawei:   {ProviderSignalSpot},
+}
+
+// metadataProviderLimits bounds the poll interval and request timeout of a
+// metadata provider, following the guidance of its cloud
+type metadataProviderLimits struct {
+	minPollInterval   time.Duration
+	maxPollInterval   time.Duration
+	minRequestTimeout time.Duration
+	maxRequestTimeout time.Duration
+}
+
+var metadataProviderBounds = map[ProviderName]metadataProviderLimits{
+	ProviderAWS:      {1 * time.Second, 10 * time.Second, 1 * time.Second, 5 * time.Second},
+	ProviderGcp:      {1 * time.Second, 10 * time.Second, 1 * time.Second, 5 * time.Second},
+	ProviderAlicloud: {3 * time.Second, 10 * time.Second, 1 * time.Second, 5 * time.Second},
+	ProviderTencent:  {3 * time.Second, 10 * time.Second, 1 * time.Second, 5 * time.Second},
+	ProviderHuawei:   {3 * time.Second, 10 * time.Second, 1 * time.Second, 5 * time.Second},
+}
+
+// GcpHangingGetMinRequestTimeout and GcpHangingGetMaxRequestTimeout bound the
+// request timeout of the gcp provider when it waits for changes with hanging
+// GETs. Each GET waits for the timeout less a second, shorter ones are polls.
+const (
+	GcpHangingGetMinRequestTimeout = 30 * time.Second
+	GcpHangingGetMaxRequestTimeout = 5 * time.Minute
+)
+
+// DefaultAwsMaintenanceLeadTime is how long before it starts a scheduled aws
+// event is handled, when no lead time is configured
+const DefaultAwsMaintenanceLeadTime = 30 * time.Minute
+
+// DefaultMetadataTokenTTL is the ttl of the session tokens of the metadata
+// services requiring one, when none is configured
+const DefaultMetadataTokenTTL = 60 * time.Second
+
+// MetadataProviderConfig configures the providers polling the instance
+// metadata service of their cloud
+type MetadataProviderConfig struct {
+	Logger     *slog.Logger
+	HttpClient *http.Client
+
+	// PollInterval is the time between two checks of the metadata service
+	PollInterval time.Duration
+
+	// Endpoint replaces the base url of the metadata service when set, e.g.
+	// for a proxy or a local mock
+	Endpoint string
+
+	// Signals are the kinds of notices watched for, only spot when empty
+	Signals []string
+
+	// TokenTTL is the ttl of the metadata session token, for the services
+	// requiring one. DefaultMetadataTokenTTL when zero.
+	TokenTTL time.Duration
+
+	// HangingGet makes the gcp provider wait for metadata changes instead of
+	// only polling
+	HangingGet bool
+
+	// MaintenanceLeadTime is how long before it starts a scheduled event of
+	// the aws provider becomes a notice. DefaultAwsMaintenanceLeadTime when zero.
+	MaintenanceLeadTime time.Duration
+}
+
+// endpoint returns the configured endpoint, or base without one
+func (c *MetadataProviderConfig) endpoint(base string) string {
+	if c.Endpoint == "" {
+		return base
+	}
+	return strings.TrimSuffix(c.Endpoint, "/")
+}
+
+// signals returns the set of watched signals
+func (c *MetadataProviderConfig) signals() map[string]bool {
+	signals := map[string]bool{ProviderSignalSpot: len(c.Signals) == 0}
+	for _, signal := range c.Signals {
+		signals[signal] = true
+	}
+	return signals
+}
+
+// errMetadataNotFound is returned for metadata that does not exist, e.g. a
+// notice that was not issued
+var errMetadataNotFound = errors.New("metadata not found")
+
+// metadataToken caches the session token of a metadata service until shortly
+// before its ttl ends, instead of requesting one for every metadata request
+type metadataToken struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	value   string
+	expires time.Time
+}
+
+func newMetadataToken(ttl time.Duration) *metadataToken {
+	if ttl <= 0 {
+		ttl = DefaultMetadataTokenTTL
+	}
+	return &metadataToken{ttl: ttl}
+}
+
+// get returns the cached token, or a new one from request, which is passed
+// the ttl in seconds
+func (t *metadataToken) get(ctx context.Context, request func(ctx context.Context, ttlSeconds string) (string, error)) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.value != "" && time.Now().Before(t.expires) {
+		return t.value, nil
+	}
+
+	token, err := request(ctx, strconv.Itoa(int(t.ttl.Seconds())))
+	if err != nil {
+		return "", err
+	}
+
+	// renew ahead of the end of the ttl, so no request uses an expired token
+	t.value = token
+	t.expires = time.Now().Add(t.ttl - t.ttl/4)
+	return token, nil
+}
+
+// invalidate drops the cached token, e.g. after the service rejected it
+func (t *metadataToken) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.value = ""
+}
+
+// pollInterval is the interval of a polling provider, changed is signalled
+// when it is set to a different value
+type pollInterval struct {
+	mu       sync.Mutex
+	interval time.Duration
+	changed  chan struct{}
+}
+
+func newPollInterval(interval time.Duration) *pollInterval {
+	return &pollInterval{
+		interval: interval,
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+func (p *pollInterval) get() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.interval
+}
+
+func (p *pollInterval) set(interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if interval == p.interval {
+		return
+	}
+	p.interval = interval
+
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
@@ -12,38 +12,49 @@ import (
 
 // AlicloudProvider is an implementation of the Provider interface for Alicloud.
 type AlicloudProvider struct {
-	httpClient *http.Client
-	logger     *slog.Logger
-	mu         sync.Mutex // protects against overlapping spot checks
+	httpClient   *http.Client
+	logger       *slog.Logger
+	pollInterval *pollInterval
+	baseUrl      string
+	token        *metadataToken
+	mu           sync.Mutex // protects against overlapping spot checks
 }
 
 const (
 	AlicloudMetaDataBaseUrl = "http://100.100.100.200/latest"
 
 	// token endpoint
-	AlicloudMetaDataTokenUrl = AlicloudMetaDataBaseUrl + "/api/token"
+	AlicloudMetaDataTokenPath = "/api/token"
 
-	// alicloud metadata endpoint
-	AlicloudMetaDataSpotUrl       = AlicloudMetaDataBaseUrl + "/meta-data/instance/spot/termination-time"
-	AlicloudMetaDataHostnameUrl   = AlicloudMetaDataBaseUrl + "/meta-data/hostname"
-	AlicloudMetaDataInstanceIdUrl = AlicloudMetaDataBaseUrl + "/meta-data/instance-id"
-	AlicloudMetaDataLocalIpUrl    = AlicloudMetaDataBaseUrl + "/meta-data/private-ipv4"
+	// alicloud metadata endpoint, relative to the base url
+	AlicloudMetaDataSpotPath       = "/meta-data/instance/spot/termination-time"
+	AlicloudMetaDataHostnamePath   = "/meta-data/hostname"
+	AlicloudMetaDataInstanceIdPath = "/meta-data/instance-id"
+	AlicloudMetaDataLocalIpPath    = "/meta-data/private-ipv4"
 )
 
-func NewAlicloudProvider(client *http.Client, logger *slog.Logger) *AlicloudProvider {
+func NewAlicloudProvider(config *MetadataProviderConfig) *AlicloudProvider {
 	return &AlicloudProvider{
-		httpClient: client,
-		logger:     logger,
+		httpClient:   config.HttpClient,
+		logger:       config.Logger,
+		pollInterval: newPollInterval(config.PollInterval),
+		baseUrl:      config.endpoint(AlicloudMetaDataBaseUrl),
+		token:        newMetadataToken(config.TokenTTL),
 	}
 }
 
+// SetPollInterval changes the poll interval, also while monitoring
+func (p *AlicloudProvider) SetPollInterval(interval time.Duration) {
+	p.pollInterval.set(interval)
+}
+
 func (p *AlicloudProvider) Name() ProviderName {
 	return ProviderAlicloud
 }
 
 func (p *AlicloudProvider) IsSupported(ctx context.Context) bool {
 
-	_, err := p.doMetadataRequest(ctx, AlicloudMetaDataHostnameUrl)
+	_, err := p.doMetadataRequest(ctx, AlicloudMetaDataHostnamePath)
 	if err != nil {
 		p.logger.Debug("fail to detect alicloud provider", "error", err.Error(), "provider", p.Name())
 		return false
@@ -61,9 +72,7 @@ func (p *AlicloudProvider) StartMonitoring(ctx context.Context, e chan<- Termina
 
 func (p *AlicloudProvider) startMonitoring(ctx context.Context, e chan<- TerminationEvent) {
 
-	config := GetProviderConfig()
-
-	ticker := time.NewTicker(config.PollInterval)
+	ticker := time.NewTicker(p.pollInterval.get())
 	defer ticker.Stop()
 
 	for {
@@ -102,6 +111,11 @@ func (p *AlicloudProvider) startMonitoring(ctx context.Context, e chan<- Termina
 
 			p.mu.Unlock()
 
+		case <-p.pollInterval.changed:
+			interval := p.pollInterval.get()
+			ticker.Reset(interval)
+			p.logger.Info("poll interval changed", "poll_interval", interval.String(), "provider", p.Name())
+
 		case <-ctx.Done():
 			return
 		}
@@ -111,7 +125,7 @@ func (p *AlicloudProvider) startMonitoring(ctx context.Context, e chan<- Termina
 func (p *AlicloudProvider) isSpotTerminationDetected(ctx context.Context) (bool, error) {
 
 	// Get spot instance action metadata
-	_, err := p.doMetadataRequest(ctx, AlicloudMetaDataSpotUrl)
+	_, err := p.doMetadataRequest(ctx, AlicloudMetaDataSpotPath)
 	if err != nil {
 		return false, err
 	}
@@ -123,7 +137,7 @@ func (p *AlicloudProvider) getInstanceMetadatas(ctx context.Context) Termination
 	var t TerminationEvent
 
 	// Get hostname - log error but continue
-	if hostname, err := p.doMetadataRequest(ctx, AlicloudMetaDataHostnameUrl); err != nil {
+	if hostname, err := p.doMetadataRequest(ctx, AlicloudMetaDataHostnamePath); err != nil {
 		p.logger.Error("failed to get hostname", "error", err.Error(), "provider", p.Name())
 		t.Hostname = "unknown"
 	} else {
@@ -131,7 +145,7 @@ func (p *AlicloudProvider) getInstanceMetadatas(ctx context.Context) Termination
 	}
 
 	// Get private IP - log error but continue
-	if privateIP, err := p.doMetadataRequest(ctx, AlicloudMetaDataLocalIpUrl); err != nil {
+	if privateIP, err := p.doMetadataRequest(ctx, AlicloudMetaDataLocalIpPath); err != nil {
 		p.logger.Error("failed to get private IP", "error", err.Error(), "provider", p.Name())
 		t.PrivateIP = "unknown"
 	} else {
@@ -139,7 +153,7 @@ func (p *AlicloudProvider) getInstanceMetadatas(ctx context.Context) Termination
 	}
 
 	// Get instance ID - log error but continue
-	if instanceID, err := p.doMetadataRequest(ctx, AlicloudMetaDataInstanceIdUrl); err != nil {
+	if instanceID, err := p.doMetadataRequest(ctx, AlicloudMetaDataInstanceIdPath); err != nil {
 		p.logger.Error("failed to get instance ID", "error", err.Error(), "provider", p.Name())
 		t.InstanceID = "unknown"
 	} else {
@@ -151,15 +165,15 @@ func (p *AlicloudProvider) getInstanceMetadatas(ctx context.Context) Termination
 	return t
 }
 
-func (p *AlicloudProvider) getMetadataToken(ctx context.Context) (string, error) {
+func (p *AlicloudProvider) getMetadataToken(ctx context.Context, ttlSeconds string) (string, error) {
 	// Get token for authentication next request
-	req, err := http.NewRequestWithContext(ctx, "PUT", AlicloudMetaDataTokenUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "PUT", p.baseUrl+AlicloudMetaDataTokenPath, nil)
 	if err != nil {
 		return "", err
 	}
 
 	// set header
-	req.Header.Set("X-aliyun-ecs-metadata-token-ttl-seconds", "60")
+	req.Header.Set("X-aliyun-ecs-metadata-token-ttl-seconds", ttlSeconds)
 
 	// Doing request for get token
 	res, err := p.httpClient.Do(req)
@@ -168,6 +182,10 @@ func (p *AlicloudProvider) getMetadataToken(ctx context.Context) (string, error)
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got %d as token request", res.StatusCode)
+	}
+
 	// parse token
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -179,13 +197,13 @@ func (p *AlicloudProvider) getMetadataToken(ctx context.Context) (string, error)
 	return token, nil
 }
 
-func (p *AlicloudProvider) doMetadataRequest(ctx context.Context, url string) (string, error) {
-	token, err := p.getMetadataToken(ctx)
+func (p *AlicloudProvider) doMetadataRequest(ctx context.Context, path string) (string, error) {
+	token, err := p.token.get(ctx, p.getMetadataToken)
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseUrl+path, nil)
 	if err != nil {
 		return "", err
 	}
@@ -197,6 +215,10 @@ func (p *AlicloudProvider) doMetadataRequest(ctx context.Context, url string) (s
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusUnauthorized {
+		// expired or rejected, the next request gets a new one
+		p.token.invalidate()
+	}
 	if res.StatusCode != http.StatusOK {
 		return "", fmt.Errorf("got %d as http request", res.StatusCode)
 	}
@@ -3,6 +3,7 @@ package evacuator
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -13,22 +14,34 @@ import (
 
 // AwsProvider is an implementation of the Provider interface for AWS.
 type AwsProvider struct {
-	httpClient *http.Client
-	logger     *slog.Logger
-	mu         sync.Mutex // protects against overlapping spot checks
+	httpClient   *http.Client
+	logger       *slog.Logger
+	pollInterval *pollInterval
+	baseUrl      string
+	signals      map[string]bool
+	token        *metadataToken
+	mu           sync.Mutex // protects against overlapping spot checks
+
+	// scheduled events are notices from this long before they start
+	maintenanceLeadTime time.Duration
 }
 
 const (
 	AwsMetaDataBaseUrl = "http://169.254.169.254/latest"
 
 	// token endpoint
-	AwsMetaDataTokenUrl = AwsMetaDataBaseUrl + "/api/token"
-
-	// aws metadata endpoint
-	AwsMetaDataSpotUrl       = AwsMetaDataBaseUrl + "/meta-data/spot/instance-action"
-	AwsMetaDataHostnameUrl   = AwsMetaDataBaseUrl + "/meta-data/hostname"
-	AwsMetaDataInstanceIdUrl = AwsMetaDataBaseUrl + "/meta-data/instance-id"
-	AwsMetaDataLocalIpUrl    = AwsMetaDataBaseUrl + "/meta-data/local-ipv4"
+	AwsMetaDataTokenPath = "/api/token"
+
+	// aws metadata endpoint, relative to the base url
+	AwsMetaDataSpotPath        = "/meta-data/spot/instance-action"
+	AwsMetaDataRebalancePath   = "/meta-data/events/recommendations/rebalance"
+	AwsMetaDataMaintenancePath = "/meta-data/events/maintenance/scheduled"
+	AwsMetaDataHostnamePath    = "/meta-data/hostname"
+	AwsMetaDataInstanceIdPath  = "/meta-data/instance-id"
+	AwsMetaDataLocalIpPath     = "/meta-data/local-ipv4"
+
+	// awsMaintenanceTimeLayout is the time format of scheduled events
+	awsMaintenanceTimeLayout = "2 Jan 2006 15:04:05 GMT"
 )
 
 type AwsResponseSpot struct {
@@ -36,20 +49,43 @@ type AwsResponseSpot struct {
 	Time   time.Time `json:"time"`
 }
 
-func NewAwsProvider(client *http.Client, logger *slog.Logger) *AwsProvider {
+// AwsResponseMaintenance is a scheduled event of the instance
+type AwsResponseMaintenance struct {
+	Code      string `json:"Code"`
+	EventId   string `json:"EventId"`
+	NotBefore string `json:"NotBefore"`
+	State     string `json:"State"`
+}
+
+func NewAwsProvider(config *MetadataProviderConfig) *AwsProvider {
+	leadTime := config.MaintenanceLeadTime
+	if leadTime <= 0 {
+		leadTime = DefaultAwsMaintenanceLeadTime
+	}
+
 	return &AwsProvider{
-		httpClient: client,
-		logger:     logger,
+		httpClient:          config.HttpClient,
+		logger:              config.Logger,
+		pollInterval:        newPollInterval(config.PollInterval),
+		baseUrl:             config.endpoint(AwsMetaDataBaseUrl),
+		signals:             config.signals(),
+		token:               newMetadataToken(config.TokenTTL),
+		maintenanceLeadTime: leadTime,
 	}
 }
 
+// SetPollInterval changes the poll interval, also while monitoring
+func (p *AwsProvider) SetPollInterval(interval time.Duration) {
+	p.pollInterval.set(interval)
+}
+
 func (p *AwsProvider) Name() ProviderName {
 	return ProviderAWS
 }
 
 func (p *AwsProvider) IsSupported(ctx context.Context) bool {
 
-	_, err := p.doMetadataRequest(ctx, AwsMetaDataHostnameUrl)
+	_, err := p.doMetadataRequest(ctx, AwsMetaDataHostnamePath)
 	if err != nil {
 		p.logger.Debug("fail to detect aws provider", "error", err.Error(), "provider", p.Name())
 		return false
@@ -67,46 +103,77 @@ func (p *AwsProvider) StartMonitoring(ctx context.Context, e chan<- TerminationE
 
 func (p *AwsProvider) startMonitoring(ctx context.Context, e chan<- TerminationEvent) {
 
-	config := GetProviderConfig()
-
-	ticker := time.NewTicker(config.PollInterval)
+	ticker := time.NewTicker(p.pollInterval.get())
 	defer ticker.Stop()
 
+	// reason of the last notice sent, only more severe ones are sent again
+	var notified TerminationReason
+
 	for {
 		select {
 		case <-ticker.C:
 			// Use mutex to prevent overlapping executions
 			if !p.mu.TryLock() {
-				p.logger.Debug("spot termination check already in progress, skipping", "provider", p.Name())
+				p.logger.Debug("termination check already in progress, skipping", "provider", p.Name())
 				continue
 			}
 
-			// Check for spot termination
-			terminationDetected, err := p.isSpotTerminationDetected(ctx)
+			// Check for the watched notices
+			notice, detected, err := p.detectNotice(ctx)
 			if err != nil {
-				p.logger.Error("failed to detect spot termination", "error", err.Error(), "provider", p.Name())
+				p.logger.Error("failed to detect termination notice", "error", err.Error(), "provider", p.Name())
 				p.mu.Unlock()
 				continue
 			}
 
-			if terminationDetected {
-				p.logger.Info("spot termination detected", "provider", p.Name())
+			if !detected && notified != "" {
+				// the notice cleared without ending the instance, withdraw it so
+				// the handlers recover the node
+				p.logger.Info("termination notice withdrawn", "reason", notified, "provider", p.Name())
+				notified = ""
 
-				// Handle termination in a separate goroutine but keep the mutex locked
-				// to prevent further ticker executions
 				go func() {
 					defer p.mu.Unlock()
-					p.logger.Info("monitoring will be stopped and continue to handler", "provider", p.Name())
 
 					t := p.getInstanceMetadatas(ctx)
+					t.Cancelled = true
 					e <- t
 				}()
+				continue
+			}
+
+			if !detected || (notified != "" && terminationReasonSeverity[notice.Reason] <= terminationReasonSeverity[notified]) {
+				p.mu.Unlock()
+				continue
+			}
+			notified = notice.Reason
 
-				// Stop the ticker and exit the monitoring loop
+			p.logger.Info("termination notice detected", "reason", notice.Reason, "provider", p.Name())
+
+			// Handle the notice in a separate goroutine but keep the mutex locked
+			// to prevent further ticker executions until it is sent
+			final := notice.Reason == TerminationReasonSpot
+			go func() {
+				defer p.mu.Unlock()
+				if final {
+					p.logger.Info("monitoring will be stopped and continue to handler", "provider", p.Name())
+				}
+
+				t := p.getInstanceMetadatas(ctx)
+				t.Reason, t.Deadline = notice.Reason, notice.Deadline
+				e <- t
+			}()
+
+			// A spot termination ends the instance, stop the ticker and exit the
+			// monitoring loop. Other notices may still escalate or be withdrawn.
+			if final {
 				return
 			}
 
-			p.mu.Unlock()
+		case <-p.pollInterval.changed:
+			interval := p.pollInterval.get()
+			ticker.Reset(interval)
+			p.logger.Info("poll interval changed", "poll_interval", interval.String(), "provider", p.Name())
 
 		case <-ctx.Done():
 			return
@@ -114,22 +181,114 @@ func (p *AwsProvider) startMonitoring(ctx context.Context, e chan<- TerminationE
 	}
 }
 
-func (p *AwsProvider) isSpotTerminationDetected(ctx context.Context) (bool, error) {
+// detectNotice checks the watched signals, most severe first, and returns the
+// first notice issued
+func (p *AwsProvider) detectNotice(ctx context.Context) (TerminationEvent, bool, error) {
+	var notice TerminationEvent
+
+	if p.signals[ProviderSignalSpot] {
+		deadline, detected, err := p.isSpotTerminationDetected(ctx)
+		if err != nil || detected {
+			notice.Reason, notice.Deadline = TerminationReasonSpot, deadline
+			return notice, detected, err
+		}
+	}
+
+	if p.signals[ProviderSignalMaintenance] {
+		deadline, detected, err := p.isMaintenanceScheduled(ctx)
+		if err != nil || detected {
+			notice.Reason, notice.Deadline = TerminationReasonMaintenance, deadline
+			return notice, detected, err
+		}
+	}
+
+	if p.signals[ProviderSignalRebalance] {
+		detected, err := p.isRebalanceRecommended(ctx)
+		if err != nil || detected {
+			notice.Reason = TerminationReasonRebalance
+			return notice, detected, err
+		}
+	}
+
+	p.logger.Debug("no termination notice detected", "provider", p.Name())
+	return notice, false, nil
+}
+
+func (p *AwsProvider) isSpotTerminationDetected(ctx context.Context) (time.Time, bool, error) {
 
-	// Get spot instance action metadata
-	spotInfo, err := p.doMetadataRequest(ctx, AwsMetaDataSpotUrl)
+	// Get spot instance action metadata, not found until the interruption
+	spotInfo, err := p.doMetadataRequest(ctx, AwsMetaDataSpotPath)
+	if errors.Is(err, errMetadataNotFound) {
+		return time.Time{}, false, nil
+	}
 	if err != nil {
-		return false, err
+		return time.Time{}, false, err
 	}
 
 	var r AwsResponseSpot
 
 	if err := json.Unmarshal([]byte(spotInfo), &r); err != nil {
-		return false, fmt.Errorf("failed to unmarshal spot instance action: %w", err)
+		return time.Time{}, false, fmt.Errorf("failed to unmarshal spot instance action: %w", err)
 	}
 
 	if r.Action != "stop" && r.Action != "terminate" {
-		return false, fmt.Errorf("unexpected spot instance action: %s", r.Action)
+		return time.Time{}, false, fmt.Errorf("unexpected spot instance action: %s", r.Action)
+	}
+
+	return r.Time, true, nil
+}
+
+// isMaintenanceScheduled reports an active scheduled event starting within the
+// lead time, e.g. a system reboot or an instance retirement, with the time it
+// starts. Events are listed days ahead, draining the node that early would
+// take its capacity away for nothing.
+func (p *AwsProvider) isMaintenanceScheduled(ctx context.Context) (time.Time, bool, error) {
+	events, err := p.doMetadataRequest(ctx, AwsMetaDataMaintenancePath)
+	if errors.Is(err, errMetadataNotFound) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var r []AwsResponseMaintenance
+	if err := json.Unmarshal([]byte(events), &r); err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to unmarshal scheduled events: %w", err)
+	}
+
+	for _, event := range r {
+		// completed and canceled events stay listed for a while
+		if event.State != "active" {
+			continue
+		}
+
+		p.logger.Debug("scheduled event found", "code", event.Code, "event_id", event.EventId, "not_before", event.NotBefore, "provider", p.Name())
+		notBefore, err := time.Parse(awsMaintenanceTimeLayout, event.NotBefore)
+		if err != nil {
+			// without a start time the event may be due anytime
+			p.logger.Warn("failed to parse scheduled event time", "not_before", event.NotBefore, "error", err.Error(), "provider", p.Name())
+			return notBefore, true, nil
+		}
+
+		if startsIn := time.Until(notBefore); startsIn > p.maintenanceLeadTime {
+			p.logger.Debug("scheduled event not due yet", "event_id", event.EventId, "starts_in", startsIn.Round(time.Second).String(), "lead_time", p.maintenanceLeadTime.String(), "provider", p.Name())
+			continue
+		}
+		return notBefore, true, nil
+	}
+
+	return time.Time{}, false, nil
+}
+
+// isRebalanceRecommended reports a rebalance recommendation, not found until
+// one is issued
+func (p *AwsProvider) isRebalanceRecommended(ctx context.Context) (bool, error) {
+	_, err := p.doMetadataRequest(ctx, AwsMetaDataRebalancePath)
+	if errors.Is(err, errMetadataNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
 	return true, nil
@@ -139,7 +298,7 @@ func (p *AwsProvider) getInstanceMetadatas(ctx context.Context) TerminationEvent
 	var t TerminationEvent
 
 	// Get hostname - log error but continue
-	if hostname, err := p.doMetadataRequest(ctx, AwsMetaDataHostnameUrl); err != nil {
+	if hostname, err := p.doMetadataRequest(ctx, AwsMetaDataHostnamePath); err != nil {
 		p.logger.Error("failed to get hostname", "error", err.Error(), "provider", p.Name())
 		t.Hostname = "unknown"
 	} else {
@@ -147,7 +306,7 @@ func (p *AwsProvider) getInstanceMetadatas(ctx context.Context) TerminationEvent
 	}
 
 	// Get private IP - log error but continue
-	if privateIP, err := p.doMetadataRequest(ctx, AwsMetaDataLocalIpUrl); err != nil {
+	if privateIP, err := p.doMetadataRequest(ctx, AwsMetaDataLocalIpPath); err != nil {
 		p.logger.Error("failed to get private IP", "error", err.Error(), "provider", p.Name())
 		t.PrivateIP = "unknown"
 	} else {
@@ -155,27 +314,25 @@ func (p *AwsProvider) getInstanceMetadatas(ctx context.Context) TerminationEvent
 	}
 
 	// Get instance ID - log error but continue
-	if instanceID, err := p.doMetadataRequest(ctx, AwsMetaDataInstanceIdUrl); err != nil {
+	if instanceID, err := p.doMetadataRequest(ctx, AwsMetaDataInstanceIdPath); err != nil {
 		p.logger.Error("failed to get instance ID", "error", err.Error(), "provider", p.Name())
 		t.InstanceID = "unknown"
 	} else {
 		t.InstanceID = instanceID
 	}
 
-	t.Reason = TerminationReasonSpot
-
 	return t
 }
 
-func (p *AwsProvider) getMetadataToken(ctx context.Context) (string, error) {
+func (p *AwsProvider) getMetadataToken(ctx context.Context, ttlSeconds string) (string, error) {
 	// Get token for authentication next request
-	req, err := http.NewRequestWithContext(ctx, "PUT", AwsMetaDataTokenUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "PUT", p.baseUrl+AwsMetaDataTokenPath, nil)
 	if err != nil {
 		return "", err
 	}
 
 	// set header
-	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", ttlSeconds)
 
 	// Doing request for get token
 	res, err := p.httpClient.Do(req)
@@ -184,6 +341,10 @@ func (p *AwsProvider) getMetadataToken(ctx context.Context) (string, error) {
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got %d as token request", res.StatusCode)
+	}
+
 	// parse token
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -195,13 +356,13 @@ func (p *AwsProvider) getMetadataToken(ctx context.Context) (string, error) {
 	return token, nil
 }
 
-func (p *AwsProvider) doMetadataRequest(ctx context.Context, url string) (string, error) {
-	token, err := p.getMetadataToken(ctx)
+func (p *AwsProvider) doMetadataRequest(ctx context.Context, path string) (string, error) {
+	token, err := p.token.get(ctx, p.getMetadataToken)
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseUrl+path, nil)
 	if err != nil {
 		return "", err
 	}
@@ -213,7 +374,15 @@ func (p *AwsProvider) doMetadataRequest(ctx context.Context, url string) (string
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("%w: %s", errMetadataNotFound, path)
+	case http.StatusUnauthorized:
+		// expired or rejected, the next request gets a new one
+		p.token.invalidate()
+		return "", fmt.Errorf("got %d as http request", res.StatusCode)
+	default:
 		return "", fmt.Errorf("got %d as http request", res.StatusCode)
 	}
 
@@ -2,24 +2,130 @@ package evacuator
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"time"
+
+	"github.com/spf13/viper"
 )
 
 // DummyProvider is an implementation of the Provider interface for testing.
 type DummyProvider struct {
 	logger        *slog.Logger
 	DetectionWait time.Duration
+
+	// Scenario replaces the single fixed event when set
+	Scenario *DummyScenario
+
+	// requestTimeout is how long a scenario timeout step hangs by default
+	requestTimeout time.Duration
+}
+
+type DummyProviderConfig struct {
+	Logger *slog.Logger
+
+	// DetectionWait is the time until the single fixed event
+	DetectionWait time.Duration
+
+	// Scenario is replayed instead of the single fixed event when set
+	Scenario *DummyScenario
+
+	// RequestTimeout is how long a scenario timeout step without duration hangs
+	RequestTimeout time.Duration
 }
 
-func NewDummyProvider(logger *slog.Logger, detectionWait time.Duration) *DummyProvider {
-	// Note: client parameter is accepted for interface consistency but not used in dummy implementation
+// DummyScenario is a sequence of steps replayed by the dummy provider, used to
+// test handler retries, ordering and deadlines deterministically
+type DummyScenario struct {
+	Steps []DummyScenarioStep `mapstructure:"steps"`
+}
+
+// DummyScenarioStep is a single scenario step, run after waiting Wait
+type DummyScenarioStep struct {
+	Wait   time.Duration `mapstructure:"wait"`
+	Action string        `mapstructure:"action"`
+
+	// notice and cancel, empty fields use the dummy instance
+	Reason     string        `mapstructure:"reason"`
+	Hostname   string        `mapstructure:"hostname"`
+	PrivateIP  string        `mapstructure:"private_ip"`
+	InstanceID string        `mapstructure:"instance_id"`
+	Deadline   time.Duration `mapstructure:"deadline"`
+
+	// error
+	Message string `mapstructure:"message"`
+
+	// timeout, defaults to provider.request_timeout
+	Duration time.Duration `mapstructure:"duration"`
+}
+
+const (
+	// DummyActionNotice emits a termination event
+	DummyActionNotice = "notice"
+
+	// DummyActionCancel withdraws the notice of an instance
+	DummyActionCancel = "cancel"
+
+	// DummyActionError fails a metadata request
+	DummyActionError = "error"
+
+	// DummyActionTimeout hangs a metadata request until it times out
+	DummyActionTimeout = "timeout"
+)
+
+const (
+	DummyHostname   = "dummy"
+	DummyPrivateIP  = "172.16.1.1"
+	DummyInstanceID = "dummy-instance-id"
+)
+
+func NewDummyProvider(config *DummyProviderConfig) *DummyProvider {
 	return &DummyProvider{
-		logger:        logger,
-		DetectionWait: detectionWait,
+		logger:         config.Logger,
+		DetectionWait:  config.DetectionWait,
+		Scenario:       config.Scenario,
+		requestTimeout: config.RequestTimeout,
 	}
 }
 
+// LoadDummyScenario reads a YAML or JSON scenario file, the format is taken from the extension
+func LoadDummyScenario(path string) (*DummyScenario, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %w", err)
+	}
+
+	var scenario DummyScenario
+	if err := v.Unmarshal(&scenario); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario: %w", err)
+	}
+
+	if len(scenario.Steps) == 0 {
+		return nil, fmt.Errorf("scenario has no steps")
+	}
+
+	for i, step := range scenario.Steps {
+		if step.Wait < 0 || step.Deadline < 0 || step.Duration < 0 {
+			return nil, fmt.Errorf("steps[%d]: durations must not be negative", i)
+		}
+
+		switch step.Action {
+		case DummyActionNotice:
+			if _, err := ParseTerminationReason(step.Reason); err != nil {
+				return nil, fmt.Errorf("steps[%d]: %w", i, err)
+			}
+		case DummyActionCancel, DummyActionError, DummyActionTimeout:
+		default:
+			return nil, fmt.Errorf("steps[%d]: unknown action %q, must be one of %s, %s, %s, %s",
+				i, step.Action, DummyActionNotice, DummyActionCancel, DummyActionError, DummyActionTimeout)
+		}
+	}
+
+	return &scenario, nil
+}
+
 func (p *DummyProvider) Name() ProviderName {
 	return ProviderDummy
 }
@@ -31,18 +137,112 @@ func (p *DummyProvider) IsSupported(ctx context.Context) bool {
 
 func (p *DummyProvider) StartMonitoring(ctx context.Context, e chan<- TerminationEvent) {
 
+	if p.Scenario != nil {
+		go p.replayScenario(ctx, e)
+		p.logger.Info("dummy provider monitoring started", "steps", len(p.Scenario.Steps), "provider", p.Name())
+		return
+	}
+
 	go func() {
 		time.Sleep(p.DetectionWait)
 		p.logger.Info("spot termination detected", "provider", p.Name())
 		p.logger.Info("monitoring will be stopped and continue to handler", "provider", p.Name())
 
 		t := TerminationEvent{
-			Hostname:   "dummy",
-			PrivateIP:  "172.16.1.1",
-			InstanceID: "dummy-instance-id",
+			Hostname:   DummyHostname,
+			PrivateIP:  DummyPrivateIP,
+			InstanceID: DummyInstanceID,
 			Reason:     TerminationReasonSpot,
 		}
 		e <- t
 	}()
 	p.logger.Info("dummy provider monitoring started", "provider", p.Name())
 }
+
+// replayScenario runs the scenario steps in order and stops monitoring afterwards
+func (p *DummyProvider) replayScenario(ctx context.Context, e chan<- TerminationEvent) {
+
+	for i, step := range p.Scenario.Steps {
+		select {
+		case <-time.After(step.Wait):
+		case <-ctx.Done():
+			return
+		}
+
+		p.logger.Debug("running scenario step", "step", i, "action", step.Action, "provider", p.Name())
+
+		switch step.Action {
+		case DummyActionNotice:
+			t := step.terminationEvent()
+			p.logger.Info("termination detected", "reason", t.Reason, "instance_id", t.InstanceID, "step", i, "provider", p.Name())
+
+			select {
+			case e <- t:
+			case <-ctx.Done():
+				return
+			}
+
+		case DummyActionCancel:
+			t := step.terminationEvent()
+			t.Reason = ""
+			t.Cancelled = true
+			p.logger.Info("termination notice cancelled", "instance_id", t.InstanceID, "step", i, "provider", p.Name())
+
+			select {
+			case e <- t:
+			case <-ctx.Done():
+				return
+			}
+
+		case DummyActionError:
+			message := step.Message
+			if message == "" {
+				message = "got 500 as http request"
+			}
+			p.logger.Error("failed to detect spot termination", "error", message, "step", i, "provider", p.Name())
+
+		case DummyActionTimeout:
+			duration := step.Duration
+			if duration == 0 {
+				duration = p.requestTimeout
+			}
+
+			select {
+			case <-time.After(duration):
+			case <-ctx.Done():
+				return
+			}
+			p.logger.Error("failed to detect spot termination", "error", context.DeadlineExceeded.Error(), "step", i, "provider", p.Name())
+		}
+	}
+
+	p.logger.Info("dummy scenario completed, monitoring stopped", "provider", p.Name())
+}
+
+// terminationEvent builds the event of a notice or cancel step
+func (s DummyScenarioStep) terminationEvent() TerminationEvent {
+	t := TerminationEvent{
+		Hostname:   s.Hostname,
+		PrivateIP:  s.PrivateIP,
+		InstanceID: s.InstanceID,
+	}
+
+	if t.Hostname == "" {
+		t.Hostname = DummyHostname
+	}
+	if t.PrivateIP == "" {
+		t.PrivateIP = DummyPrivateIP
+	}
+	if t.InstanceID == "" {
+		t.InstanceID = DummyInstanceID
+	}
+
+	// validated when loading the scenario
+	t.Reason, _ = ParseTerminationReason(s.Reason)
+
+	if s.Deadline > 0 {
+		t.Deadline = time.Now().Add(s.Deadline)
+	}
+
+	return t
+}
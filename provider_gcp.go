@@ -2,36 +2,83 @@ package evacuator
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 )
 
 // GcpProvider is an implementation of the Provider interface for GCP.
 type GcpProvider struct {
-	httpClient *http.Client
-	logger     *slog.Logger
-	mu         sync.Mutex // protects against overlapping spot checks
+	httpClient   *http.Client
+	logger       *slog.Logger
+	pollInterval *pollInterval
+	baseUrl      string
+	signals      map[string]bool
+	mu           sync.Mutex // protects against overlapping spot checks
+
+	// hangingGet waits for changes of the instance metadata, etag is the
+	// version last seen, empty before the first request
+	hangingGet bool
+	waitFor    time.Duration
+	etag       string
 }
 
 const (
 	GcpMetaDataBaseUrl = "http://metadata.google.internal/computeMetadata/v1/instance"
 
-	// gcp metadata endpoint
-	GcpMetaDataSpotUrl       = GcpMetaDataBaseUrl + "/meta-data/spot/instance-action"
-	GcpMetaDataHostnameUrl   = GcpMetaDataBaseUrl + "/hostname"
-	GcpMetaDataInstanceIdUrl = GcpMetaDataBaseUrl + "/id"
-	GcpMetaDataLocalIpUrl    = GcpMetaDataBaseUrl + "/network-interfaces/0/ip"
+	// gcp metadata endpoint, relative to the base url
+	GcpMetaDataPreemptedPath   = "/preempted"
+	GcpMetaDataMaintenancePath = "/maintenance-event"
+	GcpMetaDataHostnamePath    = "/hostname"
+	GcpMetaDataInstanceIdPath  = "/id"
+	GcpMetaDataLocalIpPath     = "/network-interfaces/0/ip"
+
+	// GcpMetaDataInstancePath returns the whole instance directory, to watch
+	// several values with one hanging GET
+	GcpMetaDataInstancePath = "/?recursive=true"
+
+	// gcpMaintenanceTerminate is the maintenance event ending in a termination,
+	// MIGRATE_ON_HOST_MAINTENANCE is a live migration the instance keeps running through
+	gcpMaintenanceTerminate = "TERMINATE_ON_HOST_MAINTENANCE"
 )
 
-func NewGcpProvider(client *http.Client, logger *slog.Logger) *GcpProvider {
-	return &GcpProvider{
-		httpClient: client,
-		logger:     logger,
+// GcpResponseInstance is the part of the recursive instance directory with
+// the watched values
+type GcpResponseInstance struct {
+	Preempted        string `json:"preempted"`
+	MaintenanceEvent string `json:"maintenanceEvent"`
+}
+
+func NewGcpProvider(config *MetadataProviderConfig) *GcpProvider {
+	p := &GcpProvider{
+		httpClient:   config.HttpClient,
+		logger:       config.Logger,
+		pollInterval: newPollInterval(config.PollInterval),
+		baseUrl:      config.endpoint(GcpMetaDataBaseUrl),
+		signals:      config.signals(),
+		hangingGet:   config.HangingGet,
 	}
+
+	// the metadata server answers a second before the client gives up
+	if config.HttpClient != nil && config.HttpClient.Timeout > 0 {
+		p.waitFor = config.HttpClient.Timeout - time.Second
+	}
+	if p.waitFor < time.Second {
+		p.waitFor = time.Second
+	}
+
+	return p
+}
+
+// SetPollInterval changes the poll interval, also while monitoring
+func (p *GcpProvider) SetPollInterval(interval time.Duration) {
+	p.pollInterval.set(interval)
 }
 
 func (p *GcpProvider) Name() ProviderName {
@@ -40,7 +87,7 @@ func (p *GcpProvider) Name() ProviderName {
 
 func (p *GcpProvider) IsSupported(ctx context.Context) bool {
 
-	_, err := p.doMetadataRequest(ctx, GcpMetaDataHostnameUrl)
+	_, err := p.doMetadataRequest(ctx, GcpMetaDataHostnamePath)
 	if err != nil {
 		p.logger.Debug("fail to detect a gcp provider", "error", err.Error(), "provider", p.Name())
 		return false
@@ -58,46 +105,77 @@ func (p *GcpProvider) StartMonitoring(ctx context.Context, e chan<- TerminationE
 
 func (p *GcpProvider) startMonitoring(ctx context.Context, e chan<- TerminationEvent) {
 
-	config := GetProviderConfig()
-
-	ticker := time.NewTicker(config.PollInterval)
+	ticker := time.NewTicker(p.pollInterval.get())
 	defer ticker.Stop()
 
+	// reason of the last notice sent, only more severe ones are sent again
+	var notified TerminationReason
+
 	for {
 		select {
 		case <-ticker.C:
 			// Use mutex to prevent overlapping executions
 			if !p.mu.TryLock() {
-				p.logger.Debug("spot termination check already in progress, skipping", "provider", p.Name())
+				p.logger.Debug("termination check already in progress, skipping", "provider", p.Name())
 				continue
 			}
 
-			// Check for spot termination
-			terminationDetected, err := p.isSpotTerminationDetected(ctx)
+			// Check for the watched notices
+			reason, detected, err := p.detectNotice(ctx)
 			if err != nil {
-				p.logger.Error("failed to detect spot termination", "error", err.Error(), "provider", p.Name())
+				p.logger.Error("failed to detect termination notice", "error", err.Error(), "provider", p.Name())
 				p.mu.Unlock()
 				continue
 			}
 
-			if terminationDetected {
-				p.logger.Info("spot termination detected", "provider", p.Name())
+			if !detected && notified != "" {
+				// the notice cleared without ending the instance, withdraw it so
+				// the handlers recover the node
+				p.logger.Info("termination notice withdrawn", "reason", notified, "provider", p.Name())
+				notified = ""
 
-				// Handle termination in a separate goroutine but keep the mutex locked
-				// to prevent further ticker executions
 				go func() {
 					defer p.mu.Unlock()
-					p.logger.Info("monitoring will be stopped and continue to handler", "provider", p.Name())
 
 					t := p.getInstanceMetadatas(ctx)
+					t.Cancelled = true
 					e <- t
 				}()
+				continue
+			}
 
-				// Stop the ticker and exit the monitoring loop
+			if !detected || (notified != "" && terminationReasonSeverity[reason] <= terminationReasonSeverity[notified]) {
+				p.mu.Unlock()
+				continue
+			}
+			notified = reason
+
+			p.logger.Info("termination notice detected", "reason", reason, "provider", p.Name())
+
+			// Handle the notice in a separate goroutine but keep the mutex locked
+			// to prevent further ticker executions until it is sent
+			final := reason == TerminationReasonSpot
+			go func() {
+				defer p.mu.Unlock()
+				if final {
+					p.logger.Info("monitoring will be stopped and continue to handler", "provider", p.Name())
+				}
+
+				t := p.getInstanceMetadatas(ctx)
+				t.Reason = reason
+				e <- t
+			}()
+
+			// A preemption ends the instance, stop the ticker and exit the
+			// monitoring loop. Maintenance may still escalate or be withdrawn.
+			if final {
 				return
 			}
 
-			p.mu.Unlock()
+		case <-p.pollInterval.changed:
+			interval := p.pollInterval.get()
+			ticker.Reset(interval)
+			p.logger.Info("poll interval changed", "poll_interval", interval.String(), "provider", p.Name())
 
 		case <-ctx.Done():
 			return
@@ -105,26 +183,77 @@ func (p *GcpProvider) startMonitoring(ctx context.Context, e chan<- TerminationE
 	}
 }
 
-func (p *GcpProvider) isSpotTerminationDetected(ctx context.Context) (bool, error) {
+// detectNotice checks the watched signals, preemption first, and returns the
+// reason of the first notice issued
+func (p *GcpProvider) detectNotice(ctx context.Context) (TerminationReason, bool, error) {
+	var instance GcpResponseInstance
+
+	if p.hangingGet {
+		var err error
+		if instance, err = p.waitForInstanceChange(ctx); err != nil {
+			return "", false, err
+		}
+	} else {
+		if p.signals[ProviderSignalSpot] {
+			preempted, err := p.doMetadataRequest(ctx, GcpMetaDataPreemptedPath)
+			if err != nil {
+				return "", false, err
+			}
+			instance.Preempted = preempted
+		}
+		if p.signals[ProviderSignalMaintenance] {
+			maintenance, err := p.doMetadataRequest(ctx, GcpMetaDataMaintenancePath)
+			if err != nil {
+				return "", false, err
+			}
+			instance.MaintenanceEvent = maintenance
+		}
+	}
 
-	// Get spot instance action metadata
-	spotInfo, err := p.doMetadataRequest(ctx, GcpMetaDataSpotUrl)
+	if p.signals[ProviderSignalSpot] && strings.TrimSpace(instance.Preempted) == "TRUE" {
+		return TerminationReasonSpot, true, nil
+	}
+
+	// only a terminating maintenance, the instance survives a live migration
+	maintenance := strings.TrimSpace(instance.MaintenanceEvent)
+	if p.signals[ProviderSignalMaintenance] && maintenance == gcpMaintenanceTerminate {
+		p.logger.Debug("maintenance event found", "maintenance_event", maintenance, "provider", p.Name())
+		return TerminationReasonMaintenance, true, nil
+	}
+
+	p.logger.Debug("no termination notice detected", "provider", p.Name())
+	return "", false, nil
+}
+
+// waitForInstanceChange returns the instance directory once it changed since
+// the last request, or when the wait ends. The first request returns right away.
+func (p *GcpProvider) waitForInstanceChange(ctx context.Context) (GcpResponseInstance, error) {
+	var instance GcpResponseInstance
+
+	path := GcpMetaDataInstancePath
+	if p.etag != "" {
+		path += fmt.Sprintf("&wait_for_change=true&last_etag=%s&timeout_sec=%d", url.QueryEscape(p.etag), int(p.waitFor.Seconds()))
+	}
+
+	res, err := p.metadataResponse(ctx, path)
 	if err != nil {
-		return false, err
+		return instance, err
 	}
+	defer res.Body.Close()
 
-	if spotInfo == "FALSE" {
-		p.logger.Debug("no spot termination detected", "provider", p.Name())
+	if err := json.NewDecoder(res.Body).Decode(&instance); err != nil {
+		return instance, fmt.Errorf("failed to unmarshal instance metadata: %w", err)
 	}
+	p.etag = res.Header.Get("ETag")
 
-	return true, nil
+	return instance, nil
 }
 
 func (p *GcpProvider) getInstanceMetadatas(ctx context.Context) TerminationEvent {
 	var t TerminationEvent
 
 	// Get hostname - log error but continue
-	if hostname, err := p.doMetadataRequest(ctx, GcpMetaDataHostnameUrl); err != nil {
+	if hostname, err := p.doMetadataRequest(ctx, GcpMetaDataHostnamePath); err != nil {
 		p.logger.Error("failed to get hostname", "error", err.Error(), "provider", p.Name())
 		t.Hostname = "unknown"
 	} else {
@@ -132,7 +261,7 @@ func (p *GcpProvider) getInstanceMetadatas(ctx context.Context) TerminationEvent
 	}
 
 	// Get private IP - log error but continue
-	if privateIP, err := p.doMetadataRequest(ctx, GcpMetaDataLocalIpUrl); err != nil {
+	if privateIP, err := p.doMetadataRequest(ctx, GcpMetaDataLocalIpPath); err != nil {
 		p.logger.Error("failed to get private IP", "error", err.Error(), "provider", p.Name())
 		t.PrivateIP = "unknown"
 	} else {
@@ -140,42 +269,53 @@ func (p *GcpProvider) getInstanceMetadatas(ctx context.Context) TerminationEvent
 	}
 
 	// Get instance ID - log error but continue
-	if instanceID, err := p.doMetadataRequest(ctx, GcpMetaDataInstanceIdUrl); err != nil {
+	if instanceID, err := p.doMetadataRequest(ctx, GcpMetaDataInstanceIdPath); err != nil {
 		p.logger.Error("failed to get instance ID", "error", err.Error(), "provider", p.Name())
 		t.InstanceID = "unknown"
 	} else {
 		t.InstanceID = instanceID
 	}
 
-	t.Reason = TerminationReasonSpot
-
 	return t
 }
 
-func (p *GcpProvider) doMetadataRequest(ctx context.Context, url string) (string, error) {
+func (p *GcpProvider) doMetadataRequest(ctx context.Context, path string) (string, error) {
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	res, err := p.metadataResponse(ctx, path)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Metadata-Flavor", "Google")
+	defer res.Body.Close()
 
-	res, err := p.httpClient.Do(req)
+	body, err := io.ReadAll(res.Body)
 	if err != nil {
 		return "", err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("got %d as http request", res.StatusCode)
+	bodyStr := string(body)
+
+	return bodyStr, nil
+}
+
+// metadataResponse returns the successful response for path, the caller
+// closes its body
+func (p *GcpProvider) metadataResponse(ctx context.Context, path string) (*http.Response, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseUrl+path, nil)
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("Metadata-Flavor", "Google")
 
-	body, err := io.ReadAll(res.Body)
+	res, err := p.httpClient.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	bodyStr := string(body)
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("got %d as http request", res.StatusCode)
+	}
 
-	return bodyStr, nil
+	return res, nil
 }
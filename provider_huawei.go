@@ -12,38 +12,49 @@ import (
 
 // HuaweiProvider is an implementation of the Provider interface for Huawei.
 type HuaweiProvider struct {
-	httpClient *http.Client
-	logger     *slog.Logger
-	mu         sync.Mutex // protects against overlapping spot checks
+	httpClient   *http.Client
+	logger       *slog.Logger
+	pollInterval *pollInterval
+	baseUrl      string
+	token        *metadataToken
+	mu           sync.Mutex // protects against overlapping spot checks
 }
 
 const (
 	HuaweiMetaDataBaseUrl = "http://169.254.169.254"
 
 	// token endpoint
-	HuaweiMetaDataTokenUrl = HuaweiMetaDataBaseUrl + "/meta-data/latest/api/token"
+	HuaweiMetaDataTokenPath = "/meta-data/latest/api/token"
 
-	// huawei metadata endpoint
-	HuaweiMetaDataSpotUrl       = HuaweiMetaDataBaseUrl + "/openstack/latest/spot/instance-action"
-	HuaweiMetaDataHostnameUrl   = HuaweiMetaDataBaseUrl + "/latest/meta-data/hostname"
-	HuaweiMetaDataInstanceIdUrl = HuaweiMetaDataBaseUrl + "-"
-	HuaweiMetaDataLocalIpUrl    = HuaweiMetaDataBaseUrl + "/latest/meta-data/local-ipv4"
+	// huawei metadata endpoint, relative to the base url
+	HuaweiMetaDataSpotPath       = "/openstack/latest/spot/instance-action"
+	HuaweiMetaDataHostnamePath   = "/latest/meta-data/hostname"
+	HuaweiMetaDataInstanceIdPath = "-"
+	HuaweiMetaDataLocalIpPath    = "/latest/meta-data/local-ipv4"
 )
 
-func NewHuaweiProvider(client *http.Client, logger *slog.Logger) *HuaweiProvider {
+func NewHuaweiProvider(config *MetadataProviderConfig) *HuaweiProvider {
 	return &HuaweiProvider{
-		httpClient: client,
-		logger:     logger,
+		httpClient:   config.HttpClient,
+		logger:       config.Logger,
+		pollInterval: newPollInterval(config.PollInterval),
+		baseUrl:      config.endpoint(HuaweiMetaDataBaseUrl),
+		token:        newMetadataToken(config.TokenTTL),
 	}
 }
 
+// SetPollInterval changes the poll interval, also while monitoring
+func (p *HuaweiProvider) SetPollInterval(interval time.Duration) {
+	p.pollInterval.set(interval)
+}
+
 func (p *HuaweiProvider) Name() ProviderName {
 	return ProviderHuawei
 }
 
 func (p *HuaweiProvider) IsSupported(ctx context.Context) bool {
 
-	_, err := p.doMetadataRequest(ctx, HuaweiMetaDataHostnameUrl)
+	_, err := p.doMetadataRequest(ctx, HuaweiMetaDataHostnamePath)
 	if err != nil {
 		p.logger.Debug("fail to detect huawei provider", "error", err.Error(), "provider", p.Name())
 		return false
@@ -61,9 +72,7 @@ func (p *HuaweiProvider) StartMonitoring(ctx context.Context, e chan<- Terminati
 
 func (p *HuaweiProvider) startMonitoring(ctx context.Context, e chan<- TerminationEvent) {
 
-	config := GetProviderConfig()
-
-	ticker := time.NewTicker(config.PollInterval)
+	ticker := time.NewTicker(p.pollInterval.get())
 	defer ticker.Stop()
 
 	for {
@@ -102,6 +111,11 @@ func (p *HuaweiProvider) startMonitoring(ctx context.Context, e chan<- Terminati
 
 			p.mu.Unlock()
 
+		case <-p.pollInterval.changed:
+			interval := p.pollInterval.get()
+			ticker.Reset(interval)
+			p.logger.Info("poll interval changed", "poll_interval", interval.String(), "provider", p.Name())
+
 		case <-ctx.Done():
 			return
 		}
@@ -111,7 +125,7 @@ func (p *HuaweiProvider) startMonitoring(ctx context.Context, e chan<- Terminati
 func (p *HuaweiProvider) isSpotTerminationDetected(ctx context.Context) (bool, error) {
 
 	// Get spot instance action metadata
-	_, err := p.doMetadataRequest(ctx, HuaweiMetaDataSpotUrl)
+	_, err := p.doMetadataRequest(ctx, HuaweiMetaDataSpotPath)
 	if err != nil {
 		return false, err
 	}
@@ -123,7 +137,7 @@ func (p *HuaweiProvider) getInstanceMetadatas(ctx context.Context) TerminationEv
 	var t TerminationEvent
 
 	// Get hostname - log error but continue
-	if hostname, err := p.doMetadataRequest(ctx, HuaweiMetaDataHostnameUrl); err != nil {
+	if hostname, err := p.doMetadataRequest(ctx, HuaweiMetaDataHostnamePath); err != nil {
 		p.logger.Error("failed to get hostname", "error", err.Error(), "provider", p.Name())
 		t.Hostname = "unknown"
 	} else {
@@ -131,7 +145,7 @@ func (p *HuaweiProvider) getInstanceMetadatas(ctx context.Context) TerminationEv
 	}
 
 	// Get private IP - log error but continue
-	if privateIP, err := p.doMetadataRequest(ctx, HuaweiMetaDataLocalIpUrl); err != nil {
+	if privateIP, err := p.doMetadataRequest(ctx, HuaweiMetaDataLocalIpPath); err != nil {
 		p.logger.Error("failed to get private IP", "error", err.Error(), "provider", p.Name())
 		t.PrivateIP = "unknown"
 	} else {
@@ -145,15 +159,15 @@ func (p *HuaweiProvider) getInstanceMetadatas(ctx context.Context) TerminationEv
 	return t
 }
 
-func (p *HuaweiProvider) getMetadataToken(ctx context.Context) (string, error) {
+func (p *HuaweiProvider) getMetadataToken(ctx context.Context, ttlSeconds string) (string, error) {
 	// Get token for authentication next request
-	req, err := http.NewRequestWithContext(ctx, "PUT", HuaweiMetaDataTokenUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "PUT", p.baseUrl+HuaweiMetaDataTokenPath, nil)
 	if err != nil {
 		return "", err
 	}
 
 	// set header
-	req.Header.Set("X-Metadata-Token-Ttl-Seconds", "60")
+	req.Header.Set("X-Metadata-Token-Ttl-Seconds", ttlSeconds)
 
 	// Doing request for get token
 	res, err := p.httpClient.Do(req)
@@ -162,6 +176,10 @@ func (p *HuaweiProvider) getMetadataToken(ctx context.Context) (string, error) {
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got %d as token request", res.StatusCode)
+	}
+
 	// parse token
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -173,13 +191,13 @@ func (p *HuaweiProvider) getMetadataToken(ctx context.Context) (string, error) {
 	return token, nil
 }
 
-func (p *HuaweiProvider) doMetadataRequest(ctx context.Context, url string) (string, error) {
-	token, err := p.getMetadataToken(ctx)
+func (p *HuaweiProvider) doMetadataRequest(ctx context.Context, path string) (string, error) {
+	token, err := p.token.get(ctx, p.getMetadataToken)
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseUrl+path, nil)
 	if err != nil {
 		return "", err
 	}
@@ -191,6 +209,10 @@ func (p *HuaweiProvider) doMetadataRequest(ctx context.Context, url string) (str
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusUnauthorized {
+		// expired or rejected, the next request gets a new one
+		p.token.invalidate()
+	}
 	if res.StatusCode != http.StatusOK {
 		return "", fmt.Errorf("got %d as http request", res.StatusCode)
 	}
@@ -12,35 +12,44 @@ import (
 
 // TencentProvider is an implementation of the Provider interface for Tencent.
 type TencentProvider struct {
-	httpClient *http.Client
-	logger     *slog.Logger
-	mu         sync.Mutex // protects against overlapping spot checks
+	httpClient   *http.Client
+	logger       *slog.Logger
+	pollInterval *pollInterval
+	baseUrl      string
+	mu           sync.Mutex // protects against overlapping spot checks
 }
 
 const (
 	TencentMetaDataBaseUrl = "http://metadata.tencentyun.com/latest"
 
-	// tencent metadata endpoint
-	TencentMetaDataSpotUrl       = TencentMetaDataBaseUrl + "/meta-data/instance/spot/termination-time"
-	TencentMetaDataHostnameUrl   = TencentMetaDataBaseUrl + "/meta-data/hostname"
-	TencentMetaDataInstanceIdUrl = TencentMetaDataBaseUrl + "/meta-data/instance-id"
-	TencentMetaDataLocalIpUrl    = TencentMetaDataBaseUrl + "/meta-data/local-ipv4"
+	// tencent metadata endpoint, relative to the base url
+	TencentMetaDataSpotPath       = "/meta-data/instance/spot/termination-time"
+	TencentMetaDataHostnamePath   = "/meta-data/hostname"
+	TencentMetaDataInstanceIdPath = "/meta-data/instance-id"
+	TencentMetaDataLocalIpPath    = "/meta-data/local-ipv4"
 )
 
-func NewTencentProvider(client *http.Client, logger *slog.Logger) *TencentProvider {
+func NewTencentProvider(config *MetadataProviderConfig) *TencentProvider {
 	return &TencentProvider{
-		httpClient: client,
-		logger:     logger,
+		httpClient:   config.HttpClient,
+		logger:       config.Logger,
+		pollInterval: newPollInterval(config.PollInterval),
+		baseUrl:      config.endpoint(TencentMetaDataBaseUrl),
 	}
 }
 
+// SetPollInterval changes the poll interval, also while monitoring
+func (p *TencentProvider) SetPollInterval(interval time.Duration) {
+	p.pollInterval.set(interval)
+}
+
 func (p *TencentProvider) Name() ProviderName {
 	return ProviderTencent
 }
 
 func (p *TencentProvider) IsSupported(ctx context.Context) bool {
 
-	_, err := p.doMetadataRequest(ctx, TencentMetaDataHostnameUrl)
+	_, err := p.doMetadataRequest(ctx, TencentMetaDataHostnamePath)
 	if err != nil {
 		p.logger.Debug("fail to detect tencent provider", "error", err.Error(), "provider", p.Name())
 		return false
@@ -58,9 +67,7 @@ func (p *TencentProvider) StartMonitoring(ctx context.Context, e chan<- Terminat
 
 func (p *TencentProvider) startMonitoring(ctx context.Context, e chan<- TerminationEvent) {
 
-	config := GetProviderConfig()
-
-	ticker := time.NewTicker(config.PollInterval)
+	ticker := time.NewTicker(p.pollInterval.get())
 	defer ticker.Stop()
 
 	for {
@@ -99,6 +106,11 @@ func (p *TencentProvider) startMonitoring(ctx context.Context, e chan<- Terminat
 
 			p.mu.Unlock()
 
+		case <-p.pollInterval.changed:
+			interval := p.pollInterval.get()
+			ticker.Reset(interval)
+			p.logger.Info("poll interval changed", "poll_interval", interval.String(), "provider", p.Name())
+
 		case <-ctx.Done():
 			return
 		}
@@ -108,7 +120,7 @@ func (p *TencentProvider) startMonitoring(ctx context.Context, e chan<- Terminat
 func (p *TencentProvider) isSpotTerminationDetected(ctx context.Context) (bool, error) {
 
 	// Get spot instance action metadata
-	_, err := p.doMetadataRequest(ctx, TencentMetaDataSpotUrl)
+	_, err := p.doMetadataRequest(ctx, TencentMetaDataSpotPath)
 	if err != nil {
 		return false, err
 	}
@@ -120,7 +132,7 @@ func (p *TencentProvider) getInstanceMetadatas(ctx context.Context) TerminationE
 	var t TerminationEvent
 
 	// Get hostname - log error but continue
-	if hostname, err := p.doMetadataRequest(ctx, TencentMetaDataHostnameUrl); err != nil {
+	if hostname, err := p.doMetadataRequest(ctx, TencentMetaDataHostnamePath); err != nil {
 		p.logger.Error("failed to get hostname", "error", err.Error(), "provider", p.Name())
 		t.Hostname = "unknown"
 	} else {
@@ -128,7 +140,7 @@ func (p *TencentProvider) getInstanceMetadatas(ctx context.Context) TerminationE
 	}
 
 	// Get private IP - log error but continue
-	if privateIP, err := p.doMetadataRequest(ctx, TencentMetaDataLocalIpUrl); err != nil {
+	if privateIP, err := p.doMetadataRequest(ctx, TencentMetaDataLocalIpPath); err != nil {
 		p.logger.Error("failed to get private IP", "error", err.Error(), "provider", p.Name())
 		t.PrivateIP = "unknown"
 	} else {
@@ -136,7 +148,7 @@ func (p *TencentProvider) getInstanceMetadatas(ctx context.Context) TerminationE
 	}
 
 	// Get instance ID - log error but continue
-	if instanceID, err := p.doMetadataRequest(ctx, TencentMetaDataInstanceIdUrl); err != nil {
+	if instanceID, err := p.doMetadataRequest(ctx, TencentMetaDataInstanceIdPath); err != nil {
 		p.logger.Error("failed to get instance ID", "error", err.Error(), "provider", p.Name())
 		t.InstanceID = "unknown"
 	} else {
@@ -148,9 +160,9 @@ func (p *TencentProvider) getInstanceMetadatas(ctx context.Context) TerminationE
 	return t
 }
 
-func (p *TencentProvider) doMetadataRequest(ctx context.Context, url string) (string, error) {
+func (p *TencentProvider) doMetadataRequest(ctx context.Context, path string) (string, error) {
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseUrl+path, nil)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,311 @@
+package evacuator
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// handlerSet is the handlers of one configuration. A reload replaces the set,
+// the handlers it drops are closed once no handling uses the old set anymore.
+type handlerSet struct {
+	// configured handlers with their spec, by name
+	registered map[string]registeredHandler
+
+	// configured handlers, or the agent publisher, and the WithHandler ones
+	handlers []Handler
+
+	// handling in progress using the set, guarded by Evacuator.mu
+	refs    int
+	retired bool
+	dropped []Handler
+}
+
+// Status reports what an evacuator is running with
+type Status struct {
+	Provider string       `json:"provider,omitempty"`
+	Handlers []string     `json:"handlers"`
+	Reload   ReloadStatus `json:"reload"`
+}
+
+// ReloadStatus reports the configuration reloads of an evacuator
+type ReloadStatus struct {
+	// Generation counts the applied configurations, 1 for the initial one
+	Generation int       `json:"generation"`
+	AppliedAt  time.Time `json:"applied_at"`
+
+	// LastAttemptAt is the last reload, LastError is empty when it was applied
+	LastAttemptAt time.Time `json:"last_attempt_at,omitzero"`
+	LastError     string    `json:"last_error,omitempty"`
+
+	// RestartRequired lists the changed sections that keep their running
+	// values until a restart
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// newHandlerSet creates the handlers of the configuration, keeping the
+// configured handlers of previous whose spec did not change
+func (e *Evacuator) newHandlerSet(config *Config, previous *handlerSet) (*handlerSet, error) {
+	set := &handlerSet{
+		registered: make(map[string]registeredHandler),
+	}
+
+	if config.Cluster.Mode == ClusterModeAgent {
+		// the cluster section only changes on a restart, the publisher is kept
+		if previous != nil {
+			set.handlers = previous.handlers
+			return set, nil
+		}
+
+		agent, err := newClusterAgentHandler(config, e.logger)
+		if err != nil {
+			return nil, err
+		}
+		set.handlers = append([]Handler{agent}, e.extraHandlers...)
+		return set, nil
+	}
+
+	registry := NewHandlerRegistry(e.logger, config)
+	for name, factory := range e.factories {
+		registry.RegisterFactory(name, factory)
+	}
+
+	var kept map[string]registeredHandler
+	if previous != nil {
+		kept = previous.registered
+	}
+
+	// Register all configured handlers
+	registered, err := registry.registerHandlers(kept)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register handlers: %w", err)
+	}
+
+	for _, h := range registered {
+		set.registered[h.name] = h
+		set.handlers = append(set.handlers, h.handler)
+	}
+	set.handlers = append(set.handlers, e.extraHandlers...)
+
+	return set, nil
+}
+
+// acquireHandlers returns the current handlers, release is called once the
+// handling using them is done
+func (e *Evacuator) acquireHandlers() ([]Handler, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	set := e.handlers
+	set.refs++
+
+	return set.handlers, func() {
+		e.mu.Lock()
+		set.refs--
+		closing := set.retired && set.refs == 0
+		e.mu.Unlock()
+
+		if closing {
+			closeHandlers(set.dropped)
+		}
+	}
+}
+
+// startBackground starts the background work of the handlers not running it
+// yet, once Run started. It is called with mu held.
+func (e *Evacuator) startBackground(handlers []Handler) {
+	if e.runCtx == nil {
+		return
+	}
+
+	for _, handler := range handlers {
+		background, ok := handler.(BackgroundHandler)
+		if !ok {
+			continue
+		}
+		if _, running := e.background[handler.Name()]; running {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(e.runCtx)
+		e.background[handler.Name()] = cancel
+		go background.Run(ctx)
+	}
+}
+
+// Reload reads the configuration again and applies it without stopping the
+// handling in progress, which finishes with the handlers it started with.
+// Handlers are rebuilt when their section changed, the poll interval, the
+// processing timeout, the recovery grace period and the dedup window apply
+// right away. Changes to other sections need a restart. A configuration that
+// fails to load, validate or create its handlers is rejected and the running
+// one kept. Both outcomes are logged and reported by Status.
+func (e *Evacuator) Reload() error {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	if e.load == nil {
+		return e.rejectReload(fmt.Errorf("no configuration source to reload from"))
+	}
+
+	config, err := e.load()
+	if err != nil {
+		return e.rejectReload(err)
+	}
+
+	return e.apply(config)
+}
+
+// apply replaces the running configuration with config
+func (e *Evacuator) apply(config *Config) error {
+	old := e.currentConfig()
+	logConfigWarnings(config, e.logger)
+
+	restartRequired := restartOnlyChanges(old, config)
+	if len(restartRequired) > 0 {
+		e.logger.Warn("configuration changes need a restart, keeping their running values", "sections", restartRequired)
+		keepRestartOnly(old, config)
+	}
+
+	e.mu.Lock()
+	previous := e.handlers
+	e.mu.Unlock()
+
+	set, err := e.newHandlerSet(config, previous)
+	if err != nil {
+		return e.rejectReload(err)
+	}
+
+	// configured handlers of the previous set not kept by the new one
+	var dropped []Handler
+	for name, h := range previous.registered {
+		if kept, ok := set.registered[name]; !ok || !reflect.DeepEqual(kept.spec, h.spec) {
+			dropped = append(dropped, h.handler)
+		}
+	}
+
+	e.mu.Lock()
+	e.config = config
+	e.handlers = set
+	provider := e.provider
+
+	for _, handler := range dropped {
+		if cancel, ok := e.background[handler.Name()]; ok {
+			cancel()
+			delete(e.background, handler.Name())
+		}
+	}
+	e.startBackground(set.handlers)
+
+	previous.retired = true
+	previous.dropped = dropped
+	closing := previous.refs == 0
+
+	e.status = ReloadStatus{
+		Generation:      e.status.Generation + 1,
+		AppliedAt:       time.Now(),
+		LastAttemptAt:   time.Now(),
+		RestartRequired: restartRequired,
+	}
+	generation := e.status.Generation
+	e.mu.Unlock()
+
+	if closing {
+		closeHandlers(dropped)
+	}
+
+	e.dedup.SetWindow(config.Handler.DedupWindow)
+
+	if setter, ok := provider.(PollIntervalSetter); ok {
+		setter.SetPollInterval(config.Provider.MetadataProviderConfig(provider.Name()).PollInterval)
+	}
+
+	e.logger.Info("configuration reloaded", "generation", generation, "handlers", len(set.handlers), "replaced_handlers", len(dropped))
+	return nil
+}
+
+// rejectReload records the failed reload and returns its error
+func (e *Evacuator) rejectReload(err error) error {
+	e.mu.Lock()
+	e.status.LastAttemptAt = time.Now()
+	e.status.LastError = err.Error()
+	e.mu.Unlock()
+
+	e.logger.Error("configuration reload rejected, keeping the running configuration", "error", err.Error())
+	return fmt.Errorf("configuration reload rejected: %w", err)
+}
+
+// Status returns the detected provider, the handlers and the reload status
+func (e *Evacuator) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status := Status{
+		Handlers: []string{},
+		Reload:   e.status,
+	}
+	if e.provider != nil {
+		status.Provider = string(e.provider.Name())
+	}
+	for _, handler := range e.handlers.handlers {
+		status.Handlers = append(status.Handlers, handler.Name())
+	}
+
+	return status
+}
+
+// restartOnlyChanges returns the changed sections that cannot be applied
+// while running, the provider only with its poll intervals left out
+func restartOnlyChanges(old *Config, config *Config) []string {
+	provider := config.Provider
+	oldProvider := withPollIntervals(old.Provider, provider)
+
+	sections := []struct {
+		name    string
+		changed bool
+	}{
+		{"node_name", old.NodeName != config.NodeName},
+		{"provider", !reflect.DeepEqual(oldProvider, provider)},
+		{"log", !reflect.DeepEqual(old.Log, config.Log)},
+		{"control", !reflect.DeepEqual(old.Control, config.Control)},
+		{"cluster", !reflect.DeepEqual(old.Cluster, config.Cluster)},
+		{"state", !reflect.DeepEqual(old.State, config.State)},
+		{"reload", !reflect.DeepEqual(old.Reload, config.Reload)},
+	}
+
+	var changed []string
+	for _, section := range sections {
+		if section.changed {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
+
+// keepRestartOnly copies the sections that cannot be applied while running
+// from old to config
+func keepRestartOnly(old *Config, config *Config) {
+	provider := withPollIntervals(old.Provider, config.Provider)
+
+	config.NodeName = old.NodeName
+	config.Provider = provider
+	config.Log = old.Log
+	config.Control = old.Control
+	config.Cluster = old.Cluster
+	config.State = old.State
+	config.Reload = old.Reload
+}
+
+// withPollIntervals returns provider with the poll intervals of from, the
+// provider settings applied while running
+func withPollIntervals(provider ProviderConfig, from ProviderConfig) ProviderConfig {
+	provider.PollIntervalRaw, provider.PollInterval = from.PollIntervalRaw, from.PollInterval
+
+	for _, name := range metadataProviders {
+		section, fromSection := provider.metadataSection(name), from.metadataSection(name)
+		section.PollIntervalRaw, section.PollInterval = fromSection.PollIntervalRaw, fromSection.PollInterval
+	}
+
+	return provider
+}
@@ -0,0 +1,470 @@
+package evacuator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+const (
+	VaultAuthToken      = "token"
+	VaultAuthKubernetes = "kubernetes"
+
+	// VaultKubernetesTokenPath is the service account token presented to the
+	// kubernetes auth method
+	VaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// VaultRequestTimeout bounds every request to Vault while loading the configuration
+	VaultRequestTimeout = 10 * time.Second
+
+	// RedactedValue replaces the secrets of a redacted configuration
+	RedactedValue = "REDACTED"
+)
+
+// SecretsConfig configures where secret references in the configuration are
+// read from
+type SecretsConfig struct {
+	Vault VaultConfig `mapstructure:"vault"`
+}
+
+// VaultConfig configures reading ${vault:path#key} references from a KV v2
+// secrets engine
+type VaultConfig struct {
+	Address    string                `mapstructure:"address"`
+	Namespace  string                `mapstructure:"namespace"`
+	CAFile     string                `mapstructure:"ca_file"`
+	KVMount    string                `mapstructure:"kv_mount"`
+	Auth       string                `mapstructure:"auth"`
+	Token      string                `mapstructure:"token"`
+	TokenFile  string                `mapstructure:"token_file"`
+	Kubernetes VaultKubernetesConfig `mapstructure:"kubernetes"`
+}
+
+type VaultKubernetesConfig struct {
+	Role      string `mapstructure:"role"`
+	Mount     string `mapstructure:"mount"`
+	TokenPath string `mapstructure:"token_path"`
+}
+
+// secretField is a configuration value that should not be written in
+// plaintext, with the path of the file it can be read from instead
+type secretField struct {
+	key   string
+	value *string
+	file  *string
+}
+
+// secretFields returns every secret field of the configuration
+func (c *Config) secretFields() []secretField {
+	fields := []secretField{
+		{"cluster.token", &c.Cluster.Token, &c.Cluster.TokenFile},
+	}
+	fields = append(fields, c.Handler.secretFields()...)
+	return append(fields, c.Secrets.secretFields()...)
+}
+
+func (h *HandlerConfig) secretFields() []secretField {
+	return []secretField{
+		{"handler.telegram.bot_token", &h.Telegram.BotToken, &h.Telegram.BotTokenFile},
+		{"handler.consul.token", &h.Consul.Token, &h.Consul.TokenFile},
+	}
+}
+
+func (s *SecretsConfig) secretFields() []secretField {
+	return []secretField{
+		{"secrets.vault.token", &s.Vault.Token, &s.Vault.TokenFile},
+	}
+}
+
+// secretSettingKeys are the keys of instance settings redacted in dumps
+var secretSettingKeys = map[string]bool{
+	"token":     true,
+	"bot_token": true,
+}
+
+// resolveSecretFiles reads the fields with a file set. The file takes
+// precedence over the value and is cleared, so the value is what the
+// handlers and instance settings see.
+func resolveSecretFiles(fields []secretField, is *configIssues) {
+	for _, field := range fields {
+		if *field.file == "" {
+			continue
+		}
+
+		value, err := readSecretFile(*field.file)
+		if err != nil {
+			is.errorf(field.key+"_file", "%v", err)
+			continue
+		}
+		*field.value = value
+		*field.file = ""
+	}
+}
+
+// readSecretFile reads a secret mounted as a file, without the trailing
+// newline most tools write
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Redacted returns a copy of the configuration with every secret replaced by
+// RedactedValue, for dumps and logs. Instance settings named like a secret
+// field and plugin environment values are redacted as well.
+func (c Config) Redacted() Config {
+	for _, field := range c.secretFields() {
+		if *field.value != "" {
+			*field.value = RedactedValue
+		}
+	}
+
+	instances := make([]HandlerInstanceConfig, len(c.Handler.Instances))
+	for i, instance := range c.Handler.Instances {
+		instance.Settings = redactSettings(instance.Settings)
+		instances[i] = instance
+	}
+	c.Handler.Instances = instances
+
+	plugins := make([]PluginConfig, len(c.Handler.Plugins))
+	for i, plugin := range c.Handler.Plugins {
+		env := make([]string, len(plugin.Env))
+		for j, variable := range plugin.Env {
+			name, _, _ := strings.Cut(variable, "=")
+			env[j] = name + "=" + RedactedValue
+		}
+		plugin.Env = env
+		plugins[i] = plugin
+	}
+	c.Handler.Plugins = plugins
+
+	return c
+}
+
+// redactSettings returns a copy of settings with the secret keys redacted
+func redactSettings(settings map[string]interface{}) map[string]interface{} {
+	if settings == nil {
+		return nil
+	}
+
+	out := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if secretSettingKeys[key] {
+			out[key] = RedactedValue
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			out[key] = redactSettings(nested)
+			continue
+		}
+		out[key] = value
+	}
+	return out
+}
+
+// Dump returns the redacted configuration keyed like the config file
+func (c Config) Dump() (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	if err := mapstructure.Decode(c.Redacted(), &out); err != nil {
+		return nil, err
+	}
+	return dumpValue(out).(map[string]interface{}), nil
+}
+
+// dumpValue converts the structs left in slices by mapstructure, e.g. the
+// handler instances, to maps keyed by their tags
+func dumpValue(value interface{}) interface{} {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Map:
+		if m, ok := value.(map[string]interface{}); ok {
+			for key, v := range m {
+				m[key] = dumpValue(v)
+			}
+		}
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() != reflect.Struct {
+			return value
+		}
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			m := make(map[string]interface{})
+			if err := mapstructure.Decode(rv.Index(i).Interface(), &m); err != nil {
+				return value
+			}
+			out[i] = dumpValue(m)
+		}
+		return out
+	}
+	return value
+}
+
+// LogValue logs the redacted configuration
+func (c Config) LogValue() slog.Value {
+	dump, err := c.Dump()
+	if err != nil {
+		return slog.StringValue(RedactedValue)
+	}
+	return slog.AnyValue(dump)
+}
+
+// secretReferencePattern matches ${env:NAME}, ${file:PATH} and
+// ${vault:PATH#KEY}, a leading $$ escapes the reference
+var secretReferencePattern = regexp.MustCompile(`\$?\$\{(env|file|vault):([^}]*)\}`)
+
+// secretResolver resolves the references in configuration values
+type secretResolver struct {
+	// vault is nil while the secrets section itself is decoded, vault
+	// references are then left as they are
+	vault     *VaultConfig
+	client    *vaultClient
+	clientErr error
+}
+
+func newSecretResolver(vault *VaultConfig) *secretResolver {
+	return &secretResolver{vault: vault}
+}
+
+// secretDecodeHook expands the references of every string decoded by viper,
+// followed by the default viper hooks
+func secretDecodeHook(r *secretResolver) mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+			if from.Kind() != reflect.String {
+				return data, nil
+			}
+			return r.expand(data.(string))
+		},
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)
+}
+
+// expand replaces the references in s, errors name the reference but never
+// the resolved value
+func (r *secretResolver) expand(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var expandErr error
+	expanded := secretReferencePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+
+		match := secretReferencePattern.FindStringSubmatch(ref)
+		value, err := r.resolve(match[1], match[2])
+		if err != nil {
+			if expandErr == nil {
+				expandErr = fmt.Errorf("%s: %w", ref, err)
+			}
+			return ref
+		}
+		return value
+	})
+
+	return expanded, expandErr
+}
+
+func (r *secretResolver) resolve(source string, ref string) (string, error) {
+	switch source {
+	case "env":
+		value, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", ref)
+		}
+		return value, nil
+	case "file":
+		return readSecretFile(ref)
+	default:
+		if r.vault == nil {
+			return "${vault:" + ref + "}", nil
+		}
+		// a failed login is reported for every reference without retrying it
+		if r.client == nil && r.clientErr == nil {
+			r.client, r.clientErr = newVaultClient(r.vault)
+		}
+		if r.clientErr != nil {
+			return "", r.clientErr
+		}
+		return r.client.read(ref)
+	}
+}
+
+// vaultClient reads KV v2 secrets, it lives for one configuration load and
+// reads every secret path once
+type vaultClient struct {
+	config     VaultConfig
+	httpClient *http.Client
+	token      string
+	secrets    map[string]map[string]interface{}
+}
+
+type vaultResponse struct {
+	Errors []string `json:"errors"`
+	Auth   struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+func newVaultClient(config *VaultConfig) (*vaultClient, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("secrets.vault.address must be set to read vault references")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.CAFile != "" {
+		ca, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secrets.vault.ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("secrets.vault.ca_file contains no certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	c := &vaultClient{
+		config:     *config,
+		httpClient: &http.Client{Transport: transport, Timeout: VaultRequestTimeout},
+		secrets:    make(map[string]map[string]interface{}),
+	}
+
+	if err := c.login(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// login gets the token of the configured auth method
+func (c *vaultClient) login() error {
+	switch c.config.Auth {
+	case VaultAuthToken:
+		if c.config.Token == "" {
+			return fmt.Errorf("secrets.vault.token or secrets.vault.token_file must be set for the %s auth", VaultAuthToken)
+		}
+		c.token = c.config.Token
+		return nil
+	case VaultAuthKubernetes:
+		kubernetes := c.config.Kubernetes
+		if kubernetes.Role == "" {
+			return fmt.Errorf("secrets.vault.kubernetes.role must be set for the %s auth", VaultAuthKubernetes)
+		}
+
+		jwt, err := readSecretFile(kubernetes.TokenPath)
+		if err != nil {
+			return fmt.Errorf("secrets.vault.kubernetes.token_path: %w", err)
+		}
+
+		var res vaultResponse
+		body := map[string]string{"role": kubernetes.Role, "jwt": jwt}
+		if err := c.do("POST", "auth/"+strings.Trim(kubernetes.Mount, "/")+"/login", body, &res); err != nil {
+			return fmt.Errorf("vault kubernetes login failed: %w", err)
+		}
+		if res.Auth.ClientToken == "" {
+			return fmt.Errorf("vault kubernetes login returned no token")
+		}
+		c.token = res.Auth.ClientToken
+		return nil
+	default:
+		return fmt.Errorf("secrets.vault.auth must be %s or %s", VaultAuthToken, VaultAuthKubernetes)
+	}
+}
+
+// read returns the key of the secret at path, ref is path#key
+func (c *vaultClient) read(ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("vault references must be ${vault:path#key}")
+	}
+
+	data, ok := c.secrets[path]
+	if !ok {
+		var res vaultResponse
+		if err := c.do("GET", strings.Trim(c.config.KVMount, "/")+"/data/"+strings.Trim(path, "/"), nil, &res); err != nil {
+			return "", fmt.Errorf("failed to read vault secret %s: %w", path, err)
+		}
+		data = res.Data.Data
+		c.secrets[path] = data
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func (c *vaultClient) do(method string, path string, body interface{}, out *vaultResponse) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), VaultRequestTimeout)
+	defer cancel()
+
+	endpoint, err := url.JoinPath(c.config.Address, "v1", path)
+	if err != nil {
+		return fmt.Errorf("invalid secrets.vault.address: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.config.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to decode vault response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %d from vault: %s", res.StatusCode, strings.Join(out.Errors, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,512 @@
+package evacuator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// StateBackendFile keeps the state in a local file, e.g. on a hostPath
+	StateBackendFile = "file"
+
+	// StateBackendConfigMap keeps the state of all nodes in one ConfigMap
+	StateBackendConfigMap = "configmap"
+
+	// StateBackendAnnotation keeps the state of a node in an annotation on it
+	StateBackendAnnotation = "annotation"
+
+	// StateAnnotation holds the event states of a node with the annotation backend
+	StateAnnotation = "evacuator.io/state"
+
+	HandlerStateCompleted = "completed"
+	HandlerStateFailed    = "failed"
+)
+
+// EventState is the progress of the handling of one notice
+type EventState struct {
+	Key        string                  `json:"key"`
+	InstanceID string                  `json:"instance_id"`
+	Hostname   string                  `json:"hostname"`
+	Reason     TerminationReason       `json:"reason"`
+	Deadline   time.Time               `json:"deadline,omitzero"`
+	ReceivedAt time.Time               `json:"received_at"`
+	UpdatedAt  time.Time               `json:"updated_at"`
+	Handlers   map[string]HandlerState `json:"handlers"`
+}
+
+// HandlerState is the last result of a handler for the notice
+type HandlerState struct {
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// NewEventState returns the state of a notice no handler ran for yet
+func NewEventState(event TerminationEvent) *EventState {
+	now := time.Now().UTC()
+	return &EventState{
+		Key:        event.Key,
+		InstanceID: event.InstanceID,
+		Hostname:   event.Hostname,
+		Reason:     event.Reason,
+		Deadline:   event.Deadline,
+		ReceivedAt: now,
+		UpdatedAt:  now,
+		Handlers:   make(map[string]HandlerState),
+	}
+}
+
+// SameNotice reports whether the event is the notice of the state, a
+// different key, e.g. an escalated reason, or a different deadline is a new
+// notice for the same instance
+func (s *EventState) SameNotice(event TerminationEvent) bool {
+	if s.Key != event.Key {
+		return false
+	}
+	return s.Deadline.IsZero() || event.Deadline.IsZero() || s.Deadline.Equal(event.Deadline)
+}
+
+// Completed reports whether the handler already handled the notice successfully
+func (s *EventState) Completed(handler string) bool {
+	return s.Handlers[handler].Status == HandlerStateCompleted
+}
+
+// Record stores the result of a handler
+func (s *EventState) Record(handler string, err error, processedAt time.Time) {
+	state := HandlerState{
+		Status:      HandlerStateCompleted,
+		ProcessedAt: processedAt.UTC(),
+	}
+	if err != nil {
+		state.Status = HandlerStateFailed
+		state.Error = err.Error()
+	}
+
+	if s.Handlers == nil {
+		s.Handlers = make(map[string]HandlerState)
+	}
+	s.Handlers[handler] = state
+	s.UpdatedAt = time.Now().UTC()
+}
+
+// StateStore persists the progress of notices across restarts, so the
+// handlers that completed a notice are not run for it again
+type StateStore interface {
+	// Load returns the state of the notice of the instance, nil when unknown
+	Load(ctx context.Context, event TerminationEvent) (*EventState, error)
+
+	// Save stores the state of the notice of the instance
+	Save(ctx context.Context, event TerminationEvent, state *EventState) error
+
+	// Delete forgets the instance, e.g. once it recovered
+	Delete(ctx context.Context, event TerminationEvent) error
+}
+
+type StateStoreConfig struct {
+	Backend string
+
+	// Path of the state file of the file backend
+	Path string
+
+	// Namespace and ConfigMap of the configmap backend
+	Namespace string
+	ConfigMap string
+
+	InCluster  bool
+	Kubeconfig string
+
+	// NodeName is the node of the annotation backend, the hostname of the
+	// event when empty, e.g. for a controller handling every node
+	NodeName string
+
+	// TTL is how long states are kept after their last update
+	TTL time.Duration
+}
+
+// NewStateStore creates the store of the configured backend, nil when no
+// backend is configured
+func NewStateStore(config *StateStoreConfig) (StateStore, error) {
+	switch config.Backend {
+	case "":
+		return nil, nil
+	case StateBackendFile:
+		return NewFileStateStore(config.Path, config.TTL), nil
+	case StateBackendConfigMap, StateBackendAnnotation:
+		clientset, err := newKubernetesClientset(config.InCluster, config.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		if config.Backend == StateBackendConfigMap {
+			return NewConfigMapStateStore(clientset, config.Namespace, config.ConfigMap, config.TTL), nil
+		}
+		return NewAnnotationStateStore(clientset, config.NodeName, config.TTL), nil
+	default:
+		return nil, fmt.Errorf("unknown state backend: %s", config.Backend)
+	}
+}
+
+// InstanceKey identifies the instance of the event, the instance ID or the
+// hostname when the provider could not tell the instance ID. State stores
+// keep one state per instance.
+func InstanceKey(event TerminationEvent) string {
+	if knownInstanceID(event.InstanceID) {
+		return event.InstanceID
+	}
+	return event.Hostname
+}
+
+// knownInstanceID reports whether id identifies an instance, providers set
+// "unknown" when the metadata does not tell
+func knownInstanceID(id string) bool {
+	return id != "" && id != "unknown"
+}
+
+// pruneStates drops the states not updated within the ttl
+func pruneStates(states map[string]*EventState, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	for key, state := range states {
+		if time.Since(state.UpdatedAt) > ttl {
+			delete(states, key)
+		}
+	}
+}
+
+// FileStateStore keeps the states in a JSON file, it survives restarts of
+// the process and, on a hostPath, of the pod
+type FileStateStore struct {
+	path string
+	ttl  time.Duration
+	mu   sync.Mutex
+}
+
+func NewFileStateStore(path string, ttl time.Duration) *FileStateStore {
+	return &FileStateStore{
+		path: path,
+		ttl:  ttl,
+	}
+}
+
+func (s *FileStateStore) Load(ctx context.Context, event TerminationEvent) (*EventState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return states[InstanceKey(event)], nil
+}
+
+func (s *FileStateStore) Save(ctx context.Context, event TerminationEvent, state *EventState) error {
+	return s.update(func(states map[string]*EventState) {
+		states[InstanceKey(event)] = state
+	})
+}
+
+func (s *FileStateStore) Delete(ctx context.Context, event TerminationEvent) error {
+	return s.update(func(states map[string]*EventState) {
+		delete(states, InstanceKey(event))
+	})
+}
+
+func (s *FileStateStore) update(fn func(states map[string]*EventState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	fn(states)
+	pruneStates(states, s.ttl)
+
+	return s.write(states)
+}
+
+// read returns the states in the file, none when it does not exist yet
+func (s *FileStateStore) read() (map[string]*EventState, error) {
+	states := make(map[string]*EventState)
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state file %s: %w", s.path, err)
+	}
+	return states, nil
+}
+
+// write replaces the file through a rename, so a crash never leaves it half written
+func (s *FileStateStore) write(states map[string]*EventState) error {
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// configMapStateKeyInvalid matches the characters not allowed in ConfigMap keys
+var configMapStateKeyInvalid = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// ConfigMapStateStore keeps the states of all nodes in one ConfigMap, one key
+// per instance. It also survives the replacement of the node, e.g. for a
+// cluster controller moving to another node.
+type ConfigMapStateStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+	ttl       time.Duration
+}
+
+func NewConfigMapStateStore(clientset kubernetes.Interface, namespace string, name string, ttl time.Duration) *ConfigMapStateStore {
+	return &ConfigMapStateStore{
+		clientset: clientset,
+		namespace: namespace,
+		name:      name,
+		ttl:       ttl,
+	}
+}
+
+func (s *ConfigMapStateStore) Load(ctx context.Context, event TerminationEvent) (*EventState, error) {
+	configMap, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state configmap: %w", err)
+	}
+
+	raw, ok := configMap.Data[s.key(event)]
+	if !ok {
+		return nil, nil
+	}
+
+	var state EventState
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state of %s: %w", s.key(event), err)
+	}
+	return &state, nil
+}
+
+func (s *ConfigMapStateStore) Save(ctx context.Context, event TerminationEvent, state *EventState) error {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return s.update(ctx, func(data map[string]string) {
+		data[s.key(event)] = string(raw)
+	})
+}
+
+func (s *ConfigMapStateStore) Delete(ctx context.Context, event TerminationEvent) error {
+	return s.update(ctx, func(data map[string]string) {
+		delete(data, s.key(event))
+	})
+}
+
+func (s *ConfigMapStateStore) key(event TerminationEvent) string {
+	return configMapStateKeyInvalid.ReplaceAllString(InstanceKey(event), "_")
+}
+
+// update changes the data of the ConfigMap, creating it when missing and
+// retrying when another node changed it in between
+func (s *ConfigMapStateStore) update(ctx context.Context, fn func(data map[string]string)) error {
+	retriable := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+
+	return retry.OnError(retry.DefaultBackoff, retriable, func() error {
+		configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+
+		configMap, err := configMaps.Get(ctx, s.name, v1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      s.name,
+					Namespace: s.namespace,
+				},
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to get state configmap: %w", err)
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		fn(configMap.Data)
+		s.prune(configMap.Data)
+
+		if create {
+			_, err = configMaps.Create(ctx, configMap, v1.CreateOptions{})
+		} else {
+			_, err = configMaps.Update(ctx, configMap, v1.UpdateOptions{})
+		}
+		return err
+	})
+}
+
+// prune drops the expired and unreadable states
+func (s *ConfigMapStateStore) prune(data map[string]string) {
+	for key, raw := range data {
+		var state EventState
+		if err := json.Unmarshal([]byte(raw), &state); err != nil || (s.ttl > 0 && time.Since(state.UpdatedAt) > s.ttl) {
+			delete(data, key)
+		}
+	}
+}
+
+// AnnotationStateStore keeps the states of a node in an annotation on the
+// node itself, so they are gone together with the node
+type AnnotationStateStore struct {
+	clientset kubernetes.Interface
+	nodeName  string
+	ttl       time.Duration
+}
+
+// NewAnnotationStateStore returns a store annotating nodeName, or the node
+// named like the hostname of the event when empty. The hostname from the
+// metadata service is often not the node name, e.g. on AWS, only a
+// controller gets events with the node name as hostname.
+func NewAnnotationStateStore(clientset kubernetes.Interface, nodeName string, ttl time.Duration) *AnnotationStateStore {
+	return &AnnotationStateStore{
+		clientset: clientset,
+		nodeName:  nodeName,
+		ttl:       ttl,
+	}
+}
+
+// node returns the name of the node holding the states of the event
+func (s *AnnotationStateStore) node(event TerminationEvent) string {
+	if s.nodeName != "" {
+		return s.nodeName
+	}
+	return event.Hostname
+}
+
+func (s *AnnotationStateStore) Load(ctx context.Context, event TerminationEvent) (*EventState, error) {
+	node, err := s.clientset.CoreV1().Nodes().Get(ctx, s.node(event), v1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubernetes node: %w", err)
+	}
+
+	states, err := s.states(node)
+	if err != nil {
+		return nil, err
+	}
+	return states[InstanceKey(event)], nil
+}
+
+func (s *AnnotationStateStore) Save(ctx context.Context, event TerminationEvent, state *EventState) error {
+	return s.update(ctx, s.node(event), func(states map[string]*EventState) {
+		states[InstanceKey(event)] = state
+	})
+}
+
+func (s *AnnotationStateStore) Delete(ctx context.Context, event TerminationEvent) error {
+	return s.update(ctx, s.node(event), func(states map[string]*EventState) {
+		delete(states, InstanceKey(event))
+	})
+}
+
+func (s *AnnotationStateStore) states(node *corev1.Node) (map[string]*EventState, error) {
+	states := make(map[string]*EventState)
+
+	raw, ok := node.Annotations[StateAnnotation]
+	if !ok {
+		return states, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &states); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s annotation of node %s: %w", StateAnnotation, node.Name, err)
+	}
+	return states, nil
+}
+
+// update patches the annotation with the resource version of the read node,
+// so concurrent changes conflict instead of being lost
+func (s *AnnotationStateStore) update(ctx context.Context, nodeName string, fn func(states map[string]*EventState)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := s.clientset.CoreV1().Nodes().Get(ctx, nodeName, v1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get kubernetes node: %w", err)
+		}
+
+		states, err := s.states(node)
+		if err != nil {
+			return err
+		}
+
+		fn(states)
+		pruneStates(states, s.ttl)
+
+		// a nil value removes the annotation with a merge patch
+		var value any
+		if len(states) > 0 {
+			raw, err := json.Marshal(states)
+			if err != nil {
+				return err
+			}
+			value = string(raw)
+		}
+
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"resourceVersion": node.ResourceVersion,
+				"annotations": map[string]any{
+					StateAnnotation: value,
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = s.clientset.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, v1.PatchOptions{})
+		return err
+	})
+}